@@ -2,48 +2,222 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/ElyKar/mpm/core"
 	"github.com/spf13/cobra"
 )
 
+// Alphabet and length of the generated password, prompted if not given
+var alphaChoice int
+var passLength int
+
+// Flags of the generator policy, see core.Policy
+var policyClasses []string
+var policyRequire map[string]int
+var policyExclude string
+var policyNoAmbiguous bool
+var policyMaxRun int
+
+// Name of the preset of the configuration to generate the password with, see core.Preset
+var addPreset string
+
+// Number of words of the generated passphrase, 0 to generate it from characters
+var addWords int
+
 // Adds a new password to the master file
 var addCmd = &cobra.Command{
-	Use:   "add --section <section> --name <name>",
+	Use:   "add --section <section> --name <name> [--alphabet <n>] [--length <n>] [--preset <name>] [--force]",
 	Short: "Generates a new password for the section and name",
-	Long:  `Interacts with the user to generate a new password`,
-	Run:   chainNodes(sectionAndNameRequired, storageExists, verifyPassphrase, verifyErase, addFunc, updateStore),
+	Long: `Interacts with the user to generate a new password, unless the alphabet and length are given.
+With the policy flags (--classes, --require, --exclude, --no-ambiguous, --max-run), the password is generated to satisfy the composition rules of a site instead.
+With --preset, the password is generated as described by a preset of the configuration file, --length overriding its length.
+The policy is saved along with the password: generating it again without an alphabet reuses it.
+With --words, a passphrase made of random words is generated instead, see 'mpm gen'.`,
+	Run: chainNodes(sectionAndNameRequired, loadConfig, lockStorage, storageExists, verifyPassphrase, verifyErase, addFunc, setDetails, updateStore),
 }
 
-// addFunc requires the storage and passphrase from the context, and also non-empty name and section
+// addFunc requires the storage, secret key and config from the context, and also non-empty name and section
 func addFunc(context map[string]interface{}) (string, int) {
 	var storage *core.Storage = (context["storage"]).(*core.Storage)
+	key := context["key"].([]byte)
+	config := (context["config"]).(*core.Config)
 
-	// Prompt user for alphabet to choose
-	var choice int
-	if err := chooseAlphabet(&choice); err != nil {
-		return err.Error(), 1
+	alphabets, err := config.AllAlphabets()
+	if err != nil {
+		return fmt.Sprintf("Invalid configuration.\n%s", err), 1
 	}
 
-	// Prompt user for password length
-	length, min, max := 0, 8, 1000
-	interactI("Length of your password:  ", &length)
-	if length < min || length > max {
-		return fmt.Sprintf("Length must be comprised between %d and %d, received %d\n", min, max, length), 1
+	// The details of the entry being replaced, if any, hold its policy
+	details := &core.EntryDetails{}
+	if entry, err := storage.GetEntry(section, name); err == nil {
+		if details, err = storage.DecodeDetails(key, section, name, entry); err != nil {
+			return fmt.Sprintf("Impossible to read the details of your password.\n%s", err), 1
+		}
+	}
+
+	var policy *core.Policy
+	var msg string
+	var code int
+	if addWords == 0 {
+		if policy, msg, code = choosePolicy(config, details.Policy); msg != "" {
+			return msg, code
+		}
+	}
+
+	var password string
+	var strength core.Strength
+	if addWords != 0 {
+		diceware, err := newDiceware(addWords)
+		if err != nil {
+			return err.Error(), 1
+		}
+		if password, err = diceware.Generate(); err != nil {
+			return err.Error(), exitUsage
+		}
+		strength = diceware.Strength()
+	} else if policy != nil {
+		if password, err = policy.Generate(); err != nil {
+			return err.Error(), exitUsage
+		}
+		strength = policy.Strength()
+	} else {
+		choice, length, msg, code := chooseAlphabetAndLength(alphabets)
+		if msg != "" {
+			return msg, code
+		}
+		password = alphabets[choice].GenPassword(length)
+		strength = alphabets[choice].Strength(length)
 	}
+	fmt.Println(strength)
 
-	// Generates, encrypts, encode and save changes
-	password := core.Alphas[choice].GenPassword(length)
-	encoder := core.NewTranscoder(context["passphrase"].(string))
+	// Encrypts, encode and save changes, the policy along with the password
+	encoder := storage.Transcoder(key, section, name)
 	encoded, _ := encoder.EncodePassword(password)
 
-	storage.Set(section, name, string(encoded))
+	if err := storage.Set(section, name, string(encoded)); err != nil {
+		return err.Error(), 1
+	}
+
+	details.Policy = policy
+	entry, _ := storage.GetEntry(section, name)
+	updated := *entry
+	if updated.Details, err = storage.EncodeDetails(key, section, name, details); err != nil {
+		return fmt.Sprintf("Impossible to encrypt the details of your password.\n%s", err), 1
+	}
+	if err = storage.SetEntry(section, name, &updated); err != nil {
+		return err.Error(), 1
+	}
 	return "", 0
 }
 
+// Returns the policy to generate the password with: the one of the preset, the one given by the flags, else the saved one unless an alphabet is given. It returns nil to use an alphabet.
+func choosePolicy(config *core.Config, saved *core.Policy) (*core.Policy, string, int) {
+	custom := policyClasses != nil || len(policyRequire) > 0 || policyExclude != "" || policyNoAmbiguous || policyMaxRun != 0
+	if addPreset != "" {
+		if custom || alphaChoice >= 0 {
+			return nil, "A preset can't be combined with an alphabet or the policy flags", exitUsage
+		}
+
+		policy, err := config.Policy(addPreset)
+		if err != nil {
+			if _, ok := config.Presets[addPreset]; !ok {
+				return nil, fmt.Sprintf("%s, the presets are: %s", err, strings.Join(config.PresetNames(), ", ")), exitUsage
+			}
+			return nil, err.Error(), 1
+		}
+		if passLength != 0 {
+			policy.Length = passLength
+			if err = policy.Validate(); err != nil {
+				return nil, err.Error(), exitUsage
+			}
+		}
+		return policy, "", 0
+	}
+
+	if !custom {
+		if saved == nil || alphaChoice >= 0 {
+			return nil, "", 0
+		}
+
+		policy := *saved
+		if passLength != 0 {
+			policy.Length = passLength
+			if err := policy.Validate(); err != nil {
+				return nil, err.Error(), exitUsage
+			}
+		}
+		return &policy, "", 0
+	}
+
+	if passLength == 0 {
+		return nil, "You need to provide the length of your password with --length !", exitUsage
+	}
+
+	policy := &core.Policy{Length: passLength, Classes: make(map[string]int), Exclude: policyExclude, NoAmbiguous: policyNoAmbiguous, MaxRun: policyMaxRun}
+	classes := policyClasses
+	if classes == nil {
+		classes = core.Classes
+	}
+	for _, class := range classes {
+		policy.Classes[class] = 0
+	}
+	for class, min := range policyRequire {
+		policy.Classes[class] = min
+	}
+
+	if err := policy.Validate(); err != nil {
+		return nil, err.Error(), exitUsage
+	}
+	return policy, "", 0
+}
+
+// Returns the index of the alphabet among the given ones and the length of the password, from the flags or prompted
+func chooseAlphabetAndLength(alphabets []core.Alphabet) (int, int, string, int) {
+	// Prompt user for alphabet to choose
+	choice := alphaChoice
+	if choice < 0 {
+		if nonInteractive {
+			msg, code := needsInput("alphabet", "give it with --alphabet")
+			return 0, 0, msg, code
+		}
+		if err := chooseAlphabet(alphabets, &choice); err != nil {
+			return 0, 0, err.Error(), exitUsage
+		}
+	} else if choice >= len(alphabets) {
+		return 0, 0, fmt.Sprintf("Invalid choice: %d", choice), exitUsage
+	}
+
+	// Prompt user for password length
+	length, min, max := passLength, 8, core.MaxLength
+	if length == 0 {
+		if nonInteractive {
+			msg, code := needsInput("length", "give it with --length")
+			return 0, 0, msg, code
+		}
+		interactI("Length of your password:  ", &length)
+	}
+	if length < min || length > max {
+		return 0, 0, fmt.Sprintf("Length must be comprised between %d and %d, received %d\n", min, max, length), exitUsage
+	}
+
+	return choice, length, "", 0
+}
+
 func init() {
 	addCmd.Flags().StringVar(&section, "section", "", "The section to add the newly-generated password")
 	addCmd.Flags().StringVar(&name, "name", "", "A name for your the newly-generated password")
+	addCmd.Flags().IntVar(&alphaChoice, "alphabet", -1, "The number of the alphabet of the password, as listed when it is prompted")
+	addCmd.Flags().IntVar(&passLength, "length", 0, "The length of the password")
+	addCmd.Flags().StringVar(&addPreset, "preset", "", "The name of the preset of your configuration file to generate the password with")
+	addCmd.Flags().StringSliceVar(&policyClasses, "classes", nil, "The character classes allowed: lower, upper, digit and symbol (all of them by default)")
+	addCmd.Flags().StringToIntVar(&policyRequire, "require", nil, "The minimum number of characters of a class, as class=count, can be repeated")
+	addCmd.Flags().StringVar(&policyExclude, "exclude", "", "Characters which must not appear")
+	addCmd.Flags().BoolVar(&policyNoAmbiguous, "no-ambiguous", false, "Leave out the characters easily mistaken for one another: "+core.AmbiguousChars)
+	addCmd.Flags().IntVar(&policyMaxRun, "max-run", 0, "The maximum number of identical characters in a row")
+	addWordsFlags(addCmd, &addWords, 0, "Generate a passphrase of this number of random words instead")
+	addCmd.Flags().BoolVar(&force, "force", false, "Erase the password if it already exists")
+	addDetailsFlags(addCmd)
 
 	RootCmd.AddCommand(addCmd)
 
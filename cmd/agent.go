@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ElyKar/mpm/core"
+	"github.com/spf13/cobra"
+)
+
+// Flags of the agent command
+var agentTimeout time.Duration
+var agentForeground bool
+
+// Starts the agent in the background, or runs it with --foreground
+var agentCmd = &cobra.Command{
+	Use:   "agent [--timeout <duration>] [--foreground]",
+	Short: "Keep your secret key in memory for a while",
+	Long: `Starts an agent in the background, which keeps the secret key of a vault in memory once you entered its passphrase.
+The commands ask the agent first, so you don't have to type your passphrase again until the timeout expires or you run 'mpm lock'.
+The agent listens on a socket only accessible to you, given by $` + core.AgentEnv + ` if set. Changing your passphrase always asks for the current one.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run:              chainNodes(agentFunc),
+}
+
+// Node starting the agent: it runs itself again in a new session, then waits for the agent to listen
+func agentFunc(context map[string]interface{}) (string, int) {
+	path := core.AgentSocketPath()
+
+	if !core.AgentSupported {
+		return core.ErrAgentUnsupported.Error(), 1
+	}
+
+	if agentForeground {
+		return serveAgent(path)
+	}
+
+	if core.AgentRunning() {
+		return (&core.AgentRunningError{Path: path}).Error(), 0
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Sprintf("Impossible to start the agent.\n%s", err), 1
+	}
+
+	process := exec.Command(executable, "agent", "--foreground", "--timeout", agentTimeout.String())
+	process.SysProcAttr = detachedAttr()
+	if err = process.Start(); err != nil {
+		return fmt.Sprintf("Impossible to start the agent.\n%s", err), 1
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- process.Wait() }()
+
+	for i := 0; i < 50; i++ {
+		select {
+		case err = <-exited:
+			return fmt.Sprintf("The agent stopped right away (%v), run 'mpm agent --foreground' to see why.", err), 1
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		if core.AgentRunning() {
+			return fmt.Sprintf("The agent is running on %s, it keeps your secret key for %s after you enter your passphrase.", path, agentTimeout), 0
+		}
+	}
+
+	return "The agent did not start in time, run 'mpm agent --foreground' to see why.", 1
+}
+
+// Runs the agent until it is interrupted
+func serveAgent(path string) (string, int) {
+	agent := core.NewAgent(agentTimeout)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		agent.Close()
+	}()
+
+	fmt.Printf("Listening on %s\n", path)
+	if err := agent.Serve(path); err != nil {
+		return fmt.Sprintf("The agent stopped.\n%s", err), 1
+	}
+
+	return "", 0
+}
+
+// Wipes the keys held by the agent
+var lockCmd = &cobra.Command{
+	Use:              "lock",
+	Short:            "Make the agent forget your secret key",
+	Long:             `Wipes all of the secret keys held by the agent right away. The agent keeps running, your passphrase will be asked again by the next command.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run:              chainNodes(lockFunc),
+}
+
+// Node asking the agent to wipe its keys
+func lockFunc(context map[string]interface{}) (string, int) {
+	if err := core.AgentLock(); err != nil {
+		return "No agent is running, there is nothing to forget.", 0
+	}
+
+	return "The agent forgot your secret key.", 0
+}
+
+func init() {
+	agentCmd.Flags().DurationVar(&agentTimeout, "timeout", 15*time.Minute, "How long the secret key is kept after you enter your passphrase, 0 to keep it until 'mpm lock'")
+	agentCmd.Flags().BoolVar(&agentForeground, "foreground", false, "Run the agent in the foreground")
+
+	RootCmd.AddCommand(agentCmd)
+	RootCmd.AddCommand(lockCmd)
+}
@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/ElyKar/mpm/core"
+	"github.com/spf13/cobra"
+)
+
+// Number of days after which a password is stale, 0 to never consider them stale
+var maxAge int
+
+// Audits the whole storage
+var auditCmd = &cobra.Command{
+	Use:   "audit [--max-age <days>] [--output <format>]",
+	Short: "Report the reused, weak and stale passwords",
+	Long: `Decrypts all the passwords of your storage and reports the ones reused by several entries, the weak ones (see 'mpm strength'), the ones not changed for longer than --max-age days, and the entries still using the legacy encryption.
+With --output json, yaml or tsv, the report can be kept to follow the health of your storage over time.`,
+	Run: chainNodes(outputValid, storageExists, verifyPassphrase, auditFunc),
+}
+
+// Node auditing the storage. It requires the storage and secret key from the context.
+func auditFunc(context map[string]interface{}) (string, int) {
+	storage := (context["storage"]).(*core.Storage)
+	key := (context["key"]).([]byte)
+
+	if maxAge < 0 {
+		return fmt.Sprintf("The maximum age can't be negative, received %d", maxAge), exitUsage
+	}
+
+	audit, err := storage.Audit(key, time.Duration(maxAge)*24*time.Hour)
+	if err != nil {
+		return fmt.Sprintf("Impossible to audit your storage.\n%s", err), 1
+	}
+
+	if output != outputText {
+		printOutput(auditRecord(audit), auditRows(audit))
+		return "", 0
+	}
+
+	fmt.Printf("Audited %d passwords on %s\n", audit.Entries, audit.Date.Local().Format("2006-01-02"))
+	fmt.Printf("Key derivation: %s\n", audit.KDF)
+	if audit.KDF == nil {
+		fmt.Println("    Your key is derived with the legacy scheme, upgrade it with 'mpm kdf tune --apply'.")
+	}
+	if !audit.Sealed {
+		fmt.Println("    Your storage has no integrity protection yet, it will be added the next time it is written.")
+	}
+
+	if len(audit.Reused) > 0 {
+		fmt.Printf("\nReused passwords (%d):\n", len(audit.Reused))
+		for _, refs := range audit.Reused {
+			fmt.Print("    -")
+			for i, ref := range refs {
+				if i > 0 {
+					fmt.Print(",")
+				}
+				fmt.Printf(" %s/%s", ref.Section, ref.Name)
+			}
+			fmt.Println()
+		}
+	}
+
+	if len(audit.Weak) > 0 {
+		fmt.Printf("\nWeak passwords (%d):\n", len(audit.Weak))
+		for _, weak := range audit.Weak {
+			fmt.Printf("    - %s/%s: %s\n", weak.Section, weak.Name, weak.Strength)
+		}
+	}
+
+	if len(audit.Stale) > 0 {
+		fmt.Printf("\nPasswords older than %d days (%d):\n", maxAge, len(audit.Stale))
+		for _, stale := range audit.Stale {
+			fmt.Printf("    - %s/%s: last changed %s\n", stale.Section, stale.Name, formatDate(stale.Modified))
+		}
+	}
+
+	if len(audit.Legacy) > 0 {
+		fmt.Printf("\nUsing the legacy encryption (%d), upgraded the next time your storage is written:\n", len(audit.Legacy))
+		for _, ref := range audit.Legacy {
+			fmt.Printf("    - %s/%s\n", ref.Section, ref.Name)
+		}
+	}
+
+	if len(audit.Tampered) > 0 {
+		fmt.Printf("\nTampered with, they can't be decrypted (%d):\n", len(audit.Tampered))
+		for _, ref := range audit.Tampered {
+			fmt.Printf("    - %s/%s\n", ref.Section, ref.Name)
+		}
+	}
+
+	if issues := audit.Issues(); issues > 0 {
+		return fmt.Sprintf("\n%d issues found.", issues), 0
+	}
+	return "No issue found.", 0
+}
+
+// The entries as records, for the json and yaml formats
+func refRecords(refs []core.EntryRef) []record {
+	records := make([]record, len(refs))
+	for i, ref := range refs {
+		records[i] = record{{"section", ref.Section}, {"name", ref.Name}}
+	}
+	return records
+}
+
+// The audit as a record, for the json and yaml formats
+func auditRecord(audit *core.Audit) record {
+	reused := make([]record, len(audit.Reused))
+	for i, refs := range audit.Reused {
+		reused[i] = record{{"entries", refRecords(refs)}}
+	}
+
+	weak := make([]record, len(audit.Weak))
+	for i, w := range audit.Weak {
+		patterns := w.Strength.Patterns
+		if patterns == nil {
+			patterns = []string{}
+		}
+		weak[i] = record{{"section", w.Section}, {"name", w.Name}, {"entropy", math.Round(w.Strength.Entropy*10) / 10}, {"level", w.Strength.Level()}, {"patterns", patterns}}
+	}
+
+	stale := make([]record, len(audit.Stale))
+	for i, s := range audit.Stale {
+		stale[i] = record{{"section", s.Section}, {"name", s.Name}, {"modified", outputDate(s.Modified)}}
+	}
+
+	return record{
+		{"date", outputDate(audit.Date)},
+		{"entries", audit.Entries},
+		{"kdf", audit.KDF.String()},
+		{"legacy_kdf", audit.KDF == nil},
+		{"sealed", audit.Sealed},
+		{"issues", audit.Issues()},
+		{"max_age_days", maxAge},
+		{"reused", reused},
+		{"weak", weak},
+		{"stale", stale},
+		{"legacy", refRecords(audit.Legacy)},
+		{"tampered", refRecords(audit.Tampered)},
+	}
+}
+
+// The audit as rows, for the tsv format: one row per finding, starting with its kind. The reused passwords are numbered, to tell their groups apart.
+func auditRows(audit *core.Audit) [][]string {
+	var rows [][]string
+	for i, refs := range audit.Reused {
+		for _, ref := range refs {
+			rows = append(rows, []string{"reused", ref.Section, ref.Name, fmt.Sprint(i + 1)})
+		}
+	}
+	for _, w := range audit.Weak {
+		rows = append(rows, []string{"weak", w.Section, w.Name, fmt.Sprintf("%.1f", w.Strength.Entropy)})
+	}
+	for _, s := range audit.Stale {
+		modified, _ := outputDate(s.Modified).(string)
+		rows = append(rows, []string{"stale", s.Section, s.Name, modified})
+	}
+	for _, ref := range audit.Legacy {
+		rows = append(rows, []string{"legacy", ref.Section, ref.Name, ""})
+	}
+	for _, ref := range audit.Tampered {
+		rows = append(rows, []string{"tampered", ref.Section, ref.Name, ""})
+	}
+
+	return rows
+}
+
+func init() {
+	auditCmd.Flags().IntVar(&maxAge, "max-age", 365, "The number of days after which a password should be changed, 0 to never consider them too old")
+	addOutputFlag(auditCmd)
+
+	RootCmd.AddCommand(auditCmd)
+}
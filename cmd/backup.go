@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/ElyKar/mpm/core"
+	"github.com/spf13/cobra"
+)
+
+// Root backup command
+var backupCmd = &cobra.Command{
+	Use:   "backup [list|restore]",
+	Short: "List and restore the backups of your storage",
+	Long: `Each time your storage is written, its previous version is kept as a backup next to it.
+The most recent backup is number 1, the oldest ones are dropped.`,
+}
+
+// Lists the backups
+var backupListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the backups of your storage",
+	Run:   chainNodes(backupListFunc),
+}
+
+// Node listing the backups, with the number of sections and passwords of each one. Nothing is decrypted.
+func backupListFunc(context map[string]interface{}) (string, int) {
+	backups, err := core.ListBackups()
+	if err != nil {
+		return fmt.Sprintf("Impossible to list your backups.\n%s", err), 1
+	}
+
+	if len(backups) == 0 {
+		return "There is no backup yet.", 0
+	}
+
+	fmt.Println("Here are the backups stored:")
+	for _, backup := range backups {
+		fmt.Printf("    [%d]  %s", backup.Generation, backup.ModTime.Format("2006-01-02 15:04:05"))
+
+		if storage, err := core.GetBackup(backup.Generation); err != nil {
+			fmt.Printf("  unreadable: %s\n", err)
+		} else {
+			entries := 0
+			for _, sec := range storage.Sections {
+				entries += len(sec)
+			}
+			fmt.Printf("  %d section(s), %d password(s)\n", len(storage.Sections), entries)
+		}
+	}
+
+	return "", 0
+}
+
+// Restores a backup, the current storage becomes the most recent backup
+var backupRestoreCmd = &cobra.Command{
+	Use:   "restore <n>",
+	Short: "Restore a backup of your storage",
+	Long: `Replaces your storage with one of its backups, listed by 'mpm backup list'.
+You need the passphrase of the backup, which is checked against the backup itself: this works even if your current storage is damaged.
+Your current storage is kept as the most recent backup, so restoring can be undone.`,
+	Args: cobra.ExactArgs(1),
+	Run: chainNodes(lockStorage, backupExists, verifyPassphrase, confirm(func(context map[string]interface{}) string {
+		return fmt.Sprintf("Are you sure you want to replace your storage with backup %d ?", context["generation"])
+	}), updateStore),
+}
+
+// Node reading the backup given as argument. On success, it stores the backup as the storage in the context, along with its generation.
+func backupExists(context map[string]interface{}) (string, int) {
+	args := (context["args"]).([]string)
+
+	generation, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Sprintf("Invalid backup number: %s", args[0]), exitUsage
+	}
+
+	storage, err := core.GetBackup(generation)
+	if tamperErr, ok := err.(*core.TamperError); ok {
+		return tamperMessage(tamperErr), exitTampered
+	} else if err != nil {
+		return fmt.Sprintf("Impossible to read backup %d.\n%s", generation, err), exitNotFound
+	}
+
+	context["storage"] = storage
+	context["generation"] = generation
+	return "", 0
+}
+
+func init() {
+	backupRestoreCmd.Flags().BoolVar(&force, "force", false, "Do not ask for confirmation")
+	backupCmd.AddCommand(backupListCmd)
+	backupCmd.AddCommand(backupRestoreCmd)
+
+	RootCmd.AddCommand(backupCmd)
+}
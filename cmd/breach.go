@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ElyKar/mpm/core"
+	"github.com/spf13/cobra"
+)
+
+// Breached passwords to look into, the text file of Have I Been Pwned or an index built from it
+var breachDB string
+
+// Where to write the index of the breached passwords
+var breachIndexPath string
+
+// Looks for the stored passwords in the breaches
+var breachCheckCmd = &cobra.Command{
+	Use:   "breach-check --db <file> [--output <format>]",
+	Short: "Look for your passwords in the breaches of Have I Been Pwned",
+	Long: `Looks for all your passwords in the Pwned Passwords of Have I Been Pwned, without any network access: download the SHA-1 hashes ordered by hash from https://haveibeenpwned.com/Passwords and give the file to --db.
+The file is searched by bisection, it is not loaded in memory. 'mpm breach-check index' builds a more compact index from it, which --db accepts too.`,
+	Run: chainNodes(breachDBRequired, outputValid, openBreachDB, storageExists, verifyPassphrase, breachCheckFunc),
+}
+
+// Builds the index of the breached passwords
+var breachIndexCmd = &cobra.Command{
+	Use:              "index --db <file> --out <index>",
+	Short:            "Build a compact index of the breached passwords",
+	Long:             `Converts the text file of Have I Been Pwned, ordered by hash, into an index about 3 times smaller which 'mpm breach-check --db' searches as well.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run:              chainNodes(breachDBRequired, breachIndexFunc),
+}
+
+// Node asserting the breached passwords were given
+func breachDBRequired(context map[string]interface{}) (string, int) {
+	if breachDB == "" {
+		return "You need to provide the file of the breached passwords with --db !", exitUsage
+	}
+
+	return "", 0
+}
+
+// Node opening the breached passwords given by --db. On success, they are stored in the context under 'breach'.
+func openBreachDB(context map[string]interface{}) (string, int) {
+	db, err := core.OpenBreachDB(breachDB)
+	if err != nil {
+		return fmt.Sprintf("Impossible to open the breached passwords.\n%s", err), 1
+	}
+
+	context["breach"] = db
+	return "", 0
+}
+
+// Node looking for the stored passwords in the breaches. It requires the storage, secret key and breached passwords from the context.
+func breachCheckFunc(context map[string]interface{}) (string, int) {
+	storage := (context["storage"]).(*core.Storage)
+	key := (context["key"]).([]byte)
+	db := (context["breach"]).(core.BreachDB)
+	defer db.Close()
+
+	report, err := storage.Breached(key, db)
+	if err != nil {
+		return fmt.Sprintf("Impossible to check your passwords.\n%s", err), 1
+	}
+
+	if output != outputText {
+		records := make([]record, len(report.Breached))
+		rows := make([][]string, len(report.Breached))
+		for i, entry := range report.Breached {
+			records[i] = record{{"section", entry.Section}, {"name", entry.Name}, {"count", entry.Count}}
+			rows[i] = []string{entry.Section, entry.Name, fmt.Sprint(entry.Count)}
+		}
+		printOutput(record{{"checked", report.Checked}, {"breached", records}, {"tampered", refRecords(report.Tampered)}}, rows)
+		if output == outputTSV && len(report.Tampered) > 0 {
+			fmt.Fprintf(os.Stderr, "%d passwords can't be decrypted, they have been tampered with.\n", len(report.Tampered))
+		}
+		return "", 0
+	}
+
+	if len(report.Tampered) > 0 {
+		fmt.Printf("Tampered with, they can't be decrypted (%d):\n", len(report.Tampered))
+		for _, ref := range report.Tampered {
+			fmt.Printf("    - %s/%s\n", ref.Section, ref.Name)
+		}
+		fmt.Println()
+	}
+
+	if len(report.Breached) == 0 {
+		return fmt.Sprintf("None of your %d passwords appears in the breaches.", report.Checked), 0
+	}
+
+	fmt.Printf("Passwords found in the breaches (%d out of %d):\n", len(report.Breached), report.Checked)
+	for _, entry := range report.Breached {
+		fmt.Printf("    - %s/%s: seen %d times\n", entry.Section, entry.Name, entry.Count)
+	}
+	return "\nChange them, attackers try these passwords first.", 0
+}
+
+// Node building the index of the breached passwords
+func breachIndexFunc(context map[string]interface{}) (string, int) {
+	if breachIndexPath == "" {
+		return "You need to provide the path of the index with --out !", exitUsage
+	}
+
+	fmt.Fprintln(os.Stderr, "Building the index, this can take a while...")
+	count, err := core.BuildBreachIndex(breachDB, breachIndexPath)
+	if err != nil {
+		return fmt.Sprintf("Impossible to build the index.\n%s", err), 1
+	}
+
+	return fmt.Sprintf("Indexed %d passwords in %s", count, breachIndexPath), 0
+}
+
+// Node looking for the imported password in the breaches, if --breach-db was given. The user must confirm the import of a breached password. It needs the new password from the context.
+func importBreachCheck(context map[string]interface{}) (string, int) {
+	if breachDB == "" {
+		return "", 0
+	}
+
+	db, err := core.OpenBreachDB(breachDB)
+	if err != nil {
+		return fmt.Sprintf("Impossible to open the breached passwords.\n%s", err), 1
+	}
+	defer db.Close()
+
+	count, err := db.Count(context["newPass"].([]byte))
+	if err != nil {
+		return fmt.Sprintf("Impossible to check your password.\n%s", err), 1
+	}
+	if count == 0 {
+		fmt.Println("This password does not appear in the breaches.")
+		return "", 0
+	}
+
+	return confirmed(fmt.Sprintf("This password appears %d times in the breaches, attackers try it first. Import it anyway ?", count))
+}
+
+func init() {
+	breachCheckCmd.Flags().StringVar(&breachDB, "db", "", "The SHA-1 hashes of Have I Been Pwned ordered by hash, or an index built from them")
+	addOutputFlag(breachCheckCmd)
+	breachIndexCmd.Flags().StringVar(&breachDB, "db", "", "The SHA-1 hashes of Have I Been Pwned ordered by hash")
+	breachIndexCmd.Flags().StringVar(&breachIndexPath, "out", "", "Where to write the index")
+	breachCheckCmd.AddCommand(breachIndexCmd)
+
+	RootCmd.AddCommand(breachCheckCmd)
+}
@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"fmt"
+
 	"github.com/ElyKar/mpm/core"
 	"github.com/spf13/cobra"
 )
@@ -9,7 +11,7 @@ import (
 var changeCmd = &cobra.Command{
 	Use:   "change",
 	Short: "Change the master password",
-	Run:   chainNodes(storageExists, verifyPassphrase, createPassphrase, changeFunc, updateStore),
+	Run:   chainNodes(lockStorage, storageExists, askPassphrase, createPassphrase, changeFunc, updateStore),
 }
 
 // changeFunc requires the storage, current passphrase and new passphrase to be stored in the context
@@ -18,7 +20,18 @@ func changeFunc(context map[string]interface{}) (string, int) {
 	new := string((context["newPass"]).([]byte))
 	var storage *core.Storage = (context["storage"]).(*core.Storage)
 
-	storage.SetNewPassphrase(old, new)
+	if err := storage.SetNewPassphrase(old, new); err != nil {
+		return fmt.Sprintf("Your passphrase hasn't been changed.\n%s", err), 1
+	}
+
+	// From now on, the storage is encrypted with the new passphrase
+	key, err := storage.DeriveKey(new)
+	if err != nil {
+		return fmt.Sprintf("Impossible to derive your new secret key.\n%s", err), 1
+	}
+
+	context["passphrase"] = new
+	context["key"] = key
 	return "", 0
 }
 
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/atotto/clipboard"
+	"github.com/spf13/cobra"
+)
+
+// Flags of the commands copying a secret to the clipboard
+var clipboardTimeout time.Duration
+var noClear bool
+
+// Registers the flags clearing the clipboard on a command
+func addClipboardFlags(cmd *cobra.Command) {
+	cmd.Flags().DurationVar(&clipboardTimeout, "timeout", 45*time.Second, "How long the secret stays in your clipboard")
+	cmd.Flags().BoolVar(&noClear, "no-clear", false, "Leave the secret in your clipboard")
+}
+
+// Copies a secret to the clipboard then, unless --no-clear is given, starts a helper in the background which clears it after the timeout. The helper only gets a hash of the secret, on its standard input.
+func copyToClipboard(secret string) error {
+	if err := clipboard.WriteAll(secret); err != nil {
+		return err
+	}
+
+	if noClear {
+		return nil
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	process := exec.Command(executable, "clear-clipboard", "--timeout", clipboardTimeout.String())
+	process.SysProcAttr = detachedAttr()
+	stdin, err := process.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	if err = process.Start(); err != nil {
+		return err
+	}
+
+	// The helper outlives this command, it is not waited for
+	_, err = io.WriteString(stdin, hashSecret(secret))
+	stdin.Close()
+	process.Process.Release()
+	return err
+}
+
+// Hashes a secret, to recognise it in the clipboard without keeping it
+func hashSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// Clipboard-copying commands report how long the secret stays there
+func clipboardMessage(what string) string {
+	if noClear {
+		return fmt.Sprintf("Your %s has been successfully copied to your clipboard", what)
+	}
+
+	return fmt.Sprintf("Your %s has been successfully copied to your clipboard, it will be cleared in %s", what, clipboardTimeout)
+}
+
+// Internal command started by copyToClipboard: it reads the hash of the secret on its standard input, waits, then clears the clipboard if it still contains the secret.
+var clearClipboardCmd = &cobra.Command{
+	Use:              "clear-clipboard --timeout <duration>",
+	Hidden:           true,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run:              chainNodes(clearClipboardFunc),
+}
+
+// Node clearing the clipboard, see clearClipboardCmd. It runs in the background, there is nobody to read its messages.
+func clearClipboardFunc(context map[string]interface{}) (string, int) {
+	hash, err := io.ReadAll(io.LimitReader(os.Stdin, sha256.Size*2))
+	if err != nil {
+		return "", 1
+	}
+
+	time.Sleep(clipboardTimeout)
+
+	current, err := clipboard.ReadAll()
+	if err != nil || hashSecret(current) != strings.TrimSpace(string(hash)) {
+		return "", 0
+	}
+
+	if err = clipboard.WriteAll(""); err != nil {
+		return "", 1
+	}
+	return "", 0
+}
+
+func init() {
+	clearClipboardCmd.Flags().DurationVar(&clipboardTimeout, "timeout", 45*time.Second, "How long to wait before clearing the clipboard")
+
+	RootCmd.AddCommand(clearClipboardCmd)
+}
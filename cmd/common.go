@@ -19,72 +19,266 @@ var section string
 // Name of the password inside the section
 var name string
 
+// Skips the confirmation prompts, for the commands providing a --force flag
+var force bool
+
 // Node asserting the section and name exist and are not empty
 func sectionAndNameRequired(context map[string]interface{}) (string, int) {
 	if section == "" || name == "" {
-		return "You need to provide a section and a name for your entry !", 1
+		return "You need to provide a section and a name for your entry !", exitUsage
+	}
+
+	return "", 0
+}
+
+// Node asserting the section flag is not empty
+func sectionRequired(context map[string]interface{}) (string, int) {
+	if section == "" {
+		return "You need to provide a section !", exitUsage
+	}
+
+	return "", 0
+}
+
+// Node asserting the password given by the section and name flags exists in the storage. Storage is required from the context
+func passwordExists(context map[string]interface{}) (string, int) {
+	storage := context["storage"].(*core.Storage)
+	if _, err := storage.Get(section, name); err != nil {
+		return err.Error(), exitNotFound
 	}
 
 	return "", 0
 }
 
+// Node asserting the section given by the section flag exists in the storage. Storage is required from the context
+func sectionExists(context map[string]interface{}) (string, int) {
+	storage := context["storage"].(*core.Storage)
+	if !storage.HasSection(section) {
+		return fmt.Sprintf("Section %s does not exists", section), exitNotFound
+	}
+
+	return "", 0
+}
+
+// Node locking the storage for the commands modifying it, it must come before reading the storage. On success, it stores the lock in the context, chainNodes releases it.
+func lockStorage(context map[string]interface{}) (string, int) {
+	lock, err := core.LockStorage()
+	if lockedErr, ok := err.(*core.LockedError); ok {
+		return fmt.Sprintf("%s\nWait for it to finish, then try again.", lockedErr), exitBusy
+	} else if err != nil {
+		return fmt.Sprintf("Impossible to lock your storage.\n%s", err), 1
+	}
+
+	context["lock"] = lock
+	return "", 0
+}
+
 // Node asserting the storage exists and is valid. On success, it stores the storage in the context.
 func storageExists(context map[string]interface{}) (string, int) {
 
-	if storage, err := core.GetStorage(); err != nil {
+	storage, err := core.GetStorage()
+	if tamperErr, ok := err.(*core.TamperError); ok {
+		return tamperMessage(tamperErr), exitTampered
+	} else if err != nil {
 		return fmt.Sprintf(`No previous storage found, error is:
 %s
 
 Are you sure the file is created and you have proper access rights ?
 You can initialize your mpm file with the command 'mpm init'
-	`, err.Error()), 1
-	} else {
-		context["storage"] = storage
-		return "", 0
+	`, err.Error()), exitNotFound
 	}
+
+	context["storage"] = storage
+	return "", 0
+}
+
+// Message displayed when the storage failed its integrity check
+func tamperMessage(err *core.TamperError) string {
+	return fmt.Sprintf(`%s
+
+Your storage has been modified outside of mpm, nothing has been read from it.
+If you did not edit it yourself, restore it from a copy you trust.`, err)
 }
 
-// Prompts the user for the passphrase and verifies it. On success, it stores the passphrase in the context (will be used for encoding/decoding)
+// Gets the secret key from the agent started by the user if it holds it, else prompts for the passphrase (see askPassphrase). On success, the secret key is stored in the context (will be used for encoding/decoding), the passphrase only if it was prompted.
 func verifyPassphrase(context map[string]interface{}) (string, int) {
 	var storage *core.Storage = (context["storage"]).(*core.Storage)
+
+	if key, ok := agentKey(storage); ok {
+		context["key"] = key
+		return "", 0
+	}
+
+	return askPassphrase(context)
+}
+
+// Gets the secret key of the storage from the agent started by the user, and unlocks the storage with it. The key is verified by the MAC of the storage, which older storages don't have yet.
+func agentKey(storage *core.Storage) ([]byte, bool) {
+	if !storage.Sealed() || !core.AgentStarted() {
+		return nil, false
+	}
+
+	key, err := core.AgentKey(core.VaultPath())
+	if err != nil || key == nil || storage.Unlock(key) != nil {
+		return nil, false
+	}
+	return key, true
+}
+
+// Prompts the user for the passphrase and verifies it, then checks the integrity of the storage and unlocks it. On success, it stores the passphrase and the secret key derived from it in the context, and gives the key to the agent if the user started one (see core.AgentStarted). For the commands which need the passphrase itself.
+// A passphrase given without prompting (see givenPassphrase) is only tried once.
+func askPassphrase(context map[string]interface{}) (string, int) {
+	var storage *core.Storage = (context["storage"]).(*core.Storage)
 	success := false
-	var passphrase string
+
+	passphrase, given, err := givenPassphrase()
+	if err != nil {
+		return fmt.Sprintf("Impossible to read your passphrase.\n%s", err), exitFailure
+	} else if given {
+		if storage.CheckPassphrase(passphrase) != nil {
+			return "Wrong passphrase", exitWrongPassphrase
+		}
+		success = true
+	} else if nonInteractive {
+		return needsInput("passphrase", "give it with --passphrase-fd, --passphrase-stdin or $"+PassphraseEnv)
+	}
+
 	for i := 0; i < 3 && !success; i++ {
 
-		fmt.Printf("Enter your passphrase: ")
-		passB, _ := gopass.GetPasswd()
+		// Prompted on the error output, which keeps the output of the command clean
+		passB, _ := gopass.GetPasswdPrompt("Enter your passphrase: ", false, os.Stdin, os.Stderr)
 		passphrase = string(passB)
 
 		if err := storage.CheckPassphrase(passphrase); err != nil {
-			fmt.Printf("Wrong passphrase\n\n")
+			fmt.Fprintf(os.Stderr, "Wrong passphrase\n\n")
 		} else {
 			success = true
 		}
 	}
 
 	if !success {
-		return "Try again later !", 1
-	} else {
-		context["passphrase"] = passphrase
-		return "", 0
+		return "Try again later !", exitWrongPassphrase
+	}
+
+	key, err := storage.DeriveKey(passphrase)
+	if err != nil {
+		return fmt.Sprintf("Impossible to derive your secret key.\n%s", err), 1
 	}
+
+	if err = storage.Unlock(key); err != nil {
+		if tamperErr, ok := err.(*core.TamperError); ok {
+			return tamperMessage(tamperErr), exitTampered
+		}
+		return fmt.Sprintf("Impossible to verify your storage.\n%s", err), 1
+	}
+
+	// The key is only given to the agent the user started with 'mpm agent'
+	if core.AgentStarted() {
+		core.AgentAddKey(core.VaultPath(), key)
+	}
+
+	context["passphrase"] = passphrase
+	context["key"] = key
+	return "", 0
 }
 
-// Updates the storage on the disk. It retrieves the storage from the context, and tries to dump it on the disk.
+// Updates the storage on the disk. It retrieves the storage and the secret key from the context, upgrades the passwords still using the legacy encryption, seals the storage and tries to dump it on the disk. The new version is then recorded for the synchronization, see commitSync.
+// A storage still using the legacy key derivation is migrated to argon2id before it is sealed (see migrateKDF), so that its MAC can't be stripped.
 func updateStore(context map[string]interface{}) (string, int) {
 	var storage *core.Storage = (context["storage"]).(*core.Storage)
 
+	if storage.KDF == nil {
+		if msg, code := migrateKDF(context); msg != "" || code != 0 {
+			return msg, code
+		}
+	}
+	var key []byte = (context["key"]).([]byte)
+
+	if _, err := storage.Upgrade(key); err != nil {
+		return fmt.Sprintf("Something went wrong while upgrading your passwords, your changes haven't been saved.\n%s", err), 1
+	}
+
+	if err := storage.Seal(key); err != nil {
+		return fmt.Sprintf("Something went wrong while sealing your storage, your changes haven't been saved.\n%s", err), 1
+	}
+
 	if err := storage.DumpOnDisk(); err != nil {
+		if conflictErr, ok := err.(*core.ConflictError); ok {
+			return fmt.Sprintf(`%s
+
+Another program wrote your storage while this command was running, probably an older version of mpm which does not lock it.
+Your changes haven't been saved, so that theirs are not lost. Run your command again.`, conflictErr), exitBusy
+		}
 		return fmt.Sprintf("Something went wrong, your changes haven't been saved. Try again later !\n%s", err), 1
 	}
+	commitSync(context)
 
 	return "\nEverything went well !", 0
 }
 
+// Re-encrypts a storage still using the legacy key derivation with a key derived by argon2id, which needs the passphrase: it is asked if it is not in the context yet. The new secret key replaces the previous one in the context.
+// A sealed storage always has a key derivation function, whose parameters derive its key: removing the MAC of a sealed storage is detected without the key (see core.Storage.Verify), and removing both leaves a storage that can't be decrypted.
+func migrateKDF(context map[string]interface{}) (string, int) {
+	storage := (context["storage"]).(*core.Storage)
+
+	if _, ok := (context["passphrase"]).(string); !ok {
+		if msg, code := askPassphrase(context); msg != "" || code != 0 {
+			return msg, code
+		}
+	}
+	passphrase := (context["passphrase"]).(string)
+
+	kdf, err := core.NewKDF(core.KDFArgon2id)
+	if err == nil {
+		err = storage.SetKDF(passphrase, kdf)
+	}
+	if err != nil {
+		return fmt.Sprintf("Something went wrong while migrating your key derivation, your changes haven't been saved.\n%s", err), 1
+	}
+
+	key, err := storage.DeriveKey(passphrase)
+	if err != nil {
+		return fmt.Sprintf("Impossible to derive your secret key.\n%s", err), 1
+	}
+	context["key"] = key
+	return "", 0
+}
+
+// Node unlocking the storage for the commands which don't need the passphrase otherwise. If the names are encrypted, the passphrase is needed to read them (see verifyPassphrase). Clear names can be read without it: the integrity of the storage is still checked if the key is at hand without prompting, given by the agent or a passphrase given to mpm, otherwise the user is told it was not. Storage is required from the context
+func unlockNames(context map[string]interface{}) (string, int) {
+	storage := context["storage"].(*core.Storage)
+	if storage.EncryptedNames {
+		return verifyPassphrase(context)
+	}
+	if !storage.Sealed() {
+		return "", 0
+	}
+
+	if key, ok := agentKey(storage); ok {
+		context["key"] = key
+		return "", 0
+	}
+	if _, ok := os.LookupEnv(PassphraseEnv); ok || passphraseFd >= 0 || passphraseStdin {
+		return askPassphrase(context)
+	}
+
+	fmt.Fprintln(os.Stderr, "The integrity of your storage has not been checked, as your passphrase was not asked: run 'mpm verify' to check it.")
+	return "", 0
+}
+
 // Prompts for a new passphrase or password, depending on the dialog provided. On success, it is stored on the context under 'newPass'.
-// The argument should contain the two text messages two display, then an error message to throw in case of mismatch
-func createPass(dialogs [3]string) nodeFunc {
+// The argument should contain the two text messages two display, then an error message to throw in case of mismatch. If the source gives the value, nothing is prompted: the last argument tells what the value is and how to give it, for the non-interactive mode.
+func createPass(dialogs [3]string, source func() (string, bool, error), missing [2]string) nodeFunc {
 	return func(context map[string]interface{}) (string, int) {
+		if pass, given, err := source(); err != nil {
+			return fmt.Sprintf("An error occurred !\n%s", err), exitFailure
+		} else if given {
+			context["newPass"] = []byte(pass)
+			return "", 0
+		} else if nonInteractive {
+			return needsInput(missing[0], missing[1])
+		}
+
 		fmt.Printf(dialogs[0])
 		pass1, err := gopass.GetPasswd()
 		if err != nil {
@@ -107,38 +301,80 @@ func createPass(dialogs [3]string) nodeFunc {
 }
 
 // Ugly, but this is the closest that Go can provide from funtional's partial execution
-var createPassphrase nodeFunc = createPass([3]string{"Enter your new passphrase: ", "Re-enter your passphrase: ", "Passphrases mismatch !"})
-var createPassword nodeFunc = createPass([3]string{"Enter your password: ", "Re-enter your password: ", "Passwords mismatch !"})
+var createPassphrase nodeFunc = createPass([3]string{"Enter your new passphrase: ", "Re-enter your passphrase: ", "Passphrases mismatch !"}, givenNewPassphrase, [2]string{"new passphrase", "give it with --new-passphrase-fd or $" + NewPassphraseEnv})
+var createPassword nodeFunc = createPass([3]string{"Enter your password: ", "Re-enter your password: ", "Passwords mismatch !"}, givenPassword, [2]string{"password", "give it on the standard input"})
 
-// verifyErase prompts the user if a password already exists for the provided section and name. If it exists, the user is prompted if he wants to erase it or not. Storage is required from the context
-func verifyErase(context map[string]interface{}) (string, int) {
-	msg := ""
-	storage := context["storage"].(*core.Storage)
-	if _, err := storage.Get(section, name); err == nil {
-		var answer string
-		interactS("Password exist, are you sure you want to erase it ? [y/n]\n", &answer)
-		if answer != "y" {
-			msg = "Ok, goodbye"
+// The first passphrase of a storage may be given like the new one, or like the current one since there is none
+var createFirstPassphrase nodeFunc = createPass([3]string{"Enter your new passphrase: ", "Re-enter your passphrase: ", "Passphrases mismatch !"}, func() (string, bool, error) {
+	if passphrase, given, err := givenNewPassphrase(); given || err != nil {
+		return passphrase, given, err
+	}
+	return givenPassphrase()
+}, [2]string{"passphrase", "give it with --passphrase-fd, --passphrase-stdin or $" + PassphraseEnv})
+
+// verifyErase prompts the user if a password already exists for the provided section and name. If it exists, the user is prompted if he wants to erase it or not, unless --force was given. Storage is required from the context
+var verifyErase nodeFunc = verifyEraseAt(&section, &name, "Password exist, are you sure you want to erase it ?")
+
+// Creates a node prompting the user with the question if a password already exists at the given section and name, read when the node runs since earlier nodes may fill them in. On confirmation, it stores 'overwrite' in the context. Storage is required from the context
+func verifyEraseAt(section *string, name *string, question string) nodeFunc {
+	return func(context map[string]interface{}) (string, int) {
+		storage := context["storage"].(*core.Storage)
+		if _, err := storage.Get(*section, *name); err != nil {
+			return "", 0
 		}
+
+		if msg, code := confirmed(question); msg != "" {
+			return msg, code
+		}
+
+		context["overwrite"] = true
+		return "", 0
 	}
+}
 
-	return msg, 0
+// Creates a node asking the user to confirm an action, the question is built from the context when the node runs. Unless the user answers 'y', the chain stops there. Nothing is asked if --force was given, see confirmed.
+func confirm(question func(map[string]interface{}) string) nodeFunc {
+	return func(context map[string]interface{}) (string, int) {
+		return confirmed(question(context))
+	}
 }
 
-// Simple function to chain nodes and create the actual Run function for *cobra.Command
+// Simple function to chain nodes and create the actual Run function for *cobra.Command. The positional arguments of the command are stored in the context under 'args', and the command itself under 'command', so that the nodes can tell the flags given empty from those not given. If a node locked the storage, the lock is released when the chain stops, and the message stopping it is printed, see printMessage.
 func chainNodes(nodes ...nodeFunc) func(*cobra.Command, []string) {
 
 	return func(cmd *cobra.Command, args []string) {
 		context := make(map[string]interface{})
+		context["args"] = args
+		context["command"] = cmd
 		var msg string
 		var code int
 
 		for _, fptr := range nodes {
 			msg, code = fptr(context)
 			if msg != "" {
-				fmt.Println(msg)
+				releaseLock(context)
+				printMessage(context, msg, code)
 				os.Exit(code)
 			}
 		}
+
+		releaseLock(context)
+	}
+}
+
+// Prints the message which stopped the chain. Errors go to the error output, as do all the messages of the commands printing data for programs on the standard output (see rawOutput), so that they can't corrupt it.
+func printMessage(context map[string]interface{}, msg string, code int) {
+	if code != 0 || rawOutput(context) {
+		fmt.Fprintln(os.Stderr, msg)
+		return
+	}
+	fmt.Println(msg)
+}
+
+// Releases the lock of the storage, if any
+func releaseLock(context map[string]interface{}) {
+	if lock, ok := context["lock"].(*core.Lock); ok {
+		lock.Unlock()
+		delete(context, "lock")
 	}
 }
@@ -0,0 +1,10 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+
+package cmd
+
+import "syscall"
+
+// Attributes of the processes started in the background, they already survive their parent on these platforms
+func detachedAttr() *syscall.SysProcAttr {
+	return nil
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ElyKar/mpm/core"
+	"github.com/spf13/cobra"
+)
+
+// Flags setting the details of an entry, shared by add and import
+var username string
+var urls []string
+var notes string
+var fields map[string]string
+
+// Registers the flags setting the details of an entry on a command
+func addDetailsFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&username, "username", "", "The username going with the password, an empty one removes it")
+	cmd.Flags().StringSliceVar(&urls, "url", nil, "The URLs where the password is used, can be repeated. An empty one removes them all")
+	cmd.Flags().StringVar(&notes, "notes", "", "Free notes about the password, empty ones remove them")
+	cmd.Flags().StringToStringVar(&fields, "field", nil, "Custom fields as key=value, can be repeated. An empty value removes the field")
+}
+
+// Node setting the details of the entry given by the section and name flags, after its password was set. Details which are not given are kept, those given empty are removed. It requires the storage, secret key and command from the context.
+func setDetails(context map[string]interface{}) (string, int) {
+	storage := (context["storage"]).(*core.Storage)
+	key := (context["key"]).([]byte)
+	flags := (context["command"]).(*cobra.Command).Flags()
+
+	if !flags.Changed("username") && !flags.Changed("url") && !flags.Changed("notes") && len(fields) == 0 {
+		return "", 0
+	}
+
+	entry, err := storage.GetEntry(section, name)
+	if err != nil {
+		return err.Error(), 1
+	}
+
+	details, err := storage.DecodeDetails(key, section, name, entry)
+	if err != nil {
+		return fmt.Sprintf("Impossible to read the details of your password.\n%s", err), 1
+	}
+
+	if flags.Changed("username") {
+		details.Username = username
+	}
+	if flags.Changed("url") {
+		details.URLs = nil
+		for _, url := range urls {
+			if url != "" {
+				details.URLs = append(details.URLs, url)
+			}
+		}
+	}
+	if flags.Changed("notes") {
+		details.Notes = notes
+	}
+	for k, v := range fields {
+		if details.Fields == nil {
+			details.Fields = make(map[string]string)
+		}
+
+		if v == "" {
+			delete(details.Fields, k)
+		} else {
+			details.Fields[k] = v
+		}
+	}
+
+	updated := *entry
+	if updated.Details, err = storage.EncodeDetails(key, section, name, details); err != nil {
+		return fmt.Sprintf("Impossible to encrypt the details of your password.\n%s", err), 1
+	}
+	updated.Modified = time.Now().UTC()
+
+	if err = storage.SetEntry(section, name, &updated); err != nil {
+		return err.Error(), 1
+	}
+	return "", 0
+}
+
+// Reads a field of an entry: "password", "username", "url" (the first one), "notes", or the name of a custom field.
+func readField(storage *core.Storage, key []byte, field string) (string, error) {
+	entry, err := storage.GetEntry(section, name)
+	if err != nil {
+		return "", err
+	}
+
+	if field == "password" {
+		decoded, err := storage.Transcoder(key, section, name).DecodePassword(entry.Password)
+		return string(decoded), err
+	}
+
+	details, err := storage.DecodeDetails(key, section, name, entry)
+	if err != nil {
+		return "", err
+	}
+
+	var value string
+	switch field {
+	case "username":
+		value = details.Username
+	case "url":
+		if len(details.URLs) > 0 {
+			value = details.URLs[0]
+		}
+	case "notes":
+		value = details.Notes
+	default:
+		value = details.Fields[field]
+	}
+
+	if value == "" {
+		return "", fmt.Errorf("Password %s has no %s", name, strings.ToLower(field))
+	}
+	return value, nil
+}
@@ -0,0 +1,19 @@
+package cmd
+
+// Exit codes of the commands, beside 0 for success. They are part of the interface of mpm for scripts, documented in the README: don't change them.
+const (
+	// Any other failure
+	exitFailure = 1
+	// The command line is incomplete or invalid
+	exitUsage = 2
+	// The passphrase is wrong
+	exitWrongPassphrase = 3
+	// The command would have to prompt, in non-interactive mode
+	exitNeedsInput = 4
+	// The storage, section or password does not exist
+	exitNotFound = 5
+	// The storage or a password failed its integrity check
+	exitTampered = 6
+	// The storage is locked or was modified by another program
+	exitBusy = 7
+)
@@ -0,0 +1,237 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ElyKar/mpm/core"
+	"github.com/howeyc/gopass"
+	"github.com/spf13/cobra"
+)
+
+// Formats of the export: the encrypted archive of mpm, or the passwords in clear for other password managers
+const (
+	exportArchive = "archive"
+	exportCSV     = "csv"
+	exportJSON    = "json"
+)
+
+// Flags of the export
+var exportFormat string
+var exportOut string
+var plaintext bool
+
+// Exports the whole storage
+var exportCmd = &cobra.Command{
+	Use:   "export [--format archive|csv|json] [--out <file>] [--plaintext] [--force] [--archive-passphrase-fd <fd>]",
+	Short: "Export your passwords to an encrypted archive, or in clear for another password manager",
+	Long: `Exports all your passwords, with their details, second factor and history, to a portable archive protected by its own passphrase: it doesn't depend on your storage or its key derivation, and 'mpm restore-archive' merges it into any storage. In non-interactive mode, the passphrase of the archive comes from --archive-passphrase-fd or $` + ArchivePassphraseEnv + `.
+With --format csv or json, your passwords are written in clear instead, for the other password managers: csv is the format of LastPass, json the unencrypted export of Bitwarden. As anyone reading the file gets all your passwords, --plaintext must be given to confirm.
+The export is written to --out, or to the standard output.`,
+	Run: chainNodes(exportFormatValid, storageExists, verifyPassphrase, exportOutValid, createArchivePassphrase, exportFunc),
+}
+
+// Merges an archive into the storage
+var restoreArchiveCmd = &cobra.Command{
+	Use:   "restore-archive <archive> [--dry-run] [--force] [--archive-passphrase-fd <fd>]",
+	Short: "Merge an archive made by 'mpm export' into your storage",
+	Long: `Decrypts an archive made by 'mpm export' and merges its passwords into your storage, with their details, second factor and history, the way 'mpm merge' does. The passwords which are not in your storage are added, those identical in both are left as they are, and a password of the archive which is an update of yours (more recent, and still having your password in its history) replaces it.
+The passwords which differ otherwise, even by their details only, are reported and kept, unless --force is given: the version of the archive then replaces yours, whose password stays in the history.
+--dry-run shows what would be merged without writing anything. In non-interactive mode, the passphrase of the archive comes from --archive-passphrase-fd or $` + ArchivePassphraseEnv + `.`,
+	Args: cobra.ExactArgs(1),
+	Run:  chainNodes(readArchive, lockStorage, storageExists, verifyPassphrase, restoreArchiveFunc, updateStore),
+}
+
+// Node asserting the export format is known, and that --plaintext is given for the formats in clear
+func exportFormatValid(context map[string]interface{}) (string, int) {
+	switch exportFormat {
+	case exportArchive:
+		return "", 0
+	case exportCSV, exportJSON:
+		if !plaintext {
+			return fmt.Sprintf("Exporting in %s writes all your passwords in clear, anyone reading the file gets them.\nGive --plaintext to confirm, or export to an encrypted archive.", exportFormat), exitUsage
+		}
+		return "", 0
+	default:
+		return fmt.Sprintf("Unknown export format %s, expected %s, %s or %s", exportFormat, exportArchive, exportCSV, exportJSON), exitUsage
+	}
+}
+
+// Node asking to confirm before replacing an existing file with the export
+func exportOutValid(context map[string]interface{}) (string, int) {
+	if exportOut == "" {
+		return "", 0
+	}
+
+	if _, err := os.Stat(exportOut); err == nil {
+		return confirmed(fmt.Sprintf("File %s already exists, are you sure you want to replace it ?", exportOut))
+	}
+	return "", 0
+}
+
+// Node reading the passphrase of a new archive, given or prompted twice on the error output. On success, it is stored in the context under 'archivePass'. Nothing is asked for the exports in clear.
+func createArchivePassphrase(context map[string]interface{}) (string, int) {
+	if exportFormat != exportArchive {
+		return "", 0
+	}
+
+	if passphrase, given, err := givenArchivePassphrase(); err != nil {
+		return fmt.Sprintf("An error occurred !\n%s", err), exitFailure
+	} else if given {
+		if passphrase == "" {
+			return "The passphrase of the archive can't be empty", exitUsage
+		}
+		context["archivePass"] = passphrase
+		return "", 0
+	} else if nonInteractive {
+		return needsInput("passphrase of the archive", "give it with --archive-passphrase-fd or $"+ArchivePassphraseEnv)
+	}
+
+	pass1, err := gopass.GetPasswdPrompt("Enter the passphrase of the archive: ", false, os.Stdin, os.Stderr)
+	if err != nil {
+		return fmt.Sprintf("An error occurred !\n%s", err), 1
+	}
+	pass2, err := gopass.GetPasswdPrompt("Re-enter the passphrase of the archive: ", false, os.Stdin, os.Stderr)
+	if err != nil {
+		return fmt.Sprintf("An error occurred !\n%s", err), 1
+	}
+
+	if string(pass1) != string(pass2) {
+		return "Passphrases mismatch !", 1
+	} else if len(pass1) == 0 {
+		return "The passphrase of the archive can't be empty", exitUsage
+	}
+
+	context["archivePass"] = string(pass1)
+	return "", 0
+}
+
+// Node exporting the storage in the chosen format. It requires the storage and secret key from the context, and the passphrase of the archive for the archives.
+func exportFunc(context map[string]interface{}) (string, int) {
+	storage := (context["storage"]).(*core.Storage)
+	key := (context["key"]).([]byte)
+
+	entries, err := storage.Export(key)
+	if authErr, ok := err.(*core.AuthError); ok {
+		return fmt.Sprintf("%s\nRefusing to export it.", authErr), exitTampered
+	} else if err != nil {
+		return fmt.Sprintf("Impossible to read your storage.\n%s", err), 1
+	}
+
+	var data []byte
+	switch exportFormat {
+	case exportArchive:
+		// The archive is derived like the storage, with its own salt, or with the default parameters for a legacy storage
+		var kdf *core.KDF
+		if storage.KDF != nil {
+			copied := *storage.KDF
+			kdf = &copied
+			err = kdf.Resalt()
+		} else {
+			kdf, err = core.NewKDF(core.KDFArgon2id)
+		}
+		if err == nil {
+			data, err = core.WriteArchive(entries, (context["archivePass"]).(string), kdf)
+		}
+	case exportCSV:
+		data, err = core.ExportCSV(entries)
+	case exportJSON:
+		data, err = core.ExportJSON(entries)
+	}
+	if err != nil {
+		return fmt.Sprintf("Impossible to export your passwords.\n%s", err), 1
+	}
+
+	if exportOut == "" {
+		if exportFormat != exportArchive {
+			warnTerminal()
+		}
+		context["rawOutput"] = true
+		os.Stdout.Write(data)
+		return "", 0
+	}
+
+	if err = core.WriteExport(exportOut, data); err != nil {
+		return fmt.Sprintf("Impossible to write %s.\n%s", exportOut, err), 1
+	}
+	if exportFormat != exportArchive {
+		return fmt.Sprintf("%d passwords exported in clear to %s, delete it as soon as you imported it.", len(entries), exportOut), 0
+	}
+	return fmt.Sprintf("%d passwords exported to %s", len(entries), exportOut), 0
+}
+
+// Node decrypting the archive given as argument, with its passphrase given or prompted on the error output. On success, its entries are stored in the context under 'imported', see importManyFunc.
+func readArchive(context map[string]interface{}) (string, int) {
+	path := (context["args"]).([]string)[0]
+
+	passphrase, given, err := givenArchivePassphrase()
+	if err != nil {
+		return fmt.Sprintf("An error occurred !\n%s", err), exitFailure
+	} else if !given {
+		if nonInteractive {
+			return needsInput("passphrase of the archive", "give it with --archive-passphrase-fd or $"+ArchivePassphraseEnv)
+		}
+
+		prompted, err := gopass.GetPasswdPrompt("Enter the passphrase of the archive: ", false, os.Stdin, os.Stderr)
+		if err != nil {
+			return fmt.Sprintf("An error occurred !\n%s", err), 1
+		}
+		passphrase = string(prompted)
+	}
+
+	header, entries, err := core.ReadArchiveFile(path, passphrase)
+	if _, ok := err.(*core.ArchiveAuthError); ok {
+		return err.Error(), exitWrongPassphrase
+	} else if err != nil {
+		return fmt.Sprintf("Impossible to read %s.\n%s", path, err), 1
+	}
+
+	fmt.Printf("Read %d passwords from the archive of %s\n", len(entries), formatDate(header.Created))
+	context["imported"] = entries
+	return "", 0
+}
+
+// Node merging the entries of the archive into the storage, see core.Storage.MergeImported. The conflicts keep the versions of the storage, unless --force is given. It requires the storage, secret key and imported entries from the context. Nothing is left to write for a dry run or if nothing changed.
+func restoreArchiveFunc(context map[string]interface{}) (string, int) {
+	storage := (context["storage"]).(*core.Storage)
+	key := (context["key"]).([]byte)
+	path := (context["args"]).([]string)[0]
+
+	resolve := core.MergeOurs.Resolve
+	if force {
+		resolve = core.MergeTheirs.Resolve
+	}
+
+	report, err := storage.MergeImported(key, (context["imported"]).([]core.ImportedEntry), resolve)
+	if err != nil {
+		return fmt.Sprintf("Impossible to merge %s.\n%s", path, err), 1
+	}
+	printMergeReport(report, path)
+	if len(report.Conflicts) > 0 && !force {
+		fmt.Println("Run again with --force to take the versions of the archive instead.")
+	}
+
+	if dryRun {
+		return "\nNothing was written, as --dry-run was given.", 0
+	} else if report.Changes() == 0 {
+		return "\nYour storage is left as it is.", 0
+	}
+	return "", 0
+}
+
+func init() {
+	exportCmd.Flags().StringVar(&exportFormat, "format", exportArchive, "The format of the export: archive (encrypted), csv or json (in clear)")
+	exportCmd.Flags().StringVar(&exportOut, "out", "", "The file to write the export to, instead of the standard output")
+	exportCmd.Flags().BoolVar(&plaintext, "plaintext", false, "Confirm that the export in csv or json writes your passwords in clear")
+	exportCmd.Flags().BoolVar(&force, "force", false, "Replace the file given to --out if it exists")
+
+	restoreArchiveCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only show what would be merged")
+	restoreArchiveCmd.Flags().BoolVar(&force, "force", false, "Replace the passwords which differ from those of the archive")
+
+	for _, cmd := range []*cobra.Command{exportCmd, restoreArchiveCmd} {
+		cmd.Flags().IntVar(&archivePassphraseFd, "archive-passphrase-fd", -1, "Read the passphrase of the archive from the first line of this file descriptor")
+	}
+
+	RootCmd.AddCommand(exportCmd)
+	RootCmd.AddCommand(restoreArchiveCmd)
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ElyKar/mpm/core"
+	"github.com/spf13/cobra"
+)
+
+// Flags of the word-based generator, shared by add and gen
+var separator string
+var capitalize bool
+var withDigit bool
+var wordList string
+
+// Number of words of the passphrase generated by gen
+var genWords int
+
+// Registers the flags of the word-based generator on a command, the number of words with its own variable and default
+func addWordsFlags(cmd *cobra.Command, words *int, defaultWords int, usage string) {
+	cmd.Flags().IntVar(words, "words", defaultWords, usage)
+	cmd.Flags().StringVar(&separator, "separator", "-", "Put between the words")
+	cmd.Flags().BoolVar(&capitalize, "capitalize", false, "Capitalize the first letter of each word")
+	cmd.Flags().BoolVar(&withDigit, "digit", false, "Append a random digit to one of the words")
+	cmd.Flags().StringVar(&wordList, "wordlist", "", "A file with the words to use, one per line (the large word list of the EFF by default)")
+}
+
+// Creates the word-based generator from the flags
+func newDiceware(words int) (*core.Diceware, error) {
+	list := core.EFFWordList()
+	if wordList != "" {
+		var err error
+		if list, err = core.LoadWordList(wordList); err != nil {
+			return nil, fmt.Errorf("Impossible to read your word list.\n%s", err)
+		}
+	}
+
+	return &core.Diceware{List: list, Words: words, Separator: separator, Capitalize: capitalize, Digit: withDigit}, nil
+}
+
+// Generates a passphrase without touching the storage
+var genCmd = &cobra.Command{
+	Use:   "gen [--words <n>] [--separator <separator>] [--capitalize] [--digit] [--wordlist <file>]",
+	Short: "Generate a passphrase made of random words",
+	Long: `Generates a passphrase made of words drawn at random, easier to remember than random characters: for your master passphrase, a Wi-Fi key or your disk encryption.
+The passphrase is printed on the standard output, and its entropy on the error output. Your storage is not used.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run:              chainNodes(genFunc),
+}
+
+// Node generating and printing the passphrase
+func genFunc(context map[string]interface{}) (string, int) {
+	diceware, err := newDiceware(genWords)
+	if err != nil {
+		return err.Error(), 1
+	}
+
+	passphrase, err := diceware.Generate()
+	if err != nil {
+		return err.Error(), exitUsage
+	}
+
+	fmt.Println(passphrase)
+	fmt.Fprintln(os.Stderr, diceware.Report())
+	return "", 0
+}
+
+func init() {
+	addWordsFlags(genCmd, &genWords, 6, "The number of words")
+
+	RootCmd.AddCommand(genCmd)
+}
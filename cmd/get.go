@@ -2,48 +2,159 @@ package cmd
 
 import (
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/ElyKar/mpm/core"
-	"github.com/atotto/clipboard"
 	"github.com/spf13/cobra"
 )
 
 // get the password for the section and name, then copy it to the clipboard
 var getCmd = &cobra.Command{
-	Use:   "get",
+	Use:   "get --section <section> --name <name> [--field <field>] [--timeout <duration>] [--no-clear] [--stdout] [--output <format>]",
 	Short: "Copy a password to your clipboard",
-	Run:   chainNodes(sectionAndNameRequired, storageExists, verifyPassphrase, getFunc),
+	Long: `Copies a password, or another field of its entry, to your clipboard.
+With --stdout, it is printed instead. With --output json, yaml or tsv, the whole entry is printed.`,
+	Run: chainNodes(sectionAndNameRequired, outputValid, storageExists, verifyPassphrase, getFunc),
 }
 
-// Get the password from the storage, decodes it, then copies it into the clipboard. It needs the storage and passphrase from the context.
+// Field of the entry to copy, the password by default
+var getField string
+
+// Prints the field instead of copying it
+var printStdout bool
+
+// Get the password (or another field) from the storage, decodes it, then copies it into the clipboard. It needs the storage and secret key from the context.
 func getFunc(context map[string]interface{}) (string, int) {
 	var storage *core.Storage = (context["storage"]).(*core.Storage)
-	var passphrase string = (context["passphrase"]).(string)
-
-	decoder := core.NewTranscoder(passphrase)
-	encoded, err := storage.Get(section, name)
+	var key []byte = (context["key"]).([]byte)
 
 	// Either section or name does not exist
-	if err != nil {
-		return err.Error(), 0
+	if _, err := storage.Get(section, name); err != nil {
+		return err.Error(), exitNotFound
 	}
 
-	decoded, err := decoder.DecodePassword(encoded)
-	if err != nil {
+	if output != outputText {
+		return printEntry(storage, key)
+	}
+
+	decoded, err := readField(storage, key, getField)
+	if authErr, ok := err.(*core.AuthError); ok {
+		return fmt.Sprintf("%s\nRefusing to copy it to your clipboard.", authErr), exitTampered
+	} else if err != nil {
 		return fmt.Sprintf("An error occurred:\n%s", err.Error()), 1
 	}
 
-	if err = clipboard.WriteAll(string(decoded)); err != nil {
+	if printStdout {
+		warnTerminal()
+		fmt.Println(decoded)
+		return "", 0
+	}
+
+	if err = copyToClipboard(string(decoded)); err != nil {
 		return fmt.Sprintf("Impossible to copy to clipboard.\n%s", err), 1
 	} else {
-		return "Your password has been successfully copied to your clipboard", 0
+		return clipboardMessage(getField), 0
+	}
+
+}
+
+// Prints the whole entry in the output format. It needs the storage and secret key.
+func printEntry(storage *core.Storage, key []byte) (string, int) {
+	entry, err := storage.GetEntry(section, name)
+	if err != nil {
+		return err.Error(), exitNotFound
+	}
+
+	password, err := storage.Transcoder(key, section, name).DecodePassword(entry.Password)
+	if err == nil {
+		var details *core.EntryDetails
+		var otp *core.OTP
+		if details, err = storage.DecodeDetails(key, section, name, entry); err == nil {
+			if otp, err = storage.DecodeOTP(key, section, name, entry); err == nil {
+				warnTerminal()
+				printOutput(entryRecord(entry, string(password), details, otp), entryRows(entry, string(password), details, otp))
+				return "", 0
+			}
+		}
+	}
+
+	if authErr, ok := err.(*core.AuthError); ok {
+		return fmt.Sprintf("%s\nRefusing to print it.", authErr), exitTampered
+	}
+	return fmt.Sprintf("An error occurred:\n%s", err.Error()), 1
+}
+
+// The entry as a record, for the json and yaml formats
+func entryRecord(entry *core.Entry, password string, details *core.EntryDetails, otp *core.OTP) record {
+	urls := details.URLs
+	if urls == nil {
+		urls = []string{}
+	}
+	fields := details.Fields
+	if fields == nil {
+		fields = map[string]string{}
+	}
+	var uri interface{}
+	if otp != nil {
+		uri = otp.URI()
+	}
+
+	return record{
+		{"section", section},
+		{"name", name},
+		{"password", password},
+		{"username", details.Username},
+		{"urls", urls},
+		{"notes", details.Notes},
+		{"fields", fields},
+		{"otp", uri},
+		{"created", outputDate(entry.Created)},
+		{"modified", outputDate(entry.Modified)},
+	}
+}
+
+// The entry as key and value rows, for the tsv format. There is one row per URL, custom fields are prefixed with 'field.', and the second factor is given as its otpauth URI.
+func entryRows(entry *core.Entry, password string, details *core.EntryDetails, otp *core.OTP) [][]string {
+	rows := [][]string{{"section", section}, {"name", name}, {"password", password}, {"username", details.Username}}
+	for _, url := range details.URLs {
+		rows = append(rows, []string{"url", url})
+	}
+	rows = append(rows, []string{"notes", details.Notes})
+
+	keys := make([]string, 0, len(details.Fields))
+	for k := range details.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		rows = append(rows, []string{"field." + k, details.Fields[k]})
+	}
+	if otp != nil {
+		rows = append(rows, []string{"otp", otp.URI()})
+	}
+
+	for _, date := range []struct {
+		key  string
+		date time.Time
+	}{{"created", entry.Created}, {"modified", entry.Modified}} {
+		if formatted, ok := outputDate(date.date).(string); ok {
+			rows = append(rows, []string{date.key, formatted})
+		} else {
+			rows = append(rows, []string{date.key, ""})
+		}
 	}
 
+	return rows
 }
 
 func init() {
 	getCmd.Flags().StringVar(&section, "section", "", "The section to get your password from")
 	getCmd.Flags().StringVar(&name, "name", "", "The password you want")
+	getCmd.Flags().StringVar(&getField, "field", "password", "The field to copy: password, username, url, notes or the name of a custom field")
+	getCmd.Flags().BoolVar(&printStdout, "stdout", false, "Print the field instead of copying it to your clipboard")
+	addClipboardFlags(getCmd)
+	addOutputFlag(getCmd)
 
 	RootCmd.AddCommand(getCmd)
 }
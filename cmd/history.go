@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/ElyKar/mpm/core"
+	"github.com/spf13/cobra"
+)
+
+// Version of the password to restore, 1 being the most recent previous password
+var restoreVersion int
+
+// Lists the previous passwords of an entry
+var historyCmd = &cobra.Command{
+	Use:   "history --section <section> --name <name>",
+	Short: "List the previous versions of a password",
+	Long: `Lists the previous versions of a password, the most recent first, with the dates they were set and replaced.
+The passwords themselves are not displayed, use 'mpm restore' to bring one back.`,
+	Run: chainNodes(sectionAndNameRequired, storageExists, unlockNames, passwordExists, historyFunc),
+}
+
+// Node listing the previous passwords of the entry. It requires the storage from the context. Nothing is decrypted.
+func historyFunc(context map[string]interface{}) (string, int) {
+	storage := (context["storage"]).(*core.Storage)
+
+	history, err := storage.History(section, name)
+	if err != nil {
+		return err.Error(), 1
+	}
+
+	if len(history) == 0 {
+		return fmt.Sprintf("Password %s has no previous version.", name), 0
+	}
+
+	fmt.Printf("Here are the previous versions of %s:\n", name)
+	for i, revision := range history {
+		fmt.Printf("    [%d]  set %s, replaced %s\n", i+1, formatDate(revision.Modified), formatDate(revision.Replaced))
+	}
+
+	return "", 0
+}
+
+// Rolls an entry back to a previous password
+var restoreCmd = &cobra.Command{
+	Use:   "restore --section <section> --name <name> --version <n> [--force]",
+	Short: "Restore a previous version of a password",
+	Long: `Sets back a previous version of a password, listed by 'mpm history'.
+The current password becomes the most recent previous version, so restoring can be undone.`,
+	Run: chainNodes(sectionAndNameRequired, lockStorage, storageExists, verifyPassphrase, passwordExists, confirm(func(context map[string]interface{}) string {
+		return fmt.Sprintf("Are you sure you want to replace %s with its version %d ?", name, restoreVersion)
+	}), restoreFunc, updateStore),
+}
+
+// restoreFunc requires the storage from the context, and also non-empty name and section
+func restoreFunc(context map[string]interface{}) (string, int) {
+	storage := (context["storage"]).(*core.Storage)
+
+	if err := storage.Restore(section, name, restoreVersion); err != nil {
+		return err.Error(), 1
+	}
+
+	return "", 0
+}
+
+func init() {
+	historyCmd.Flags().StringVar(&section, "section", "", "The section of the password")
+	historyCmd.Flags().StringVar(&name, "name", "", "The password you want the history of")
+
+	restoreCmd.Flags().StringVar(&section, "section", "", "The section of the password")
+	restoreCmd.Flags().StringVar(&name, "name", "", "The password you want to restore")
+	restoreCmd.Flags().IntVar(&restoreVersion, "version", 1, "The version to restore, as listed by 'mpm history'")
+	restoreCmd.Flags().BoolVar(&force, "force", false, "Do not ask for confirmation")
+
+	RootCmd.AddCommand(historyCmd)
+	RootCmd.AddCommand(restoreCmd)
+}
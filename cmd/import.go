@@ -1,34 +1,184 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+	"strings"
+
 	"github.com/ElyKar/mpm/core"
 	"github.com/spf13/cobra"
 )
 
-// Imports an existing password
+// Format of the export to import from, see core.Importers. Empty to import a single password.
+var importFrom string
+
+// Only shows what importing an export would do
+var dryRun bool
+
+// Imports an existing password, or all the passwords exported by another password manager
 var importCmd = &cobra.Command{
-	Use:   "import --section <section> --name <name>",
+	Use:   "import --section <section> --name <name> [--breach-db <file>] [--force] | --from <format> <path> [--section <section>] [--dry-run] [--force]",
 	Short: "Imports an existing password in the storage",
-	Run:   chainNodes(sectionAndNameRequired, storageExists, verifyPassphrase, verifyErase, createPassword, importFunc, updateStore),
+	Long: `Imports an existing password in the storage. In non-interactive mode, the password is read from the standard input, after the passphrase if it is read from there too.
+Its strength is estimated, with a warning if it is weak: see 'mpm strength'. With --breach-db, it is also looked for in the breaches, see 'mpm breach-check'.
+
+With --from, all the passwords exported by another password manager are imported, along with their details and second factor. Their folders become sections, the passwords outside of any folder go to --section (named after the format by default). The passwords which already exist in your storage are reported and kept, unless --force is given. --dry-run shows what would be imported without writing anything.
+The formats are:
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if importFrom != "" {
+			importManyCmd(cmd, args)
+		} else {
+			importOneCmd(cmd, args)
+		}
+	},
+}
+
+// Imports a single password
+var importOneCmd = chainNodes(sectionAndNameRequired, lockStorage, storageExists, verifyPassphrase, verifyErase, createPassword, importStrength, importBreachCheck, importFunc, setDetails, updateStore)
+
+// Imports an export
+var importManyCmd = chainNodes(readExport, lockStorage, storageExists, verifyPassphrase, importManyFunc, updateStore)
+
+// Node showing the strength of the imported password, with a warning if it is weak. The password is imported anyway. It needs the new password from the context.
+func importStrength(context map[string]interface{}) (string, int) {
+	strength := core.EstimateStrength(string(context["newPass"].([]byte)))
+	fmt.Println(strength)
+	if strength.Weak() {
+		fmt.Fprintln(os.Stderr, "Warning: this password is weak, you should change it and generate the new one with 'mpm add'.")
+	}
+
+	return "", 0
 }
 
-// importFunc requires the storage and passphrase from the context, and also non-empty name and section
+// importFunc requires the storage and secret key from the context, and also non-empty name and section
 func importFunc(context map[string]interface{}) (string, int) {
 	var storage *core.Storage = (context["storage"]).(*core.Storage)
 
 	// Assert we won't erase a password
 	password := string(context["newPass"].([]byte))
-	encoder := core.NewTranscoder(context["passphrase"].(string))
+	encoder := storage.Transcoder(context["key"].([]byte), section, name)
 	encoded, _ := encoder.EncodePassword(password)
 
-	storage.Set(section, name, string(encoded))
+	if err := storage.Set(section, name, string(encoded)); err != nil {
+		return err.Error(), 1
+	}
+	return "", 0
+}
+
+// Node reading the export given as argument, in the format given by --from. On success, it stores the entries in the context under 'imported'.
+func readExport(context map[string]interface{}) (string, int) {
+	args := (context["args"]).([]string)
+
+	importer, err := core.FindImporter(importFrom)
+	if err != nil {
+		return err.Error(), exitUsage
+	}
+	if len(args) != 1 {
+		return fmt.Sprintf("You need to provide the path of your export: mpm import --from %s <path>", importer.Name()), exitUsage
+	}
+
+	defaultSection := section
+	if defaultSection == "" {
+		defaultSection = importer.Name()
+	}
+
+	entries, err := importer.Read(args[0], defaultSection)
+	if err != nil {
+		return fmt.Sprintf("Impossible to read %s.\n%s", args[0], err), 1
+	}
+
+	fmt.Printf("Read %d items from %s\n", len(entries), args[0])
+	context["imported"] = entries
+	return "", 0
+}
+
+// Node importing the entries read from an export, or only showing what it would do with --dry-run. It requires the storage, secret key and imported entries from the context.
+func importManyFunc(context map[string]interface{}) (string, int) {
+	storage := (context["storage"]).(*core.Storage)
+	key := (context["key"]).([]byte)
+	plan := storage.PlanImport(key, (context["imported"]).([]core.ImportedEntry))
+
+	verb := "Importing"
+	if dryRun {
+		verb = "Would import"
+	}
+	if len(plan.New) > 0 {
+		fmt.Printf("\n%s (%d):\n", verb, len(plan.New))
+		for _, entry := range plan.New {
+			printImported(plan, entry)
+		}
+	}
+
+	if len(plan.Conflicts) > 0 {
+		if force {
+			fmt.Printf("\nAlready in your storage, replaced (%d):\n", len(plan.Conflicts))
+		} else {
+			fmt.Printf("\nAlready in your storage, kept as they are unless --force is given (%d):\n", len(plan.Conflicts))
+		}
+		for _, entry := range plan.Conflicts {
+			printImported(plan, entry)
+		}
+	}
+
+	if len(plan.Unchanged) > 0 {
+		fmt.Printf("\nAlready in your storage with the same password (%d)\n", len(plan.Unchanged))
+	}
+
+	if len(plan.Skipped) > 0 {
+		fmt.Printf("\nSkipped (%d):\n", len(plan.Skipped))
+		for _, skipped := range plan.Skipped {
+			fmt.Printf("    - %s/%s: %s\n", skipped.Section, skipped.Name, skipped.Reason)
+		}
+	}
+
+	if dryRun {
+		return "\nNothing was written, as --dry-run was given.", 0
+	}
+
+	imported, err := storage.Import(key, plan, force)
+	if err != nil {
+		return err.Error(), 1
+	}
+	if imported == 0 {
+		return "\nNothing to import.", 0
+	}
+
+	fmt.Printf("\n%d passwords imported, 'mpm audit' tells which ones are weak or reused.\n", imported)
 	return "", 0
 }
 
+// Prints an entry of an import plan, with its name in the export if it had to be renamed
+func printImported(plan *core.ImportPlan, entry core.ImportedEntry) {
+	fmt.Printf("    - %s/%s", entry.Section, entry.Name)
+	if original, ok := plan.Renamed[core.EntryRef{Section: entry.Section, Name: entry.Name}]; ok {
+		fmt.Printf(" (another %s in the export)", original)
+	}
+	fmt.Println()
+}
+
 func init() {
-	importCmd.Flags().StringVar(&section, "section", "", "The section to add the imported password")
+	for _, importer := range core.Importers() {
+		importCmd.Long += fmt.Sprintf("    %-12s%s\n", importer.Name(), importer.Description())
+	}
+
+	importCmd.Flags().StringVar(&section, "section", "", "The section to add the imported password, or the one of the passwords outside of any folder with --from")
 	importCmd.Flags().StringVar(&name, "name", "", "A name for your the imported password")
+	importCmd.Flags().StringVar(&breachDB, "breach-db", "", "Look for the password in these breached passwords, see 'mpm breach-check'")
+	importCmd.Flags().BoolVar(&force, "force", false, "Erase the password if it already exists, or import it even if it was breached")
+	importCmd.Flags().StringVar(&importFrom, "from", "", "Import all the passwords of an export in this format: "+strings.Join(importerNames(), ", "))
+	importCmd.Flags().BoolVar(&dryRun, "dry-run", false, "With --from, only show what would be imported")
+	addDetailsFlags(importCmd)
 
 	RootCmd.AddCommand(importCmd)
 
 }
+
+// The names of the formats which can be imported
+func importerNames() []string {
+	var names []string
+	for _, importer := range core.Importers() {
+		names = append(names, importer.Name())
+	}
+	return names
+}
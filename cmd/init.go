@@ -1,18 +1,23 @@
 package cmd
 
 import (
+	"fmt"
+
 	"github.com/ElyKar/mpm/core"
 	"github.com/spf13/cobra"
 )
 
+// Whether the names of the new storage are encrypted
+var encryptNames bool
+
 // Rudimentary command, it simply initializes an empty storage with a passphrase.
 var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initialize an empty store for mpm",
-	Run:   chainNodes(createPassphrase, initFunc, updateStore),
+	Run:   chainNodes(lockStorage, createFirstPassphrase, initFunc, updateStore),
 }
 
-// Requires the newPass from the context, and creates a new storage with it.
+// Requires the newPass from the context, and creates a new storage with it. On success, the storage and its secret key are stored in the context.
 func initFunc(context map[string]interface{}) (string, int) {
 	var passphrase []byte = (context["newPass"]).([]byte)
 
@@ -20,10 +25,23 @@ func initFunc(context map[string]interface{}) (string, int) {
 		return "There is already a store !", 1
 	}
 
-	context["storage"] = core.InitPassphrase(passphrase)
+	storage, err := core.InitPassphrase(passphrase)
+	if err != nil {
+		return fmt.Sprintf("Impossible to create your storage.\n%s", err), 1
+	}
+
+	key, err := storage.DeriveKey(string(passphrase))
+	if err != nil {
+		return fmt.Sprintf("Impossible to derive your secret key.\n%s", err), 1
+	}
+
+	storage.EncryptedNames = encryptNames
+	context["storage"] = storage
+	context["key"] = key
 	return "", 0
 }
 
 func init() {
+	initCmd.Flags().BoolVar(&encryptNames, "encrypt-names", false, "Encrypt the names of the sections and passwords too")
 	RootCmd.AddCommand(initCmd)
 }
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// In non-interactive mode, nothing is prompted: the passphrase comes from a file descriptor, the standard input or the environment, the other answers from flags. When an answer is missing, the command fails with exitNeedsInput.
+
+// Environment variables giving the passphrase, the new one for the change command, the one of the archives for export and restore-archive, and the one of the other vault for merge
+const (
+	PassphraseEnv        = "MPM_PASSPHRASE"
+	NewPassphraseEnv     = "MPM_NEW_PASSPHRASE"
+	ArchivePassphraseEnv = "MPM_ARCHIVE_PASSPHRASE"
+	OtherPassphraseEnv   = "MPM_OTHER_PASSPHRASE"
+)
+
+// Global flags of the non-interactive mode
+var nonInteractive bool
+var passphraseFd int
+var passphraseStdin bool
+var newPassphraseFd int
+
+// Flag of export and restore-archive giving the passphrase of the archive
+var archivePassphraseFd int
+
+// Flag of merge giving the passphrase of the other vault
+var otherPassphraseFd int
+
+// Reads a line from the standard input, one byte at a time so that nothing is read ahead of the prompts which follow
+func readLine() (string, error) {
+	return readLineFrom(os.Stdin)
+}
+
+// Reads a line from a reader, one byte at a time. The line ending is dropped.
+func readLineFrom(reader io.Reader) (string, error) {
+	var line strings.Builder
+	buffer := make([]byte, 1)
+
+	for {
+		n, err := reader.Read(buffer)
+		if n == 1 {
+			if buffer[0] == '\n' {
+				break
+			}
+			line.WriteByte(buffer[0])
+		}
+
+		if err == io.EOF && line.Len() > 0 {
+			break
+		} else if err != nil {
+			return "", err
+		}
+	}
+
+	return strings.TrimSuffix(line.String(), "\r"), nil
+}
+
+// Reads the first line of a file descriptor
+func readFd(fd int) (string, error) {
+	file := os.NewFile(uintptr(fd), fmt.Sprintf("fd %d", fd))
+	if file == nil {
+		return "", fmt.Errorf("Invalid file descriptor %d", fd)
+	}
+	defer file.Close()
+
+	return readLineFrom(file)
+}
+
+// Returns the passphrase given by --passphrase-fd, --passphrase-stdin or $MPM_PASSPHRASE, in this order. The boolean is false if none of them is used.
+func givenPassphrase() (string, bool, error) {
+	if passphraseFd >= 0 {
+		passphrase, err := readFd(passphraseFd)
+		return passphrase, true, err
+	}
+
+	if passphraseStdin {
+		passphrase, err := readLine()
+		return passphrase, true, err
+	}
+
+	passphrase, ok := os.LookupEnv(PassphraseEnv)
+	return passphrase, ok, nil
+}
+
+// Returns the new passphrase given by --new-passphrase-fd or $MPM_NEW_PASSPHRASE, in this order. The boolean is false if none of them is used.
+func givenNewPassphrase() (string, bool, error) {
+	if newPassphraseFd >= 0 {
+		passphrase, err := readFd(newPassphraseFd)
+		return passphrase, true, err
+	}
+
+	passphrase, ok := os.LookupEnv(NewPassphraseEnv)
+	return passphrase, ok, nil
+}
+
+// Returns the passphrase of an archive given by --archive-passphrase-fd or $MPM_ARCHIVE_PASSPHRASE, in this order. The boolean is false if none of them is used.
+func givenArchivePassphrase() (string, bool, error) {
+	if archivePassphraseFd >= 0 {
+		passphrase, err := readFd(archivePassphraseFd)
+		return passphrase, true, err
+	}
+
+	passphrase, ok := os.LookupEnv(ArchivePassphraseEnv)
+	return passphrase, ok, nil
+}
+
+// Returns the passphrase of the vault to merge given by --other-passphrase-fd or $MPM_OTHER_PASSPHRASE, in this order. The boolean is false if none of them is used.
+func givenOtherPassphrase() (string, bool, error) {
+	if otherPassphraseFd >= 0 {
+		passphrase, err := readFd(otherPassphraseFd)
+		return passphrase, true, err
+	}
+
+	passphrase, ok := os.LookupEnv(OtherPassphraseEnv)
+	return passphrase, ok, nil
+}
+
+// Returns the password to import: in non-interactive mode, it is read from the standard input, after the passphrase if it comes from there too.
+func givenPassword() (string, bool, error) {
+	if !nonInteractive {
+		return "", false, nil
+	}
+
+	password, err := readLine()
+	return password, true, err
+}
+
+// Asks the user a yes/no question. It returns an empty message if the answer is yes, else the message to stop the chain with. Nothing is asked if --force was given, and the chain fails in non-interactive mode.
+func confirmed(question string) (string, int) {
+	if force {
+		return "", 0
+	}
+
+	if nonInteractive {
+		return fmt.Sprintf("%s\nGive --force to confirm in non-interactive mode.", question), exitNeedsInput
+	}
+
+	var answer string
+	interactS(question+" [y/n]\n", &answer)
+	if answer != "y" {
+		return "Ok, goodbye", 0
+	}
+
+	return "", 0
+}
+
+// Message of the nodes which would have to prompt in non-interactive mode
+func needsInput(what string, how string) (string, int) {
+	return fmt.Sprintf("Missing %s in non-interactive mode, %s.", what, how), exitNeedsInput
+}
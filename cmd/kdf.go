@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ElyKar/mpm/core"
+	"github.com/spf13/cobra"
+)
+
+// Flags of the tune command
+var kdfAlgorithm string
+var kdfTarget time.Duration
+var kdfApply bool
+
+// Root KDF command
+var kdfCmd = &cobra.Command{
+	Use:   "kdf [tune]",
+	Short: "Manage the key derivation of your storage",
+}
+
+// Benchmarks the key derivation on this machine, and optionally applies the result to the storage
+var kdfTuneCmd = &cobra.Command{
+	Use:   "tune [--algorithm argon2id|scrypt] [--target <duration>] [--apply]",
+	Short: "Find the strongest key derivation parameters for this machine",
+	Long: `Benchmarks the key derivation function on this machine, and finds the strongest parameters that derive a key in about the target duration.
+With --apply, all of your passwords are re-encrypted with a key derived using these parameters.`,
+	Run: chainNodes(kdfTuneFunc, applyRequired, lockStorage, storageExists, askPassphrase, kdfApplyFunc, updateStore),
+}
+
+// Node benchmarking the key derivation function. On success, it stores the tuned KDF in the context.
+func kdfTuneFunc(context map[string]interface{}) (string, int) {
+	fmt.Printf("Benchmarking %s, this may take a few seconds...\n", kdfAlgorithm)
+
+	kdf, elapsed, err := core.TuneKDF(kdfAlgorithm, kdfTarget)
+	if err != nil {
+		return fmt.Sprintf("Impossible to benchmark the key derivation.\n%s", err), 1
+	}
+
+	fmt.Printf("Recommended parameters: %s, deriving a key takes %s\n", kdf, elapsed.Round(time.Millisecond))
+	context["kdf"] = kdf
+	return "", 0
+}
+
+// Internal node, stops the chain unless the --apply flag has been given
+func applyRequired(context map[string]interface{}) (string, int) {
+	if !kdfApply {
+		return "Run this command again with --apply to use these parameters for your storage.", 0
+	}
+
+	return "", 0
+}
+
+// Node re-encrypting the storage with the tuned KDF. It requires the storage, passphrase and kdf from the context.
+func kdfApplyFunc(context map[string]interface{}) (string, int) {
+	storage := (context["storage"]).(*core.Storage)
+	passphrase := (context["passphrase"]).(string)
+	kdf := (context["kdf"]).(*core.KDF)
+
+	if err := storage.SetKDF(passphrase, kdf); err != nil {
+		return fmt.Sprintf("Your storage hasn't been changed.\n%s", err), 1
+	}
+
+	key, err := storage.DeriveKey(passphrase)
+	if err != nil {
+		return fmt.Sprintf("Impossible to derive your new secret key.\n%s", err), 1
+	}
+
+	context["key"] = key
+	return "", 0
+}
+
+func init() {
+	kdfTuneCmd.Flags().StringVar(&kdfAlgorithm, "algorithm", core.KDFArgon2id, "The key derivation function to benchmark, argon2id or scrypt")
+	kdfTuneCmd.Flags().DurationVar(&kdfTarget, "target", 500*time.Millisecond, "How long deriving a key should take")
+	kdfTuneCmd.Flags().BoolVar(&kdfApply, "apply", false, "Re-encrypt your storage with the recommended parameters")
+	kdfCmd.AddCommand(kdfTuneCmd)
+
+	RootCmd.AddCommand(kdfCmd)
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"sort"
 	"text/template"
 
 	"github.com/ElyKar/mpm/core"
@@ -30,14 +31,34 @@ var listCmd = &cobra.Command{
 var listAllCmd = &cobra.Command{
 	Use:   "all",
 	Short: "List all sections and passwords",
-	Run:   chainNodes(storageExists, listAllFunc),
+	Run:   chainNodes(outputValid, storageExists, unlockNames, listAllFunc),
 }
 
-// Node for listing all the content of the storage. It requires the storage from the context.
+// Node for listing all the content of the storage. It requires the storage from the context, unlocked if its names are encrypted.
 func listAllFunc(context map[string]interface{}) (string, int) {
 	storage := (context["storage"]).(*core.Storage)
 
-	entries := storage.ListAll()
+	entries, err := storage.ListAll()
+	if err != nil {
+		return err.Error(), 1
+	}
+
+	if output != outputText {
+		var rows [][]string
+		sections := make([]string, 0, len(entries))
+		for sec := range entries {
+			sections = append(sections, sec)
+		}
+		sort.Strings(sections)
+		for _, sec := range sections {
+			for _, pass := range entries[sec] {
+				rows = append(rows, []string{sec, pass})
+			}
+		}
+
+		printOutput(entries, rows)
+		return "", 0
+	}
 	tmpl := template.Must(template.New("allTmpl").Parse(listAllTmpl))
 
 	fmt.Println("Here are the passwords stored:")
@@ -49,14 +70,22 @@ func listAllFunc(context map[string]interface{}) (string, int) {
 var listSectionsCmd = &cobra.Command{
 	Use:   "sections",
 	Short: "List all sections stored",
-	Run:   chainNodes(storageExists, listSectionsFunc),
+	Run:   chainNodes(outputValid, storageExists, unlockNames, listSectionsFunc),
 }
 
-// Node for listing all the sections of the storage. It requires the storage from the context.
+// Node for listing all the sections of the storage. It requires the storage from the context, unlocked if its names are encrypted.
 func listSectionsFunc(context map[string]interface{}) (string, int) {
 	storage := (context["storage"]).(*core.Storage)
 
-	entries := storage.ListSections()
+	entries, err := storage.ListSections()
+	if err != nil {
+		return err.Error(), 1
+	}
+
+	if output != outputText {
+		printOutput(entries, listRows(entries))
+		return "", 0
+	}
 	tmpl := template.Must(template.New("sectionsTmpl").Parse(listSectionsTmpl))
 
 	fmt.Println("Here are the sections stored:")
@@ -68,23 +97,22 @@ func listSectionsFunc(context map[string]interface{}) (string, int) {
 var listPasswordCmd = &cobra.Command{
 	Use:   "passwords --section <section>",
 	Short: "List all passwords of a section",
-	Run:   chainNodes(sectionRequired, storageExists, listPasswordFunc),
+	Run:   chainNodes(sectionRequired, outputValid, storageExists, unlockNames, listPasswordFunc),
 }
 
-// Internal node, only the section flag is required to be non-empty
-func sectionRequired(context map[string]interface{}) (string, int) {
-	if section == "" {
-		return "You need to provide a section !", 1
-	}
-
-	return "", 0
-}
-
-// Node for listing all the passwords of a given section. It requires the storage from the context.
+// Node for listing all the passwords of a given section. It requires the storage from the context, unlocked if its names are encrypted.
 func listPasswordFunc(context map[string]interface{}) (string, int) {
 	storage := (context["storage"]).(*core.Storage)
 
-	entries := storage.ListPasswords(section)
+	entries, err := storage.ListPasswords(section)
+	if err != nil {
+		return err.Error(), 1
+	}
+
+	if output != outputText {
+		printOutput(entries, listRows(entries))
+		return "", 0
+	}
 	tmpl := template.Must(template.New("allTmpl").Parse(listAllTmpl))
 
 	fmt.Println("Here are the passwords stored:")
@@ -92,7 +120,19 @@ func listPasswordFunc(context map[string]interface{}) (string, int) {
 	return "", 0
 }
 
+// One row per name, for the tsv format
+func listRows(names []string) [][]string {
+	rows := make([][]string, len(names))
+	for i, name := range names {
+		rows[i] = []string{name}
+	}
+	return rows
+}
+
 func init() {
+	addOutputFlag(listAllCmd)
+	addOutputFlag(listSectionsCmd)
+	addOutputFlag(listPasswordCmd)
 	listPasswordCmd.Flags().StringVar(&section, "section", "", "The section to list passwords for")
 	listCmd.AddCommand(listPasswordCmd)
 	listCmd.AddCommand(listAllCmd)
@@ -0,0 +1,243 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ElyKar/mpm/core"
+	"github.com/howeyc/gopass"
+	"github.com/spf13/cobra"
+)
+
+// Flag of the merge, empty to ask for each conflict
+var mergeStrategy string
+
+// Merges another vault into the current one
+var mergeCmd = &cobra.Command{
+	Use:   "merge <other-vault> [--strategy newest|ours|theirs] [--dry-run] [--other-passphrase-fd <fd>]",
+	Short: "Merge another copy of your storage into this one",
+	Long: `Merges another vault, a registered name or the path of a master file, into the one in use: typically a copy of your storage which was edited on its own. Both are decrypted with their passphrase, which can differ, and the other vault is left untouched.
+The passwords changed in one vault only take that change: a password more recent than the other one, which replaced it (the other one being in its history), is taken as an update of it, and a password removed after the last change of the other one is removed. The passwords changed in both vaults, or whose details differ while they have the same password, are conflicts: you are asked which one to keep, unless --strategy is given (newest, ours or theirs). When both are kept, the password which was not kept stays in the history.
+--dry-run shows what would be merged without writing anything. In non-interactive mode, the passphrase of the other vault comes from --other-passphrase-fd or $` + OtherPassphraseEnv + `, and --strategy is needed if there are conflicts.`,
+	Args: cobra.ExactArgs(1),
+	Run:  chainNodes(mergeStrategyValid, lockStorage, storageExists, verifyPassphrase, readOtherVault, mergeFunc, updateStore),
+}
+
+// Node asserting the strategy of the merge is known
+func mergeStrategyValid(context map[string]interface{}) (string, int) {
+	if mergeStrategy == "" {
+		return "", 0
+	}
+
+	if _, err := core.MergeStrategy(mergeStrategy).Resolve(&core.MergeConflict{}); err != nil {
+		return err.Error(), exitUsage
+	}
+	return "", 0
+}
+
+// Node reading the vault to merge, given as argument, and its secret key (see unlockOther). On success, the vault and its key are stored in the context under 'other' and 'otherKey'.
+func readOtherVault(context map[string]interface{}) (string, int) {
+	path, err := core.ResolveVault((context["args"]).([]string)[0])
+	if err != nil {
+		return fmt.Sprintf("Impossible to find the vault to merge.\n%s", err), exitFailure
+	}
+	if abs, err := filepath.Abs(path); err == nil && abs == core.VaultPath() {
+		return "This is the vault in use, there is nothing to merge", exitUsage
+	}
+
+	other, err := core.GetStorageAt(path)
+	if tamperErr, ok := err.(*core.TamperError); ok {
+		return tamperMessage(tamperErr), exitTampered
+	} else if err != nil {
+		return fmt.Sprintf("No storage found in %s.\n%s", path, err), exitNotFound
+	}
+	context["other"] = other
+
+	otherKey, msg, code := unlockOther(context, other, path)
+	if msg != "" || code != 0 {
+		return msg, code
+	}
+
+	context["otherKey"] = otherKey
+	return "", 0
+}
+
+// Gets the secret key of another version of the storage, named as given in the messages, and checks its integrity with it. A copy of the storage sharing its secret key needs no passphrase, the passphrase already entered is tried next, then the one of the other version is given or prompted on the error output. The secret key of the storage is required from the context.
+func unlockOther(context map[string]interface{}, other *core.Storage, path string) ([]byte, string, int) {
+	key := (context["key"]).([]byte)
+
+	if other.Sealed() && other.Verify(key) == nil {
+		return key, "", 0
+	}
+
+	var passphrase string
+	if known, ok := (context["passphrase"]).(string); ok && other.CheckPassphrase(known) == nil {
+		passphrase = known
+	} else if given, ok, err := givenOtherPassphrase(); err != nil {
+		return nil, fmt.Sprintf("Impossible to read the passphrase of %s.\n%s", path, err), exitFailure
+	} else if ok {
+		passphrase = given
+	} else if nonInteractive {
+		msg, code := needsInput("passphrase of "+path, "give it with --other-passphrase-fd or $"+OtherPassphraseEnv)
+		return nil, msg, code
+	} else {
+		prompted, err := gopass.GetPasswdPrompt(fmt.Sprintf("Enter the passphrase of %s: ", path), false, os.Stdin, os.Stderr)
+		if err != nil {
+			return nil, fmt.Sprintf("An error occurred !\n%s", err), 1
+		}
+		passphrase = string(prompted)
+	}
+
+	if other.CheckPassphrase(passphrase) != nil {
+		return nil, fmt.Sprintf("Wrong passphrase for %s", path), exitWrongPassphrase
+	}
+
+	otherKey, err := other.DeriveKey(passphrase)
+	if err != nil {
+		return nil, fmt.Sprintf("Impossible to derive the secret key of %s.\n%s", path, err), 1
+	}
+	if err = other.Verify(otherKey); err != nil {
+		if tamperErr, ok := err.(*core.TamperError); ok {
+			return nil, tamperMessage(tamperErr), exitTampered
+		}
+		return nil, err.Error(), 1
+	}
+
+	return otherKey, "", 0
+}
+
+// Node merging the other vault into the storage. It requires the storage, its secret key, and the other vault and its key from the context. Nothing is left to write for a dry run or if nothing changed.
+func mergeFunc(context map[string]interface{}) (string, int) {
+	storage := (context["storage"]).(*core.Storage)
+	key := (context["key"]).([]byte)
+	other := (context["other"]).(*core.Storage)
+	otherKey := (context["otherKey"]).([]byte)
+	path := (context["args"]).([]string)[0]
+
+	resolve := core.MergeStrategy(mergeStrategy).Resolve
+	if mergeStrategy == "" {
+		if dryRun {
+			resolve = core.MergeNewest.Resolve
+		} else {
+			resolve = askConflict(path)
+		}
+	}
+
+	report, err := storage.Merge(key, nil, other, otherKey, resolve)
+	if _, ok := err.(*core.MergeKeyError); ok {
+		return fmt.Sprintf("%s can't be read with its secret key, it has been tampered with.", path), exitTampered
+	} else if msg, ok := err.(*needsInputError); ok {
+		return msg.Error(), exitNeedsInput
+	} else if err != nil {
+		return fmt.Sprintf("Impossible to merge %s.\n%s", path, err), 1
+	}
+	printMergeReport(report, path)
+
+	if dryRun {
+		return "\nNothing was written, as --dry-run was given.", 0
+	} else if report.Changes() == 0 {
+		return "\nYour storage is left as it is.", 0
+	}
+	return "", 0
+}
+
+// Error of a resolver which needs an answer in non-interactive mode
+type needsInputError struct {
+	conflicts string
+}
+
+func (e *needsInputError) Error() string {
+	msg, _ := needsInput("choice between both versions of "+e.conflicts, "give --strategy newest, ours or theirs")
+	return msg
+}
+
+// Asks which version of a conflicting password to keep, in interactive mode
+func askConflict(other string) core.MergeResolver {
+	return func(conflict *core.MergeConflict) (bool, error) {
+		if nonInteractive {
+			return false, &needsInputError{conflict.Section + "/" + conflict.Name}
+		}
+
+		fmt.Printf("\n%s/%s has been changed in both vaults:\n", conflict.Section, conflict.Name)
+		fmt.Printf("    yours:  %s\n", describeVersion(conflict.OursRemoved, conflict.Ours))
+		fmt.Printf("    theirs: %s (%s)\n", describeVersion(conflict.TheirsRemoved, conflict.Theirs), other)
+		if conflict.SamePassword {
+			fmt.Println("Both have the same password, their details differ.")
+		}
+
+		for {
+			fmt.Println("Keep yours or theirs ? [y/t]")
+			answer, err := readLine()
+			if err != nil {
+				return false, err
+			}
+			switch answer {
+			case "y":
+				return false, nil
+			case "t":
+				return true, nil
+			}
+		}
+	}
+}
+
+// Describes a version of a conflicting password
+func describeVersion(removed bool, date time.Time) string {
+	if removed {
+		return "removed on " + formatDate(date)
+	}
+	return "modified on " + formatDate(date)
+}
+
+// Prints what a merge changed in the storage, the other side being named as given
+func printMergeReport(report *core.MergeReport, other string) {
+	parts := []struct {
+		title string
+		refs  []core.EntryRef
+	}{
+		{"Added", report.Added},
+		{"Updated", report.Updated},
+		{"Removed", report.Deleted},
+	}
+
+	for _, part := range parts {
+		if len(part.refs) == 0 {
+			continue
+		}
+		fmt.Printf("\n%s (%d):\n", part.title, len(part.refs))
+		for _, ref := range part.refs {
+			fmt.Printf("    - %s/%s\n", ref.Section, ref.Name)
+		}
+	}
+
+	if len(report.Conflicts) > 0 {
+		fmt.Printf("\nChanged on both sides (%d):\n", len(report.Conflicts))
+		for _, conflict := range report.Conflicts {
+			kept := "kept yours"
+			switch {
+			case conflict.TookTheirs && conflict.TheirsRemoved:
+				kept = "removed, as in " + other
+			case conflict.TookTheirs:
+				kept = "kept the one of " + other
+			case conflict.OursRemoved:
+				kept = "kept removed"
+			}
+			fmt.Printf("    - %s/%s: %s\n", conflict.Section, conflict.Name, kept)
+		}
+		fmt.Println("When both passwords differ, the one which was not kept is in the history.")
+	}
+
+	if report.Changes() == 0 {
+		fmt.Printf("Nothing to take from %s.\n", other)
+	}
+}
+
+func init() {
+	mergeCmd.Flags().StringVar(&mergeStrategy, "strategy", "", "Solve the conflicts without asking, keeping the newest version, ours or theirs")
+	mergeCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only show what would be merged, conflicts being solved by the newest version unless --strategy is given")
+	mergeCmd.Flags().IntVar(&otherPassphraseFd, "other-passphrase-fd", -1, "Read the passphrase of the other vault from the first line of this file descriptor")
+
+	RootCmd.AddCommand(mergeCmd)
+}
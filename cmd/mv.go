@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/ElyKar/mpm/core"
+	"github.com/spf13/cobra"
+)
+
+// Destination of the move, each one defaults to its source counterpart
+var toSection string
+var toName string
+
+// Moves or renames a password, or renames a whole section when no name is given
+var mvCmd = &cobra.Command{
+	Use:   "mv --section <section> [--name <name>] [--to-section <section>] [--to-name <name>] [--force]",
+	Short: "Moves or renames a password or a section",
+	Long: `Moves a password to another section and/or renames it.
+Without --name, the whole section is renamed to --to-section.`,
+	Run: chainNodes(sectionRequired, mvTargetRequired, lockStorage, storageExists, verifyPassphrase, mvSourceExists, mvSectionFree, verifyEraseAt(&toSection, &toName, "Password exist at the destination, are you sure you want to erase it ?"), mvFunc, updateStore),
+}
+
+// Internal node, asserts the destination is valid and differs from the source. It fills in the defaults of the destination.
+func mvTargetRequired(context map[string]interface{}) (string, int) {
+	if name == "" {
+		if toSection == "" || toName != "" {
+			return "You need to provide the new name of the section with --to-section !", exitUsage
+		}
+	} else {
+		if toSection == "" {
+			toSection = section
+		}
+		if toName == "" {
+			toName = name
+		}
+	}
+
+	if toSection == section && toName == name {
+		return "The destination is the same as the source !", exitUsage
+	}
+
+	return "", 0
+}
+
+// Internal node, asserts the section or password to move exists. Storage is required from the context
+func mvSourceExists(context map[string]interface{}) (string, int) {
+	if name == "" {
+		return sectionExists(context)
+	}
+
+	return passwordExists(context)
+}
+
+// Internal node, asserts the new name of a renamed section is free: sections are never merged. Storage is required from the context
+func mvSectionFree(context map[string]interface{}) (string, int) {
+	storage := context["storage"].(*core.Storage)
+	if name == "" && storage.HasSection(toSection) {
+		return fmt.Sprintf("Section %s already exists", toSection), 1
+	}
+
+	return "", 0
+}
+
+// mvFunc requires the storage and secret key from the context, and a valid source and destination
+func mvFunc(context map[string]interface{}) (string, int) {
+	storage := (context["storage"]).(*core.Storage)
+	key := (context["key"]).([]byte)
+
+	if name == "" {
+		if err := storage.RenameSection(key, section, toSection); err != nil {
+			return err.Error(), 1
+		}
+		return "", 0
+	}
+
+	if overwrite, _ := context["overwrite"].(bool); overwrite {
+		storage.Delete(toSection, toName)
+	}
+
+	if err := storage.Move(key, section, name, toSection, toName); err != nil {
+		return err.Error(), 1
+	}
+
+	return "", 0
+}
+
+func init() {
+	mvCmd.Flags().StringVar(&section, "section", "", "The section to move the password from, or the section to rename")
+	mvCmd.Flags().StringVar(&name, "name", "", "The password you want to move")
+	mvCmd.Flags().StringVar(&toSection, "to-section", "", "The section to move the password to, or the new name of the section")
+	mvCmd.Flags().StringVar(&toName, "to-name", "", "The new name of the password")
+	mvCmd.Flags().BoolVar(&force, "force", false, "Do not ask for confirmation")
+
+	RootCmd.AddCommand(mvCmd)
+}
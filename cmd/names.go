@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"github.com/ElyKar/mpm/core"
+	"github.com/spf13/cobra"
+)
+
+// Root names command
+var namesCmd = &cobra.Command{
+	Use:   "names [encrypt|decrypt]",
+	Short: "Encrypt or decrypt the names of your sections and passwords",
+	Long: `By default, the names of your sections and passwords are stored in clear, so that listing them does not require your passphrase.
+Once encrypted, your storage no longer reveals which services you use, but 'mpm list' asks for your passphrase.`,
+}
+
+// Encrypts the names of the storage
+var namesEncryptCmd = &cobra.Command{
+	Use:   "encrypt",
+	Short: "Encrypt the names of your sections and passwords",
+	Run:   chainNodes(lockStorage, storageExists, verifyPassphrase, setEncryptedNames(true), updateStore),
+}
+
+// Decrypts the names of the storage
+var namesDecryptCmd = &cobra.Command{
+	Use:   "decrypt",
+	Short: "Store the names of your sections and passwords in clear",
+	Run:   chainNodes(lockStorage, storageExists, verifyPassphrase, setEncryptedNames(false), updateStore),
+}
+
+// Creates the node switching the names of the storage to the given mode. It requires the storage from the context, unlocked.
+func setEncryptedNames(enabled bool) nodeFunc {
+	return func(context map[string]interface{}) (string, int) {
+		storage := (context["storage"]).(*core.Storage)
+
+		if storage.EncryptedNames == enabled {
+			return "Nothing to do, your storage is already in this mode.", 0
+		}
+
+		if err := storage.SetEncryptedNames(enabled); err != nil {
+			return err.Error(), 1
+		}
+
+		return "", 0
+	}
+}
+
+func init() {
+	namesCmd.AddCommand(namesEncryptCmd)
+	namesCmd.AddCommand(namesDecryptCmd)
+
+	RootCmd.AddCommand(namesCmd)
+}
@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ElyKar/mpm/core"
+	"github.com/howeyc/gopass"
+	"github.com/spf13/cobra"
+)
+
+// Parameters of a one-time password generator given as a bare secret, an otpauth URI carries its own
+var otpCounterBased bool
+var otpAlgorithm string
+var otpDigits int
+var otpPeriod int
+var otpCounter uint64
+
+// Computes the current one-time password of an entry
+var otpCmd = &cobra.Command{
+	Use:   "otp --section <section> --name <name> [--timeout <duration>] [--no-clear] [--stdout]",
+	Short: "Copy the one-time password of an entry to your clipboard",
+	Long: `Computes the current one-time password of the second factor stored with a password (TOTP or HOTP), and copies it to your clipboard like 'mpm get'. With --stdout, it is printed instead.
+The second factor is stored with 'mpm otp set'. The counter of an HOTP generator moves forward each time a code is computed.`,
+	Run: chainNodes(sectionAndNameRequired, lockStorage, storageExists, verifyPassphrase, passwordExists, otpFunc),
+}
+
+// Stores the second factor of an entry
+var otpSetCmd = &cobra.Command{
+	Use:   "set --section <section> --name <name> [--hotp] [--algorithm <algorithm>] [--digits <n>] [--period <seconds>] [--counter <n>]",
+	Short: "Store the second factor of an entry",
+	Long: `Stores the one-time password generator of an entry, encrypted like its password. The otpauth:// URI encoded in the QR code of the site, or its secret in base32, is prompted. In non-interactive mode, it is read from the standard input.
+With a bare secret, the generator is a TOTP (SHA1, 6 digits, every 30 seconds) unless the flags say otherwise.`,
+	Run: chainNodes(sectionAndNameRequired, lockStorage, storageExists, verifyPassphrase, passwordExists, readOTP, otpSetFunc, updateStore),
+}
+
+// Removes the second factor of an entry
+var otpRmCmd = &cobra.Command{
+	Use:   "rm --section <section> --name <name> [--force]",
+	Short: "Remove the second factor of an entry",
+	Run: chainNodes(sectionAndNameRequired, lockStorage, storageExists, verifyPassphrase, passwordExists, confirm(func(context map[string]interface{}) string {
+		return fmt.Sprintf("Are you sure you want to remove the second factor of %s ?", name)
+	}), otpRmFunc, updateStore),
+}
+
+// Node computing the one-time password and copying it to the clipboard. The counter of an HOTP generator is saved. It requires the storage and secret key from the context.
+func otpFunc(context map[string]interface{}) (string, int) {
+	storage := (context["storage"]).(*core.Storage)
+	key := (context["key"]).([]byte)
+
+	entry, err := storage.GetEntry(section, name)
+	if err != nil {
+		return err.Error(), exitNotFound
+	}
+
+	otp, err := storage.DecodeOTP(key, section, name, entry)
+	if authErr, ok := err.(*core.AuthError); ok {
+		return fmt.Sprintf("%s\nRefusing to use its second factor.", authErr), exitTampered
+	} else if err != nil {
+		return fmt.Sprintf("Impossible to read the second factor of your password.\n%s", err), 1
+	} else if otp == nil {
+		return fmt.Sprintf("Password %s has no second factor, store it with 'mpm otp set'", name), exitNotFound
+	}
+
+	now := time.Now()
+	code, err := otp.Code(now)
+	if err != nil {
+		return err.Error(), 1
+	}
+
+	// The counter moves forward before the code is given, so that a code is never given twice
+	if otp.Type == core.OTPCounter {
+		otp.Next()
+		updated := *entry
+		if updated.OTP, err = storage.EncodeOTP(key, section, name, otp); err != nil {
+			return fmt.Sprintf("Impossible to encrypt the second factor of your password.\n%s", err), 1
+		}
+		if err = storage.SetEntry(section, name, &updated); err != nil {
+			return err.Error(), 1
+		}
+		if msg, code := updateStore(context); code != 0 {
+			return msg, code
+		}
+	}
+
+	if printStdout {
+		fmt.Println(code)
+		return "", 0
+	}
+
+	if err = copyToClipboard(code); err != nil {
+		return fmt.Sprintf("Impossible to copy to clipboard.\n%s", err), 1
+	}
+	if otp.Type == core.OTPTime {
+		return fmt.Sprintf("%s, it expires in %ds", clipboardMessage("one-time password"), int(otp.Remaining(now).Seconds()+0.5)), 0
+	}
+	return clipboardMessage("one-time password"), 0
+}
+
+// Node reading the otpauth URI or the secret of the second factor, prompted or from the standard input in non-interactive mode. On success, the generator is stored in the context under 'otp'.
+func readOTP(context map[string]interface{}) (string, int) {
+	value, given, err := givenPassword()
+	if err != nil {
+		return fmt.Sprintf("An error occurred !\n%s", err), 1
+	} else if !given {
+		prompted, err := gopass.GetPasswdPrompt("Enter the otpauth:// URI or the secret of your second factor: ", false, os.Stdin, os.Stderr)
+		if err != nil {
+			return fmt.Sprintf("An error occurred !\n%s", err), 1
+		}
+		value = string(prompted)
+	}
+
+	value = strings.TrimSpace(value)
+	var otp *core.OTP
+	if strings.HasPrefix(strings.ToLower(value), "otpauth:") {
+		otp, err = core.ParseOTPURI(value)
+	} else {
+		otp = &core.OTP{Type: core.OTPTime, Secret: value, Algorithm: otpAlgorithm, Digits: otpDigits, Period: otpPeriod}
+		if otpCounterBased {
+			otp.Type, otp.Period, otp.Counter = core.OTPCounter, 0, otpCounter
+		}
+		otp, err = normalizedOTP(otp)
+	}
+	if err != nil {
+		return fmt.Sprintf("Invalid second factor.\n%s", err), exitUsage
+	}
+
+	context["otp"] = otp
+	return "", 0
+}
+
+// Normalizes a generator given by its parameters, see core.NewOTP
+func normalizedOTP(otp *core.OTP) (*core.OTP, error) {
+	normalized, err := core.NewOTP(otp.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	normalized.Type, normalized.Algorithm, normalized.Digits, normalized.Period, normalized.Counter = otp.Type, strings.ToUpper(otp.Algorithm), otp.Digits, otp.Period, otp.Counter
+	return normalized, normalized.Validate()
+}
+
+// Node storing the second factor in the context with the entry. It requires the storage and secret key from the context.
+func otpSetFunc(context map[string]interface{}) (string, int) {
+	storage := (context["storage"]).(*core.Storage)
+	key := (context["key"]).([]byte)
+	otp := (context["otp"]).(*core.OTP)
+
+	entry, err := storage.GetEntry(section, name)
+	if err != nil {
+		return err.Error(), exitNotFound
+	}
+
+	updated := *entry
+	if updated.OTP, err = storage.EncodeOTP(key, section, name, otp); err != nil {
+		return fmt.Sprintf("Impossible to encrypt the second factor of your password.\n%s", err), 1
+	}
+	updated.Modified = time.Now().UTC()
+
+	if err = storage.SetEntry(section, name, &updated); err != nil {
+		return err.Error(), 1
+	}
+
+	fmt.Printf("Second factor: %s\n", otp)
+	return "", 0
+}
+
+// Node removing the second factor of the entry. It requires the storage from the context.
+func otpRmFunc(context map[string]interface{}) (string, int) {
+	storage := (context["storage"]).(*core.Storage)
+
+	entry, err := storage.GetEntry(section, name)
+	if err != nil {
+		return err.Error(), exitNotFound
+	}
+	if entry.OTP == "" {
+		return fmt.Sprintf("Password %s has no second factor", name), exitNotFound
+	}
+
+	updated := *entry
+	updated.OTP = ""
+	updated.Modified = time.Now().UTC()
+	if err = storage.SetEntry(section, name, &updated); err != nil {
+		return err.Error(), 1
+	}
+	return "", 0
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{otpCmd, otpSetCmd, otpRmCmd} {
+		cmd.Flags().StringVar(&section, "section", "", "The section of the password")
+		cmd.Flags().StringVar(&name, "name", "", "The name of the password")
+	}
+	otpCmd.Flags().BoolVar(&printStdout, "stdout", false, "Print the one-time password instead of copying it to your clipboard")
+	addClipboardFlags(otpCmd)
+	otpSetCmd.Flags().BoolVar(&otpCounterBased, "hotp", false, "The secret is the one of a counter-based generator (HOTP)")
+	otpSetCmd.Flags().StringVar(&otpAlgorithm, "algorithm", "SHA1", "The hash function: SHA1, SHA256 or SHA512")
+	otpSetCmd.Flags().IntVar(&otpDigits, "digits", 6, "The number of digits of the codes, from 6 to 8")
+	otpSetCmd.Flags().IntVar(&otpPeriod, "period", 30, "The lifetime of the codes in seconds, for a time-based generator")
+	otpSetCmd.Flags().Uint64Var(&otpCounter, "counter", 0, "The counter of the next code, for a counter-based generator")
+	otpRmCmd.Flags().BoolVar(&force, "force", false, "Do not ask for confirmation")
+	otpCmd.AddCommand(otpSetCmd)
+	otpCmd.AddCommand(otpRmCmd)
+
+	RootCmd.AddCommand(otpCmd)
+}
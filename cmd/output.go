@@ -0,0 +1,242 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Formats of the output of the list and get commands: text for humans, the others for programs
+const (
+	outputText = "text"
+	outputJSON = "json"
+	outputYAML = "yaml"
+	outputTSV  = "tsv"
+)
+
+// Format of the output, given by --output
+var output string
+
+// Registers the --output flag on a command
+func addOutputFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&output, "output", outputText, "The format of the output: text, json, yaml or tsv")
+}
+
+// Tells whether the command prints data for programs on the standard output: with --output json, yaml or tsv, with --stdout, or when a node set 'rawOutput' in the context
+func rawOutput(context map[string]interface{}) bool {
+	raw, _ := context["rawOutput"].(bool)
+	return raw || printStdout || (output != "" && output != outputText)
+}
+
+// Node asserting the output format is known
+func outputValid(context map[string]interface{}) (string, int) {
+	switch output {
+	case outputText, outputJSON, outputYAML, outputTSV:
+		return "", 0
+	}
+
+	return fmt.Sprintf("Unknown output format %s, expected text, json, yaml or tsv", output), exitUsage
+}
+
+// A field of a record
+type recordField struct {
+	Key   string
+	Value interface{}
+}
+
+// A record is an object whose fields keep their order in the output, unlike a map
+type record []recordField
+
+// MarshalJSON writes the record as a JSON object, its fields in order
+func (r record) MarshalJSON() ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+	for i, field := range r {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+
+		key, err := marshalJSON(field.Key, "")
+		if err != nil {
+			return nil, err
+		}
+		value, err := marshalJSON(field.Value, "")
+		if err != nil {
+			return nil, err
+		}
+
+		buffer.Write(key)
+		buffer.WriteByte(':')
+		buffer.Write(value)
+	}
+	buffer.WriteByte('}')
+
+	return buffer.Bytes(), nil
+}
+
+// Marshals a value to JSON, indented unless indent is empty. Unlike json.Marshal, '<', '>' and '&' are not escaped: they are common in passwords, and the output is not meant for HTML.
+func marshalJSON(value interface{}, indent string) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", indent)
+
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buffer.Bytes(), []byte("\n")), nil
+}
+
+// Formats a date for the output, nil if it is unknown
+func outputDate(date time.Time) interface{} {
+	if date.IsZero() {
+		return nil
+	}
+
+	return date.UTC().Format(time.RFC3339)
+}
+
+// Writes a value in the json or yaml format, or its rows in the tsv format. The values are made of strings, numbers, booleans, nil, lists of strings or records, maps and records.
+func printOutput(value interface{}, rows [][]string) error {
+	switch output {
+	case outputJSON:
+		data, err := marshalJSON(value, "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	case outputYAML:
+		var builder strings.Builder
+		writeYAML(&builder, value, 0)
+		fmt.Print(builder.String())
+	case outputTSV:
+		for _, row := range rows {
+			escaped := make([]string, len(row))
+			for i, cell := range row {
+				escaped[i] = tsvEscaper.Replace(cell)
+			}
+			fmt.Println(strings.Join(escaped, "\t"))
+		}
+	}
+
+	return nil
+}
+
+// Cells of the tsv format can't hold tabs nor line breaks, they are escaped with backslashes
+var tsvEscaper = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r")
+
+// Converts the maps to records, their keys sorted
+func toRecord(value interface{}) (record, bool) {
+	var fields record
+	switch v := value.(type) {
+	case record:
+		return v, true
+	case map[string][]string:
+		for key, sub := range v {
+			fields = append(fields, recordField{key, sub})
+		}
+	case map[string]string:
+		for key, sub := range v {
+			fields = append(fields, recordField{key, sub})
+		}
+	default:
+		return nil, false
+	}
+
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Key < fields[j].Key })
+	return fields, true
+}
+
+// Writes a value in the yaml format, its blocks indented by two spaces per level. Numbers and booleans are written as is, all the strings are double-quoted, with the escapes of Go which yaml understands too: no name or password can be mistaken for a number, a boolean or null.
+func writeYAML(builder *strings.Builder, value interface{}, indent int) {
+	pad := strings.Repeat("  ", indent)
+
+	if fields, ok := toRecord(value); ok {
+		if len(fields) == 0 {
+			builder.WriteString(pad + "{}\n")
+		}
+		for _, field := range fields {
+			builder.WriteString(pad + strconv.Quote(field.Key) + ":")
+			if yamlBlock(field.Value) {
+				builder.WriteString("\n")
+				writeYAML(builder, field.Value, indent+1)
+			} else {
+				builder.WriteString(" " + yamlScalar(field.Value) + "\n")
+			}
+		}
+		return
+	}
+
+	if list, ok := value.([]string); ok {
+		if len(list) == 0 {
+			builder.WriteString(pad + "[]\n")
+		}
+		for _, item := range list {
+			builder.WriteString(pad + "- " + strconv.Quote(item) + "\n")
+		}
+		return
+	}
+
+	// The records of a list are written one level deeper, the dash taking the place of the indentation of their first line
+	if list, ok := value.([]record); ok {
+		if len(list) == 0 {
+			builder.WriteString(pad + "[]\n")
+		}
+		for _, item := range list {
+			var sub strings.Builder
+			writeYAML(&sub, item, indent+1)
+			builder.WriteString(pad + "- " + strings.TrimPrefix(sub.String(), pad+"  "))
+		}
+		return
+	}
+
+	builder.WriteString(pad + yamlScalar(value) + "\n")
+}
+
+// Tells whether a value is written as a yaml block, on the lines following its key
+func yamlBlock(value interface{}) bool {
+	if fields, ok := toRecord(value); ok {
+		return len(fields) > 0
+	}
+
+	switch list := value.(type) {
+	case []string:
+		return len(list) > 0
+	case []record:
+		return len(list) > 0
+	}
+	return false
+}
+
+// Writes a value which is not a block in the yaml format
+func yamlScalar(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return strconv.Quote(v)
+	case bool, int, float64:
+		return fmt.Sprint(v)
+	case []string, []record:
+		return "[]"
+	}
+
+	if _, ok := toRecord(value); ok {
+		return "{}"
+	}
+	return strconv.Quote(fmt.Sprint(value))
+}
+
+// Warns the user when a secret is about to be printed on a terminal, where it may stay in the scrollback
+func warnTerminal() {
+	if stat, err := os.Stdout.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprintln(os.Stderr, "Warning: your secret is displayed on your terminal, it may stay in its scrollback.")
+	}
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/ElyKar/mpm/core"
+	"github.com/spf13/cobra"
+)
+
+// Removes a password from the storage
+var rmCmd = &cobra.Command{
+	Use:   "rm --section <section> --name <name> [--force]",
+	Short: "Removes a password from the storage",
+	Run: chainNodes(sectionAndNameRequired, lockStorage, storageExists, verifyPassphrase, passwordExists, confirm(func(context map[string]interface{}) string {
+		return fmt.Sprintf("Are you sure you want to remove %s from section %s ?", name, section)
+	}), rmFunc, updateStore),
+}
+
+// rmFunc requires the storage from the context, and also non-empty name and section
+func rmFunc(context map[string]interface{}) (string, int) {
+	storage := (context["storage"]).(*core.Storage)
+
+	if err := storage.Delete(section, name); err != nil {
+		return err.Error(), 1
+	}
+
+	return "", 0
+}
+
+func init() {
+	rmCmd.Flags().StringVar(&section, "section", "", "The section to remove the password from")
+	rmCmd.Flags().StringVar(&name, "name", "", "The password you want to remove")
+	rmCmd.Flags().BoolVar(&force, "force", false, "Do not ask for confirmation")
+
+	RootCmd.AddCommand(rmCmd)
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/ElyKar/mpm/core"
+	"github.com/spf13/cobra"
+)
+
+// Removes a whole section from the storage
+var rmSectionCmd = &cobra.Command{
+	Use:   "rmsection --section <section> [--force]",
+	Short: "Removes a section and all of its passwords",
+	Run: chainNodes(sectionRequired, lockStorage, storageExists, verifyPassphrase, sectionExists, confirm(func(context map[string]interface{}) string {
+		storage := (context["storage"]).(*core.Storage)
+		passwords, _ := storage.ListPasswords(section)
+		return fmt.Sprintf("Are you sure you want to remove section %s and its %d password(s) ?", section, len(passwords))
+	}), rmSectionFunc, updateStore),
+}
+
+// rmSectionFunc requires the storage from the context, and also a non-empty section
+func rmSectionFunc(context map[string]interface{}) (string, int) {
+	storage := (context["storage"]).(*core.Storage)
+
+	if err := storage.DeleteSection(section); err != nil {
+		return err.Error(), 1
+	}
+
+	return "", 0
+}
+
+func init() {
+	rmSectionCmd.Flags().StringVar(&section, "section", "", "The section you want to remove")
+	rmSectionCmd.Flags().BoolVar(&force, "force", false, "Do not ask for confirmation")
+
+	RootCmd.AddCommand(rmSectionCmd)
+}
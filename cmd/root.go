@@ -2,7 +2,13 @@ package cmd
 
 // The packages contains all commands and helpers for CLI interaction
 
-import "github.com/spf13/cobra"
+import (
+	"github.com/ElyKar/mpm/core"
+	"github.com/spf13/cobra"
+)
+
+// Global flag selecting the vault, either a registered name or a path
+var vault string
 
 // The root command of this interface
 var RootCmd = &cobra.Command{
@@ -10,4 +16,23 @@ var RootCmd = &cobra.Command{
 	Short: "mpm is a sweet and tiny password manager written in Go.",
 	Long: `mpm is a CLI password manager made to handle all of your passwords.
 You can customise each password by choosing which characters it may contain and its total length.`,
+	PersistentPreRunE: selectVault,
+}
+
+// Selects the vault used by the command, before it runs. See core.ResolveVault for the order of precedence.
+func selectVault(cmd *cobra.Command, args []string) error {
+	path, err := core.ResolveVault(vault)
+	if err != nil {
+		return err
+	}
+
+	return core.SetVaultPath(path)
+}
+
+func init() {
+	RootCmd.PersistentFlags().BoolVar(&nonInteractive, "non-interactive", false, "Never prompt: fail with exit code 4 when an answer is missing")
+	RootCmd.PersistentFlags().IntVar(&passphraseFd, "passphrase-fd", -1, "Read the passphrase from the first line of this file descriptor")
+	RootCmd.PersistentFlags().BoolVar(&passphraseStdin, "passphrase-stdin", false, "Read the passphrase from the first line of the standard input")
+	RootCmd.PersistentFlags().IntVar(&newPassphraseFd, "new-passphrase-fd", -1, "Read the new passphrase of 'mpm change' from the first line of this file descriptor")
+	RootCmd.PersistentFlags().StringVar(&vault, "vault", "", "The vault to use, either a registered name or a path (defaults to $"+core.VaultEnv+", then to the vault in use)")
 }
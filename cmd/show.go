@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/ElyKar/mpm/core"
+	"github.com/spf13/cobra"
+)
+
+// Template for showing the details of an entry. The password is never displayed.
+const showTmpl = `    Section:   {{ .Section }}
+    Name:      {{ .Name }}
+{{ with .Details.Username }}    Username:  {{ . }}
+{{ end }}{{ range .Details.URLs }}    URL:       {{ . }}
+{{ end }}{{ range $k, $v := .Details.Fields }}    {{ printf "%-10s" (print $k ":") }} {{ $v }}
+{{ end }}{{ with .Details.Policy }}    Policy:    {{ . }}
+{{ end }}{{ with .OTP }}    OTP:       {{ . }}
+{{ end }}    Created:   {{ date .Entry.Created }}
+    Modified:  {{ date .Entry.Modified }}
+{{ with .Details.Notes }}
+{{ . }}
+{{ end }}`
+
+// Shows the details of an entry
+var showCmd = &cobra.Command{
+	Use:   "show --section <section> --name <name>",
+	Short: "Show the details of a password",
+	Long:  `Shows the username, URLs, notes and custom fields stored along with a password. The password itself is not displayed, use 'mpm get'.`,
+	Run:   chainNodes(sectionAndNameRequired, storageExists, verifyPassphrase, showFunc),
+}
+
+// Node showing the details of the entry. It requires the storage and secret key from the context.
+func showFunc(context map[string]interface{}) (string, int) {
+	storage := (context["storage"]).(*core.Storage)
+	key := (context["key"]).([]byte)
+
+	entry, err := storage.GetEntry(section, name)
+	if err != nil {
+		return err.Error(), exitNotFound
+	}
+
+	details, err := storage.DecodeDetails(key, section, name, entry)
+	if err != nil {
+		return fmt.Sprintf("Impossible to read the details of your password.\n%s", err), 1
+	}
+
+	otp, err := storage.DecodeOTP(key, section, name, entry)
+	if err != nil {
+		return fmt.Sprintf("Impossible to read the second factor of your password.\n%s", err), 1
+	}
+
+	tmpl := template.Must(template.New("showTmpl").Funcs(template.FuncMap{"date": formatDate}).Parse(showTmpl))
+	tmpl.Execute(os.Stdout, struct {
+		Section string
+		Name    string
+		Entry   *core.Entry
+		Details *core.EntryDetails
+		OTP     *core.OTP
+	}{section, name, entry, details, otp})
+	return "", 0
+}
+
+// Formats a date of an entry, in local time. Entries created before dates were recorded have none.
+func formatDate(date time.Time) string {
+	if date.IsZero() {
+		return "unknown"
+	}
+
+	return date.Local().Format("2006-01-02 15:04:05")
+}
+
+func init() {
+	showCmd.Flags().StringVar(&section, "section", "", "The section of the password")
+	showCmd.Flags().StringVar(&name, "name", "", "The password you want to see the details of")
+
+	RootCmd.AddCommand(showCmd)
+}
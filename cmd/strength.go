@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/ElyKar/mpm/core"
+	"github.com/howeyc/gopass"
+	"github.com/spf13/cobra"
+)
+
+// Estimates the strength of a password
+var strengthCmd = &cobra.Command{
+	Use:   "strength [--section <section> --name <name>] [--output <format>]",
+	Short: "Estimate the strength of a password",
+	Long: `Estimates how hard a password is to guess, and lists the weaknesses found in it: common passwords, words, sequences, keyboard patterns, dates...
+The password is prompted, or read from the standard input in non-interactive mode. With --section and --name, the stored password is estimated instead.`,
+	Run: chainNodes(outputValid, strengthPassword, strengthFunc),
+}
+
+// Node reading the password to estimate, stored in the context under 'newPass': the stored one if a section or name is given, else the one typed by the user.
+func strengthPassword(context map[string]interface{}) (string, int) {
+	if section == "" && name == "" {
+		if password, given, err := givenPassword(); err != nil {
+			return fmt.Sprintf("An error occurred !\n%s", err), 1
+		} else if given {
+			context["newPass"] = []byte(password)
+			return "", 0
+		}
+
+		password, err := gopass.GetPasswdPrompt("Enter your password: ", false, os.Stdin, os.Stderr)
+		if err != nil {
+			return fmt.Sprintf("An error occurred !\n%s", err), 1
+		}
+		context["newPass"] = password
+		return "", 0
+	}
+
+	for _, node := range []nodeFunc{sectionAndNameRequired, storageExists, verifyPassphrase} {
+		if msg, code := node(context); msg != "" {
+			return msg, code
+		}
+	}
+
+	storage := context["storage"].(*core.Storage)
+	if _, err := storage.Get(section, name); err != nil {
+		return err.Error(), exitNotFound
+	}
+
+	password, err := readField(storage, context["key"].([]byte), "password")
+	if authErr, ok := err.(*core.AuthError); ok {
+		return authErr.Error(), exitTampered
+	} else if err != nil {
+		return fmt.Sprintf("An error occurred:\n%s", err), 1
+	}
+
+	context["newPass"] = []byte(password)
+	return "", 0
+}
+
+// Node printing the strength of the password in the context
+func strengthFunc(context map[string]interface{}) (string, int) {
+	strength := core.EstimateStrength(string(context["newPass"].([]byte)))
+
+	if output == outputText {
+		fmt.Println(strength)
+		return "", 0
+	}
+
+	patterns := strength.Patterns
+	if patterns == nil {
+		patterns = []string{}
+	}
+	entropy := math.Round(strength.Entropy*10) / 10
+
+	rows := [][]string{{"entropy", fmt.Sprint(entropy)}, {"level", strength.Level()}, {"weak", fmt.Sprint(strength.Weak())}}
+	for _, pattern := range patterns {
+		rows = append(rows, []string{"pattern", pattern})
+	}
+	printOutput(record{
+		{"entropy", entropy},
+		{"level", strength.Level()},
+		{"weak", strength.Weak()},
+		{"patterns", patterns},
+	}, rows)
+	return "", 0
+}
+
+func init() {
+	strengthCmd.Flags().StringVar(&section, "section", "", "The section of the stored password to estimate")
+	strengthCmd.Flags().StringVar(&name, "name", "", "The name of the stored password to estimate")
+	addOutputFlag(strengthCmd)
+
+	RootCmd.AddCommand(strengthCmd)
+}
@@ -0,0 +1,246 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ElyKar/mpm/core"
+	"github.com/spf13/cobra"
+)
+
+// Flags of the synchronization
+var syncRemote string
+var syncBranch string
+var syncReset bool
+
+// Synchronizes the storage with the remote
+var syncCmd = &cobra.Command{
+	Use:   "sync [init|remote] [--reset] [--force] [--other-passphrase-fd <fd>]",
+	Short: "Synchronize your storage with your other machines through git",
+	Long: `Synchronizes your storage with a git remote, set up with 'mpm sync init --remote <url>'. Each change made by mpm is committed to a bare git repository next to your storage, holding it as it is on the disk: git only ever sees your storage encrypted.
+'mpm sync' sends your changes to the remote, and takes those made on your other machines. If both sides changed, they are merged password by password: a password changed on one side only takes that change, and if it was changed on both sides, the most recent change wins while the other password stays in its history. Merging needs your passphrase, which must be the same on all your machines: change it right after synchronizing all of them.
+Your passphrase is asked before taking the storage of the remote, whose integrity is checked with it: if the passphrase was changed on another machine, the new one is asked too (in non-interactive mode, give it with --other-passphrase-fd or $` + OtherPassphraseEnv + `).
+--reset replaces your storage with the one of the remote, dropping the changes which were not synchronized. Your current storage stays as your most recent backup.`,
+	Args: cobra.NoArgs,
+	Run:  chainNodes(syncRepoExists, lockStorage, syncFunc),
+}
+
+// Sets up the synchronization
+var syncInitCmd = &cobra.Command{
+	Use:   "init [--remote <url>] [--branch <branch>]",
+	Short: "Set up the synchronization of your storage",
+	Long:  "Creates the git repository synchronizing your storage, next to it, and records your storage in it if it exists. On a new machine, set the remote and run 'mpm sync' to get your storage.",
+	Args:  cobra.NoArgs,
+	Run:   chainNodes(lockStorage, syncInitFunc),
+}
+
+// Sets the remote of the synchronization
+var syncRemoteCmd = &cobra.Command{
+	Use:   "remote <url>",
+	Short: "Set the git remote your storage is synchronized with",
+	Args:  cobra.ExactArgs(1),
+	Run:   chainNodes(syncRepoExists, syncRemoteFunc),
+}
+
+// Node asserting the synchronization is set up. On success, it stores its repository in the context under 'sync'.
+func syncRepoExists(context map[string]interface{}) (string, int) {
+	repo, err := core.OpenSync()
+	if err != nil {
+		return fmt.Sprintf("Impossible to open the synchronization of your storage.\n%s", err), 1
+	} else if repo == nil {
+		return "The synchronization of your storage is not set up, run 'mpm sync init --remote <url>' first", exitNotFound
+	}
+
+	context["sync"] = repo
+	return "", 0
+}
+
+// Creates the repository, and commits the storage if it exists
+func syncInitFunc(context map[string]interface{}) (string, int) {
+	repo, err := core.InitSync(syncRemote, syncBranch)
+	if err != nil {
+		return fmt.Sprintf("Impossible to set up the synchronization.\n%s", err), 1
+	}
+
+	fmt.Printf("The synchronization of your storage is set up in %s\n", repo.Dir())
+	if _, err = os.Stat(core.VaultPath()); err == nil {
+		if _, err = repo.Commit(syncMessage("Update")); err != nil {
+			return fmt.Sprintf("Impossible to record your storage.\n%s", err), 1
+		}
+	}
+
+	if repo.Remote() == "" {
+		return "Set the remote with 'mpm sync remote <url>', then run 'mpm sync'.", 0
+	}
+	return "Run 'mpm sync' to synchronize it.", 0
+}
+
+// Sets the remote. It requires the repository from the context.
+func syncRemoteFunc(context map[string]interface{}) (string, int) {
+	repo := (context["sync"]).(*core.SyncRepo)
+	url := (context["args"]).([]string)[0]
+
+	if err := repo.SetRemote(url); err != nil {
+		return fmt.Sprintf("Impossible to set the remote.\n%s", err), 1
+	}
+	return fmt.Sprintf("Your storage is now synchronized with %s", url), 0
+}
+
+// Synchronizes the storage with the remote. It requires the repository from the context. Changes written outside of mpm are committed first, then the remote is fetched:
+//   - if it has nothing new, the local changes are pushed
+//   - if only it has changes, or if --reset is given, the storage is replaced by its version, once verified with the passphrase (see syncVerifyRemote). --reset with nothing on the remote is an error.
+//   - if both have changes, they are merged (see core.Storage.Merge), then the merge is committed and pushed
+func syncFunc(context map[string]interface{}) (string, int) {
+	repo := (context["sync"]).(*core.SyncRepo)
+
+	if _, err := os.Stat(core.VaultPath()); err == nil {
+		if _, err = repo.Commit(syncMessage("Update")); err != nil {
+			return fmt.Sprintf("Impossible to record your storage.\n%s", err), 1
+		}
+	}
+
+	if err := repo.Fetch(); err != nil {
+		return fmt.Sprintf("Impossible to get the changes of the remote.\n%s", err), 1
+	}
+	local, remote := repo.Head(), repo.RemoteHead()
+
+	switch {
+	case local == remote:
+		if local == "" {
+			return "There is nothing to synchronize yet, neither here nor on the remote.", 0
+		}
+		return "Your storage is up to date.", 0
+	case syncReset && remote == "":
+		return fmt.Sprintf("The remote has no storage on the branch %s, there is nothing to replace yours with.", repo.Branch()), exitNotFound
+	case remote == "" || (!syncReset && repo.IsAncestor(remote, local)):
+		return syncPush(repo)
+	case syncReset || local == "" || repo.IsAncestor(local, remote):
+		if local != "" && !repo.IsAncestor(local, remote) {
+			if msg, code := confirmed("Your changes which were not synchronized will be lost, are you sure you want to replace your storage with the one of the remote ?"); msg != "" || code != 0 {
+				return msg, code
+			}
+		}
+
+		storage, err := repo.ReadStorage(remote)
+		if tamperErr, ok := err.(*core.TamperError); ok {
+			return tamperMessage(tamperErr), exitTampered
+		} else if err != nil {
+			return fmt.Sprintf("Impossible to read the storage of the remote.\n%s", err), 1
+		}
+		if msg, code := syncVerifyRemote(context, storage); msg != "" || code != 0 {
+			return msg, code
+		}
+		if err = storage.DumpOnDisk(); err != nil {
+			return fmt.Sprintf("Something went wrong, your storage hasn't been updated. Try again later !\n%s", err), 1
+		}
+		if err = repo.SetHead(remote, local); err != nil {
+			return fmt.Sprintf("Your storage has been updated, but not its synchronization.\n%s", err), 1
+		}
+		return fmt.Sprintf("Your storage has been updated with the changes of %s", repo.Remote()), 0
+	}
+
+	// Both sides changed, the merge needs the secret key
+	for _, node := range []nodeFunc{storageExists, verifyPassphrase} {
+		if msg, code := node(context); msg != "" || code != 0 {
+			return msg, code
+		}
+	}
+	storage := (context["storage"]).(*core.Storage)
+	key := (context["key"]).([]byte)
+
+	theirs, err := repo.ReadStorage(remote)
+	if tamperErr, ok := err.(*core.TamperError); ok {
+		return tamperMessage(tamperErr), exitTampered
+	} else if err != nil {
+		return fmt.Sprintf("Impossible to read the storage of the remote.\n%s", err), 1
+	}
+	var base *core.Storage
+	if ancestor := repo.MergeBase(local, remote); ancestor != "" {
+		base, _ = repo.ReadStorage(ancestor)
+	}
+
+	report, err := storage.Merge(key, base, theirs, key, core.MergeNewest.Resolve)
+	if keyErr, ok := err.(*core.MergeKeyError); ok {
+		return fmt.Sprintf(`%s.
+The passphrase has been changed on one of your machines while another one had changes which were not synchronized, both can't be merged.
+To keep the storage of the remote, replace yours with 'mpm sync --reset': it stays as your most recent backup, merge it back with 'mpm merge %s.bak.1'.`, keyErr, core.VaultPath()), 1
+	} else if err != nil {
+		return fmt.Sprintf("Impossible to merge the changes of the remote.\n%s", err), 1
+	}
+	printMergeReport(report, "the remote")
+
+	context["syncParent"] = remote
+	if msg, code := updateStore(context); code != 0 {
+		return msg, code
+	}
+	return syncPush(repo)
+}
+
+// Checks the storage of the remote before it replaces the one on the disk, if any: it must be sealed unless the current one isn't, and its MAC must match its secret key, see unlockOther. On a new machine, there is nothing to check it against.
+func syncVerifyRemote(context map[string]interface{}, theirs *core.Storage) (string, int) {
+	if _, err := os.Stat(core.VaultPath()); os.IsNotExist(err) {
+		return "", 0
+	}
+
+	for _, node := range []nodeFunc{storageExists, verifyPassphrase} {
+		if msg, code := node(context); msg != "" || code != 0 {
+			return msg, code
+		}
+	}
+	storage := (context["storage"]).(*core.Storage)
+
+	if storage.Sealed() && !theirs.Sealed() {
+		return tamperMessage(&core.TamperError{Reason: "the storage of the remote has no MAC, while yours has one"}), exitTampered
+	}
+	_, msg, code := unlockOther(context, theirs, "the storage of the remote")
+	return msg, code
+}
+
+// Pushes the local commits to the remote
+func syncPush(repo *core.SyncRepo) (string, int) {
+	if err := repo.Push(); err == core.ErrSyncRejected {
+		return fmt.Sprintf("%s, they were pushed while you were synchronizing. Run 'mpm sync' again.", err), exitBusy
+	} else if err != nil {
+		return fmt.Sprintf("Impossible to send your changes to the remote.\n%s", err), 1
+	}
+	return fmt.Sprintf("Your changes have been sent to %s", repo.Remote()), 0
+}
+
+// Records the storage just written in the synchronization repository, if it is set up. The commit merged into it is taken from the context under 'syncParent', if any. Failing to commit only warns: the storage has been written, and the next commit will record it.
+func commitSync(context map[string]interface{}) {
+	repo, err := core.OpenSync()
+	if err == nil && repo != nil {
+		message, merged := syncMessage("Update"), []string{}
+		if parent, ok := (context["syncParent"]).(string); ok {
+			message, merged = syncMessage("Merge"), append(merged, parent)
+		}
+		_, err = repo.Commit(message, merged...)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Your storage has been written, but not recorded for its synchronization.\n%s\n", err)
+	}
+}
+
+// Message of the commits, which only tells the machine they come from: the names stay out of git
+func syncMessage(action string) string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return action
+	}
+	return fmt.Sprintf("%s from %s", action, host)
+}
+
+func init() {
+	syncInitCmd.Flags().StringVar(&syncRemote, "remote", "", "The URL of the git remote to synchronize with")
+	syncInitCmd.Flags().StringVar(&syncBranch, "branch", "main", "The branch holding your storage on the remote")
+
+	syncCmd.Flags().BoolVar(&syncReset, "reset", false, "Replace your storage with the one of the remote")
+	syncCmd.Flags().BoolVar(&force, "force", false, "Don't ask to confirm --reset")
+	syncCmd.Flags().IntVar(&otherPassphraseFd, "other-passphrase-fd", -1, "Read the passphrase of the storage of the remote, if it changed, from the first line of this file descriptor")
+
+	syncCmd.AddCommand(syncInitCmd)
+	syncCmd.AddCommand(syncRemoteCmd)
+
+	RootCmd.AddCommand(syncCmd)
+}
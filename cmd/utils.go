@@ -10,7 +10,7 @@ import (
 
 // Template for choosing alphabet
 const alphaTmpl = `Choose your alphabet:
-{{ range $idx, $elt := .Possibilities }}    [{{$idx}}]  {{$elt.Display}}
+{{ range $idx, $elt := .Possibilities }}    [{{$idx}}]  {{printf "%-14s" $elt.Name}}{{$elt.Display}}
 {{ end }}`
 
 // Prompts the user for a string
@@ -26,16 +26,16 @@ func interactI(question string, answer *int) {
 }
 
 // Display alphabets and let the user choose. It checks that the alphabet exists.
-func chooseAlphabet(choice *int) error {
+func chooseAlphabet(alphabets []core.Alphabet, choice *int) error {
 	tmpl := template.Must(template.New("alphabets").Parse(alphaTmpl))
-	err := tmpl.Execute(os.Stdout, struct{ Possibilities []core.Alphabet }{core.Alphas})
+	err := tmpl.Execute(os.Stdout, struct{ Possibilities []core.Alphabet }{alphabets})
 	if err != nil {
 		panic(err)
 	}
 
 	interactI("\nWhat's your choice ?   ", choice)
 
-	if *choice < 0 || *choice >= len(core.Alphas) {
+	if *choice < 0 || *choice >= len(alphabets) {
 		return fmt.Errorf("Invalid choice: %d", *choice)
 	}
 
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/ElyKar/mpm/core"
+	"github.com/spf13/cobra"
+)
+
+// Root vault command. Managing the registry must work even when no vault can be selected, so it does not select one.
+var vaultCmd = &cobra.Command{
+	Use:              "vault [list|add|use]",
+	Short:            "Manage your named vaults",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+}
+
+// Lists the registered vaults
+var vaultListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the registered vaults",
+	Run:   chainNodes(loadConfig, vaultListFunc),
+}
+
+// Node reading the configuration. On success, it stores it in the context.
+func loadConfig(context map[string]interface{}) (string, int) {
+	config, err := core.LoadConfig()
+	if err != nil {
+		return fmt.Sprintf("Impossible to read your configuration.\n%s", err), 1
+	}
+
+	context["config"] = config
+	return "", 0
+}
+
+// Node listing the vaults, the one in use is starred. It requires the config from the context.
+func vaultListFunc(context map[string]interface{}) (string, int) {
+	config := (context["config"]).(*core.Config)
+
+	if len(config.Vaults) == 0 {
+		fmt.Println("There is no registered vault, you can add one with 'mpm vault add <name> <path>'")
+	} else {
+		fmt.Println("Here are the vaults registered:")
+		for _, name := range config.VaultNames() {
+			marker := " "
+			if name == config.Current {
+				marker = "*"
+			}
+			fmt.Printf("  %s %s: %s\n", marker, name, config.Vaults[name])
+		}
+	}
+
+	if path, err := core.ResolveVault(vault); err != nil {
+		fmt.Printf("\nNo vault can be used: %s\n", err)
+	} else {
+		fmt.Printf("\nVault in use: %s\n", path)
+	}
+	return "", 0
+}
+
+// Registers a vault
+var vaultAddCmd = &cobra.Command{
+	Use:   "add <name> <path>",
+	Short: "Register a vault under a name",
+	Long: `Registers the vault stored at path under a name, which can then be given to --vault or $MPM_VAULT.
+The vault does not have to exist yet, create it with 'mpm --vault <name> init'.`,
+	Args: cobra.ExactArgs(2),
+	Run:  chainNodes(loadConfig, vaultAddFunc, saveConfig),
+}
+
+// Node registering the vault given as arguments. It requires the config from the context.
+func vaultAddFunc(context map[string]interface{}) (string, int) {
+	config := (context["config"]).(*core.Config)
+	args := (context["args"]).([]string)
+
+	if err := config.AddVault(args[0], args[1]); err != nil {
+		return err.Error(), 1
+	}
+
+	return "", 0
+}
+
+// Selects the vault in use
+var vaultUseCmd = &cobra.Command{
+	Use:   "use [name]",
+	Short: "Use a registered vault by default",
+	Long:  `Makes a registered vault the one used when neither --vault nor $MPM_VAULT are given. Without a name, goes back to $HOME/.mpm.`,
+	Args:  cobra.MaximumNArgs(1),
+	Run:   chainNodes(loadConfig, vaultUseFunc, saveConfig),
+}
+
+// Node selecting the vault given as argument. It requires the config from the context.
+func vaultUseFunc(context map[string]interface{}) (string, int) {
+	config := (context["config"]).(*core.Config)
+	args := (context["args"]).([]string)
+
+	name := ""
+	if len(args) == 1 {
+		name = args[0]
+	}
+
+	if err := config.UseVault(name); err != nil {
+		return err.Error(), 1
+	}
+
+	return "", 0
+}
+
+// Node writing the configuration. It requires the config from the context.
+func saveConfig(context map[string]interface{}) (string, int) {
+	config := (context["config"]).(*core.Config)
+
+	if err := config.Save(); err != nil {
+		return fmt.Sprintf("Something went wrong, your changes haven't been saved.\n%s", err), 1
+	}
+
+	return "Everything went well !", 0
+}
+
+func init() {
+	vaultCmd.AddCommand(vaultListCmd)
+	vaultCmd.AddCommand(vaultAddCmd)
+	vaultCmd.AddCommand(vaultUseCmd)
+
+	RootCmd.AddCommand(vaultCmd)
+}
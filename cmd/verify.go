@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/ElyKar/mpm/core"
+	"github.com/spf13/cobra"
+)
+
+// Checks the integrity of the storage. The actual check is done by verifyPassphrase, no password is decrypted.
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check that your storage has not been tampered with",
+	Run:   chainNodes(storageExists, verifyPassphrase, verifyFunc),
+}
+
+// Node reporting the result of the integrity check. It requires the storage from the context.
+func verifyFunc(context map[string]interface{}) (string, int) {
+	storage := (context["storage"]).(*core.Storage)
+
+	if !storage.Sealed() {
+		return "Your storage has no integrity protection yet, it will be added the next time it is written, along with the argon2id key derivation.", 0
+	}
+
+	entries, legacy := 0, 0
+	for _, sec := range storage.Sections {
+		for _, entry := range sec {
+			entries++
+			if core.IsLegacy(entry.Password) {
+				legacy++
+			}
+		}
+	}
+
+	fmt.Printf("Key derivation: %s\n", storage.KDF)
+	fmt.Printf("Sections: %d, passwords: %d (%d using the legacy encryption)\n", len(storage.Sections), entries, legacy)
+	return "Your storage is intact.", 0
+}
+
+func init() {
+	RootCmd.AddCommand(verifyCmd)
+}
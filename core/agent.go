@@ -0,0 +1,363 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The agent keeps the secret keys of the vaults in memory for a while, so the passphrase is not asked by each command. It listens on a Unix socket in a directory only readable by its owner, and only answers the processes of the same user.
+
+// AgentEnv is the environment variable overriding the path of the socket of the agent
+const AgentEnv = "MPM_AGENT_SOCK"
+
+// How long a client waits for the agent
+const agentDialTimeout = time.Second
+
+// Operations understood by the agent
+const (
+	agentGet  = "get"
+	agentPut  = "put"
+	agentLock = "lock"
+)
+
+// ErrAgentUnsupported is raised when running an agent on a platform without peer credentials on Unix sockets.
+var ErrAgentUnsupported = errors.New("The agent is not supported on this platform")
+
+// AgentRunningError is raised when starting an agent while another one already listens on the socket.
+type AgentRunningError struct {
+	Path string
+}
+
+func (e *AgentRunningError) Error() string {
+	return fmt.Sprintf("An agent is already running on %s", e.Path)
+}
+
+// Requests sent to the agent, one per connection
+type agentRequest struct {
+	Op    string
+	Vault string `json:",omitempty"`
+	Key   []byte `json:",omitempty"`
+}
+
+// Responses of the agent. Key is empty when the agent has no key for the vault.
+type agentResponse struct {
+	Key   []byte `json:",omitempty"`
+	Error string `json:",omitempty"`
+}
+
+// AgentSocketPath returns the path of the socket of the agent: $MPM_AGENT_SOCK, else in $XDG_RUNTIME_DIR, else in a directory of the user under the temporary directory.
+func AgentSocketPath() string {
+	if path := os.Getenv(AgentEnv); path != "" {
+		return path
+	}
+
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "mpm", "agent.sock")
+	}
+
+	return filepath.Join(os.TempDir(), fmt.Sprintf("mpm-%d", os.Getuid()), "agent.sock")
+}
+
+// Agent holds secret keys, by vault, until they expire
+type Agent struct {
+	timeout  time.Duration
+	mutex    sync.Mutex
+	keys     map[string]*cachedKey
+	listener net.Listener
+}
+
+// A secret key held by the agent, with the timer wiping it
+type cachedKey struct {
+	key   []byte
+	timer *time.Timer
+}
+
+// NewAgent creates an agent keeping each key for the given duration after it was given. Zero keeps them until the agent is locked.
+func NewAgent(timeout time.Duration) *Agent {
+	return &Agent{timeout: timeout, keys: make(map[string]*cachedKey)}
+}
+
+// Serve listens on the socket and answers the clients until Close is called. The directory of the socket is created if needed, only accessible to the user, and an existing one is refused unless it is. While it listens, the socket is recorded in the configuration as the one of the agent started by the user, see AgentStarted.
+func (a *Agent) Serve(path string) error {
+	if !AgentSupported {
+		return ErrAgentUnsupported
+	}
+
+	if err := makeAgentDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	// A socket left by an agent which died can be replaced, not a live one
+	if conn, err := net.DialTimeout("unix", path, agentDialTimeout); err == nil {
+		conn.Close()
+		return &AgentRunningError{path}
+	}
+	os.Remove(path)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return err
+	}
+
+	a.mutex.Lock()
+	a.listener = listener
+	a.mutex.Unlock()
+
+	if err = recordAgent(path, true); err != nil {
+		listener.Close()
+		return err
+	}
+	defer recordAgent(path, false)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		go a.handle(conn.(*net.UnixConn))
+	}
+}
+
+// Close stops the agent and wipes all of its keys
+func (a *Agent) Close() error {
+	a.Lock()
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.listener == nil {
+		return nil
+	}
+	return a.listener.Close()
+}
+
+// Lock wipes all the keys held by the agent
+func (a *Agent) Lock() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for vault, cached := range a.keys {
+		a.forget(vault, cached)
+	}
+}
+
+// Answers one request. The clients of other users are rejected.
+func (a *Agent) handle(conn *net.UnixConn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(agentDialTimeout))
+
+	uid, err := peerUID(conn)
+	if err != nil || uid != os.Getuid() {
+		return
+	}
+
+	var request agentRequest
+	if err = json.NewDecoder(conn).Decode(&request); err != nil {
+		return
+	}
+
+	var response agentResponse
+	switch request.Op {
+	case agentGet:
+		response.Key = a.get(request.Vault)
+	case agentPut:
+		a.put(request.Vault, request.Key)
+	case agentLock:
+		a.Lock()
+	default:
+		response.Error = fmt.Sprintf("Unknown operation %s", request.Op)
+	}
+
+	json.NewEncoder(conn).Encode(&response)
+}
+
+// Returns a copy of the key of a vault, nil if there is none
+func (a *Agent) get(vault string) []byte {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	cached, ok := a.keys[vault]
+	if !ok {
+		return nil
+	}
+	return append([]byte(nil), cached.key...)
+}
+
+// Keeps the key of a vault, replacing the previous one
+func (a *Agent) put(vault string, key []byte) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if previous, ok := a.keys[vault]; ok {
+		a.forget(vault, previous)
+	}
+
+	cached := &cachedKey{key: key}
+	if a.timeout > 0 {
+		cached.timer = time.AfterFunc(a.timeout, func() {
+			a.mutex.Lock()
+			defer a.mutex.Unlock()
+			if a.keys[vault] == cached {
+				a.forget(vault, cached)
+			}
+		})
+	}
+	a.keys[vault] = cached
+}
+
+// Wipes a key. The mutex must be held.
+func (a *Agent) forget(vault string, cached *cachedKey) {
+	if cached.timer != nil {
+		cached.timer.Stop()
+	}
+	for i := range cached.key {
+		cached.key[i] = 0
+	}
+	delete(a.keys, vault)
+}
+
+// Creates the directory of the socket if needed, then asserts it is only accessible to the user: a directory created by another user could let them listen on the socket instead of the agent
+func makeAgentDir(dir string) error {
+	if _, err := os.Lstat(dir); os.IsNotExist(err) {
+		if err = os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		if err = os.Chmod(dir, 0700); err != nil {
+			return err
+		}
+	}
+
+	return checkAgentDir(dir)
+}
+
+// Asserts the directory of the socket is a directory, not a link, owned by the user and only accessible to them
+func checkAgentDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory, the agent can't use it", dir)
+	}
+	if uid, err := fileOwner(info); err != nil || uid != os.Getuid() {
+		return fmt.Errorf("%s does not belong to you, the agent can't use it", dir)
+	}
+	if info.Mode().Perm() != 0700 {
+		return fmt.Errorf("%s is accessible to other users (mode %o instead of 700), the agent can't use it", dir, info.Mode().Perm())
+	}
+	return nil
+}
+
+// Sends a request to the agent, and reads its response. Nothing is sent unless the socket is in a directory of the user, and the agent runs as the user.
+func agentCall(request *agentRequest) (*agentResponse, error) {
+	if !AgentSupported {
+		return nil, ErrAgentUnsupported
+	}
+
+	path := AgentSocketPath()
+	if err := checkAgentDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("unix", path, agentDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(agentDialTimeout))
+
+	if uid, err := peerUID(conn.(*net.UnixConn)); err != nil {
+		return nil, err
+	} else if uid != os.Getuid() {
+		return nil, fmt.Errorf("The agent on %s belongs to another user", path)
+	}
+
+	if err = json.NewEncoder(conn).Encode(request); err != nil {
+		return nil, err
+	}
+
+	response := &agentResponse{}
+	if err = json.NewDecoder(conn).Decode(response); err != nil {
+		return nil, err
+	}
+	if response.Error != "" {
+		return nil, errors.New(response.Error)
+	}
+	return response, nil
+}
+
+// Identifies a vault for the agent, by its absolute path
+func agentVault(vault string) string {
+	if abs, err := filepath.Abs(vault); err == nil {
+		return abs
+	}
+	return vault
+}
+
+// AgentStarted tells whether an agent started by the user with 'mpm agent' listens on the socket, as recorded in the configuration. The keys are only given to this agent.
+func AgentStarted() bool {
+	config, err := LoadConfig()
+	return err == nil && config.Agent != "" && config.Agent == AgentSocketPath()
+}
+
+// Records the socket of the agent in the configuration while it runs, and forgets it when it stops
+func recordAgent(path string, running bool) error {
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	if running {
+		config.Agent = path
+	} else if config.Agent == path {
+		config.Agent = ""
+	} else {
+		return nil
+	}
+	return config.Save()
+}
+
+// AgentKey asks the agent for the secret key of a vault. It returns nil if the agent does not hold it, and an error if no agent is running.
+func AgentKey(vault string) ([]byte, error) {
+	response, err := agentCall(&agentRequest{Op: agentGet, Vault: agentVault(vault)})
+	if err != nil {
+		return nil, err
+	}
+	return response.Key, nil
+}
+
+// AgentAddKey gives the secret key of a vault to the agent. It returns an error if no agent is running, and should only be called if AgentStarted.
+func AgentAddKey(vault string, key []byte) error {
+	_, err := agentCall(&agentRequest{Op: agentPut, Vault: agentVault(vault), Key: key})
+	return err
+}
+
+// AgentLock makes the agent wipe all of its keys. It returns an error if no agent is running.
+func AgentLock() error {
+	_, err := agentCall(&agentRequest{Op: agentLock})
+	return err
+}
+
+// AgentRunning tells whether an agent listens on the socket.
+func AgentRunning() bool {
+	conn, err := net.DialTimeout("unix", AgentSocketPath(), agentDialTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
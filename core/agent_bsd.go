@@ -0,0 +1,46 @@
+//go:build darwin || freebsd
+
+package core
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// AgentSupported tells whether the agent can run here: the kernel gives the credentials of the peer of a Unix socket
+const AgentSupported = true
+
+// Returns the user id of the process at the other end of the socket
+func peerUID(conn *net.UnixConn) (int, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+
+	var cred *unix.Xucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	})
+	if err != nil {
+		return -1, err
+	}
+	if credErr != nil {
+		return -1, credErr
+	}
+
+	return int(cred.Uid), nil
+}
+
+// Returns the user id owning a file
+func fileOwner(info os.FileInfo) (int, error) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, errors.New("The owner of the file is unknown")
+	}
+	return int(stat.Uid), nil
+}
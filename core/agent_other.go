@@ -0,0 +1,19 @@
+//go:build !darwin && !freebsd && !linux
+
+package core
+
+import (
+	"net"
+	"os"
+)
+
+// AgentSupported tells whether the agent can run here: without the credentials of the peer, it could give the keys to other users
+const AgentSupported = false
+
+func peerUID(conn *net.UnixConn) (int, error) {
+	return -1, ErrAgentUnsupported
+}
+
+func fileOwner(info os.FileInfo) (int, error) {
+	return -1, ErrAgentUnsupported
+}
@@ -2,11 +2,15 @@ package core
 
 import (
 	"crypto/rand"
+	"fmt"
 	"math/big"
+	"strings"
 )
 
 // An Alphabet abstract the character set from which the password will be randomly created.
 type Alphabet struct {
+	// Name of the alphabet, for the presets
+	Name string
 	// String to display on the prompt
 	Display string
 	// Entire set of characters
@@ -17,31 +21,79 @@ type Alphabet struct {
 var Alphas []Alphabet = []Alphabet{
 	// Well, some websites require secret digicodes. Don't look at me like that.
 	Alphabet{
+		"digits",
 		"[0-9]",
 		"0123456789",
 	},
 	// Useless, but provides a nicer display.
 	Alphabet{
+		"letters",
 		"[a-zA-Z]",
 		"abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ",
 	},
 	// Should not be used. Though, some very legacy apps may still have trouble with special chars (shame on them)
 	Alphabet{
+		"alphanumeric",
 		"[a-zA-Z0-9]",
 		"abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789",
 	},
 	// Finally some special chars, restreint version
 	Alphabet{
+		"restricted",
 		"[a-zA-Z0-9!&()*+,-./?[]~]",
 		"abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!&()*+,-./?[]~",
 	},
 	// Full (or almost full) version, though may be a bit too much
 	Alphabet{
+		"full",
 		"[a-zA-Z0-9 !\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~]",
-		"abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789 !\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~]",
+		"abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789 !\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~",
 	},
 }
 
+// NewAlphabet creates an alphabet from its characters, see ValidateCharacters.
+func NewAlphabet(name string, chars string) (Alphabet, error) {
+	if err := ValidateCharacters(chars); err != nil {
+		return Alphabet{}, fmt.Errorf("Invalid alphabet %s: %s", name, err)
+	}
+
+	return Alphabet{name, "[" + chars + "]", chars}, nil
+}
+
+// ValidateCharacters checks the characters of an alphabet: they must be printable ASCII characters, and distinct. A duplicated character would be drawn twice as often as the others.
+func ValidateCharacters(chars string) error {
+	if len(chars) < 2 {
+		return fmt.Errorf("it needs at least 2 characters")
+	}
+
+	for i := 0; i < len(chars); i++ {
+		if chars[i] < ' ' || chars[i] > '~' {
+			return fmt.Errorf("only printable ASCII characters are supported, found %q", chars[i])
+		}
+		if strings.IndexByte(chars[:i], chars[i]) >= 0 {
+			return fmt.Errorf("character %q appears twice", chars[i])
+		}
+	}
+
+	return nil
+}
+
+// Characters returns the characters of the alphabet
+func (a Alphabet) Characters() string {
+	return a.choices
+}
+
+// FindAlphabet returns the alphabet of the given name
+func FindAlphabet(alphabets []Alphabet, name string) (Alphabet, error) {
+	for _, alphabet := range alphabets {
+		if alphabet.Name == name {
+			return alphabet, nil
+		}
+	}
+
+	return Alphabet{}, fmt.Errorf("Alphabet %s does not exists", name)
+}
+
 // GenPassword creates a random password of given length, using Golang's cryptographically secure PRNG (which is just a wrapper around your OS's cryptographically secure PRNG, so if you find a problem of randomness in it, that's something you can be proud of).
 func (a Alphabet) GenPassword(n int) string {
 	max := big.NewInt(int64(len(a.choices)))
@@ -0,0 +1,192 @@
+package core
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// An archive is a portable copy of the entries of a storage, protected by its own passphrase. Unlike the master file, it doesn't depend on the key derivation of the storage, and can be restored into any other one, see ReadArchive.
+// It is a JSON document: a header in clear, then the entries encrypted with AES-256-GCM under the key derived from the passphrase. The header is authenticated along with them.
+
+// Format of the archives, and the version written by this version of mpm
+const (
+	archiveFormat  = "mpm-archive"
+	ArchiveVersion = 1
+)
+
+// Prefix of the associated data of the archives, so that their ciphertext can't be mistaken for another one
+const archiveLabel = "mpm archive\n"
+
+// ArchiveHeader describes an archive, it is stored in clear
+type ArchiveHeader struct {
+	Format  string `json:"Format"`
+	Version int    `json:"Version"`
+	// Key derivation of the archive, independent from the one of the storage
+	KDF *KDF `json:"KDF"`
+	// When the archive was written, and how many entries it holds
+	Created time.Time `json:"Created"`
+	Entries int       `json:"Entries"`
+}
+
+// The archive file. The header is kept as written, since it is authenticated, see archiveData.
+type archiveFile struct {
+	Header json.RawMessage `json:"Header"`
+	// Nonce then ciphertext of the entries, encoded in base64
+	Data string `json:"Data"`
+}
+
+// ArchiveAuthError is raised when an archive can't be decrypted: either the passphrase is wrong, or the archive has been tampered with
+type ArchiveAuthError struct{}
+
+func (e *ArchiveAuthError) Error() string {
+	return "Wrong passphrase, or the archive has been tampered with"
+}
+
+// WriteArchive encrypts entries into an archive, whose key is derived from the passphrase with kdf. The KDF must have a fresh salt.
+func WriteArchive(entries []ImportedEntry, passphrase string, kdf *KDF) ([]byte, error) {
+	if kdf == nil {
+		return nil, fmt.Errorf("An archive needs a key derivation function")
+	}
+
+	header, err := json.Marshal(&ArchiveHeader{archiveFormat, ArchiveVersion, kdf, time.Now().UTC(), len(entries)})
+	if err != nil {
+		return nil, err
+	}
+	content, err := json.Marshal(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := archiveCipher(kdf, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(content)+aead.Overhead())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	ad, err := archiveData(header)
+	if err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nonce, nonce, content, ad)
+
+	return json.MarshalIndent(&archiveFile{header, base64.StdEncoding.EncodeToString(sealed)}, "", "  ")
+}
+
+// ReadArchiveHeader reads the header of an archive, without decrypting it. Archives written by a newer version of mpm are refused, as are the key derivations out of bounds (see KDF.Check).
+func ReadArchiveHeader(data []byte) (*ArchiveHeader, error) {
+	var file archiveFile
+	if err := json.Unmarshal(data, &file); err != nil || file.Header == nil {
+		return nil, fmt.Errorf("This is not an archive of mpm")
+	}
+
+	header := &ArchiveHeader{}
+	if err := json.Unmarshal(file.Header, header); err != nil || header.Format != archiveFormat {
+		return nil, fmt.Errorf("This is not an archive of mpm")
+	}
+	if header.Version > ArchiveVersion {
+		return nil, fmt.Errorf("This archive was written by a newer version of mpm (version %d), upgrade mpm to read it", header.Version)
+	}
+	if header.KDF == nil {
+		return nil, fmt.Errorf("The archive has no key derivation function")
+	}
+	// The parameters come from the file, they are bounded before any key is derived with them
+	if err := header.KDF.Check(); err != nil {
+		return nil, err
+	}
+
+	return header, nil
+}
+
+// ReadArchive decrypts the entries of an archive with its passphrase. An *ArchiveAuthError is raised if the passphrase is wrong or if the archive was modified.
+func ReadArchive(data []byte, passphrase string) ([]ImportedEntry, error) {
+	header, err := ReadArchiveHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var file archiveFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(file.Data)
+	if err != nil {
+		return nil, &ArchiveAuthError{}
+	}
+
+	aead, err := archiveCipher(header.KDF, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, &ArchiveAuthError{}
+	}
+
+	ad, err := archiveData(file.Header)
+	if err != nil {
+		return nil, &ArchiveAuthError{}
+	}
+	content, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], ad)
+	if err != nil {
+		return nil, &ArchiveAuthError{}
+	}
+
+	var entries []ImportedEntry
+	if err = json.Unmarshal(content, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ReadArchiveFile reads and decrypts an archive file, see ReadArchive. The header is returned as well.
+func ReadArchiveFile(path string, passphrase string) (*ArchiveHeader, []ImportedEntry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header, err := ReadArchiveHeader(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries, err := ReadArchive(data, passphrase)
+	return header, entries, err
+}
+
+// Builds the associated data of an archive from its header, compacted so that the indentation of the file doesn't matter
+func archiveData(header []byte) ([]byte, error) {
+	ad := bytes.NewBufferString(archiveLabel)
+	if err := json.Compact(ad, header); err != nil {
+		return nil, err
+	}
+	return ad.Bytes(), nil
+}
+
+// Creates the cipher of an archive, from the key derived from its passphrase
+func archiveCipher(kdf *KDF, passphrase string) (cipher.AEAD, error) {
+	key, err := kdf.DeriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// WriteExport writes an export or an archive to a file only readable by the user. The file is replaced atomically, so an existing one is never left half written.
+func WriteExport(path string, data []byte) error {
+	return writeFileAtomic(path, data)
+}
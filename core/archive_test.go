@@ -0,0 +1,80 @@
+package core
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	entries := []ImportedEntry{{Section: "mail", Name: "perso", Password: "secret", Details: EntryDetails{Username: "me"}}}
+
+	data, err := WriteArchive(entries, "passphrase", testKDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadArchive(data, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 1 || read[0].Password != "secret" || read[0].Details.Username != "me" {
+		t.Fatalf("ReadArchive returned %+v", read)
+	}
+
+	if _, err = ReadArchive(data, "wrong"); err == nil {
+		t.Fatal("ReadArchive accepted a wrong passphrase")
+	} else if _, ok := err.(*ArchiveAuthError); !ok {
+		t.Fatalf("ReadArchive returned %v for a wrong passphrase", err)
+	}
+}
+
+// The parameters of the header are bounded before deriving anything: unbounded, they would exhaust the memory of the process
+func TestArchiveHeaderOutOfBounds(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(kdf map[string]interface{})
+	}{
+		{"argon2id memory", func(kdf map[string]interface{}) { kdf["Memory"] = 4000000000 }},
+		{"argon2id time", func(kdf map[string]interface{}) { kdf["Time"] = maxArgonTime + 1 }},
+		{"argon2id threads", func(kdf map[string]interface{}) { kdf["Threads"] = 255 }},
+		{"scrypt N", func(kdf map[string]interface{}) {
+			kdf["Algo"], kdf["N"], kdf["R"], kdf["P"] = KDFScrypt, maxScryptN<<1, 8, 1
+		}},
+		{"scrypt r", func(kdf map[string]interface{}) {
+			kdf["Algo"], kdf["N"], kdf["R"], kdf["P"] = KDFScrypt, 1<<14, 1<<20, 1
+		}},
+		{"scrypt p", func(kdf map[string]interface{}) {
+			kdf["Algo"], kdf["N"], kdf["R"], kdf["P"] = KDFScrypt, 1<<14, 8, 1<<20
+		}},
+		{"scrypt memory", func(kdf map[string]interface{}) {
+			kdf["Algo"], kdf["N"], kdf["R"], kdf["P"] = KDFScrypt, maxScryptN, maxScryptR, 1
+		}},
+	}
+
+	data, err := WriteArchive(nil, "passphrase", testKDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var file map[string]interface{}
+			if err := json.Unmarshal(data, &file); err != nil {
+				t.Fatal(err)
+			}
+			test.edit(file["Header"].(map[string]interface{})["KDF"].(map[string]interface{}))
+			crafted, err := json.Marshal(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err = ReadArchiveHeader(crafted); err == nil || !strings.Contains(err.Error(), "exceed") {
+				t.Fatalf("ReadArchiveHeader returned %v", err)
+			}
+			if _, err = ReadArchive(crafted, "passphrase"); err == nil || !strings.Contains(err.Error(), "exceed") {
+				t.Fatalf("ReadArchive returned %v", err)
+			}
+		})
+	}
+}
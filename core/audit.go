@@ -0,0 +1,144 @@
+package core
+
+import (
+	"crypto/sha256"
+	"sort"
+	"time"
+)
+
+// EntryRef designates an entry by its section and name
+type EntryRef struct {
+	Section string
+	Name    string
+}
+
+// WeakEntry is an entry whose password is too easily guessed
+type WeakEntry struct {
+	EntryRef
+	Strength Strength
+}
+
+// StaleEntry is an entry whose password was not changed for too long
+type StaleEntry struct {
+	EntryRef
+	// When the password was last changed, zero if the entry is older than the dates
+	Modified time.Time
+}
+
+// Audit reports the health of a storage, see Storage.Audit. The entries of each list are sorted.
+type Audit struct {
+	// When the audit was done
+	Date time.Time
+	// Number of entries audited
+	Entries int
+	// Key derivation of the storage, nil for the legacy one
+	KDF *KDF
+	// Whether the storage is protected by a MAC
+	Sealed bool
+	// Groups of entries sharing the same password
+	Reused [][]EntryRef
+	// Entries whose password is weak, see Strength.Weak
+	Weak []WeakEntry
+	// Entries whose password is older than the maximum age
+	Stale []StaleEntry
+	// Entries with fields still using the legacy encryption
+	Legacy []EntryRef
+	// Entries which can't be decrypted, they have been tampered with
+	Tampered []EntryRef
+}
+
+// Issues returns the number of problems found by the audit
+func (a *Audit) Issues() int {
+	issues := len(a.Reused) + len(a.Weak) + len(a.Stale) + len(a.Legacy) + len(a.Tampered)
+	if a.KDF == nil {
+		issues++
+	}
+	if !a.Sealed {
+		issues++
+	}
+
+	return issues
+}
+
+// Audit decrypts all the passwords of the storage, and reports the reused, weak and stale ones along with the entries still using the legacy encryption. The generated passwords are estimated from their policy, the others with EstimateStrength. A password is stale if it was not changed for longer than maxAge, 0 to never consider them stale.
+func (s *Storage) Audit(key []byte, maxAge time.Duration) (*Audit, error) {
+	layout, err := s.decodeLayout(key)
+	if err != nil {
+		return nil, err
+	}
+
+	audit := &Audit{Date: time.Now().UTC(), KDF: s.KDF, Sealed: s.Sealed()}
+	passwords := make(map[[sha256.Size]byte][]EntryRef)
+
+	for section, entries := range layout {
+		for name, entry := range entries {
+			ref := EntryRef{section, name}
+			audit.Entries++
+
+			if entry.legacy() {
+				audit.Legacy = append(audit.Legacy, ref)
+			}
+			if maxAge > 0 && audit.Date.Sub(entry.Modified) > maxAge {
+				audit.Stale = append(audit.Stale, StaleEntry{ref, entry.Modified})
+			}
+
+			password, err := s.Transcoder(key, section, name).DecodePassword(entry.Password)
+			if err != nil {
+				if _, ok := err.(*AuthError); ok {
+					audit.Tampered = append(audit.Tampered, ref)
+					continue
+				}
+				return nil, err
+			}
+			details, err := s.DecodeDetails(key, section, name, entry)
+			if err != nil {
+				if _, ok := err.(*AuthError); ok {
+					audit.Tampered = append(audit.Tampered, ref)
+					continue
+				}
+				return nil, err
+			}
+
+			// Only a hash of the passwords is kept to find the reused ones
+			hash := sha256.Sum256(password)
+			passwords[hash] = append(passwords[hash], ref)
+
+			var strength Strength
+			if details.Policy != nil {
+				strength = details.Policy.Strength()
+			} else {
+				strength = EstimateStrength(string(password))
+			}
+			if strength.Weak() {
+				audit.Weak = append(audit.Weak, WeakEntry{ref, strength})
+			}
+		}
+	}
+
+	for _, refs := range passwords {
+		if len(refs) > 1 {
+			sortRefs(refs)
+			audit.Reused = append(audit.Reused, refs)
+		}
+	}
+	sort.Slice(audit.Reused, func(i, j int) bool { return refLess(audit.Reused[i][0], audit.Reused[j][0]) })
+	sort.Slice(audit.Weak, func(i, j int) bool { return refLess(audit.Weak[i].EntryRef, audit.Weak[j].EntryRef) })
+	sort.Slice(audit.Stale, func(i, j int) bool { return refLess(audit.Stale[i].EntryRef, audit.Stale[j].EntryRef) })
+	sortRefs(audit.Legacy)
+	sortRefs(audit.Tampered)
+
+	return audit, nil
+}
+
+// Orders the entries by section, then by name
+func refLess(a EntryRef, b EntryRef) bool {
+	if a.Section != b.Section {
+		return a.Section < b.Section
+	}
+	return a.Name < b.Name
+}
+
+// Sorts entries by section, then by name
+func sortRefs(refs []EntryRef) {
+	sort.Slice(refs, func(i, j int) bool { return refLess(refs[i], refs[j]) })
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Number of previous versions of the master file kept as backups
+const backupCount = 5
+
+// Backup describes a previous version of the master file. Backups are plain copies of it, passwords stay encrypted and the file is still sealed.
+type Backup struct {
+	// 1 for the most recent backup, up to backupCount for the oldest one
+	Generation int
+	Path       string
+	ModTime    time.Time
+}
+
+// backupName returns the path of the given generation, next to the master file.
+func backupName(generation int) string {
+	return fmt.Sprintf("%s.bak.%d", fileName, generation)
+}
+
+// ListBackups lists the existing backups, from the most recent to the oldest.
+func ListBackups() ([]Backup, error) {
+	backups := make([]Backup, 0)
+	for i := 1; i <= backupCount; i++ {
+		info, err := os.Stat(backupName(i))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		backups = append(backups, Backup{i, backupName(i), info.ModTime()})
+	}
+
+	return backups, nil
+}
+
+// GetBackup reads the backup of the given generation, the same way GetStorage reads the master file. Dumping it on the disk restores it, as long as the master file does not change in the meantime.
+func GetBackup(generation int) (*Storage, error) {
+	if generation < 1 || generation > backupCount {
+		return nil, fmt.Errorf("Backup %d does not exists, generations go from 1 to %d", generation, backupCount)
+	}
+
+	storage, err := readStorage(backupName(generation))
+	if err != nil {
+		return nil, err
+	}
+
+	// The backup replaces the current master file
+	if storage.version, err = fileVersion(fileName); err != nil {
+		return nil, err
+	}
+
+	return storage, nil
+}
+
+// rotateBackups shifts all the backups by one generation, the oldest one is dropped, and copies the master file as the most recent one. The copy keeps the modification time of the master file.
+func rotateBackups() error {
+	info, err := os.Stat(fileName)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	for i := backupCount - 1; i >= 1; i-- {
+		if err := os.Rename(backupName(i), backupName(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err = writeFileAtomic(backupName(1), data); err != nil {
+		return err
+	}
+
+	return os.Chtimes(backupName(1), info.ModTime(), info.ModTime())
+}
+
+// writeFileAtomic writes the data into a temporary file of the same directory, flushes it to the disk, then renames it to its final name.
+func writeFileAtomic(file string, data []byte) error {
+	dir := filepath.Dir(file)
+
+	tmp, err := ioutil.TempFile(dir, filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	// Only cleans up if something went wrong, the file has been renamed otherwise
+	defer os.Remove(tmp.Name())
+
+	if err = tmp.Chmod(0600); err == nil {
+		if _, err = tmp.Write(data); err == nil {
+			err = tmp.Sync()
+		}
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp.Name(), file); err != nil {
+		return err
+	}
+
+	// Persist the rename itself. Not every platform can sync a directory, so errors are ignored.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,340 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// The breached passwords come from the Pwned Passwords of Have I Been Pwned, downloaded as the SHA-1 hashes ordered by hash: one "HASH:COUNT" line per password, the hash in upper case hexadecimal. See https://haveibeenpwned.com/Passwords
+// The file is searched as is, or through a compact index built from it, see BuildBreachIndex.
+
+// Magic number starting the breach indexes
+const breachIndexMagic = "MPMHIBP1"
+
+// Bytes of the hash kept by the index. With 10 bytes, the odds that a password is mistaken for one of the billion breached ones are below one in 10^15.
+const breachPrefixSize = 10
+
+// Size of a record of the index: the hash prefix, then the count as a big endian uint32
+const breachRecordSize = breachPrefixSize + 4
+
+// Longest line expected in the text file
+const breachMaxLine = 128
+
+// BreachDB tells how many times a password appears in the breaches
+type BreachDB interface {
+	// Count returns how many times the password appears in the breaches, 0 if it doesn't
+	Count(password []byte) (int, error)
+	Close() error
+}
+
+// OpenBreachDB opens the breached passwords, either the text file of Have I Been Pwned or an index built from it.
+func OpenBreachDB(path string) (BreachDB, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	magic := make([]byte, len(breachIndexMagic))
+	if _, err = file.ReadAt(magic, 0); err == nil && string(magic) == breachIndexMagic {
+		if (stat.Size()-int64(len(magic)))%breachRecordSize != 0 {
+			file.Close()
+			return nil, fmt.Errorf("The breach index %s is truncated", path)
+		}
+		return &breachIndex{file, (stat.Size() - int64(len(magic))) / breachRecordSize}, nil
+	}
+
+	return &breachText{file, stat.Size()}, nil
+}
+
+// The text file of Have I Been Pwned, searched by bisection over the positions in the file
+type breachText struct {
+	file *os.File
+	size int64
+}
+
+// Close closes the file
+func (b *breachText) Close() error {
+	return b.file.Close()
+}
+
+// Returns the first line starting at or after a position, along with its start and the position after it. A start beyond the end of the file means there is none.
+func (b *breachText) lineAt(pos int64) (int64, []byte, int64, error) {
+	start := pos
+	if pos > 0 {
+		start = pos - 1
+	}
+
+	buffer := make([]byte, 2*breachMaxLine)
+	n, err := b.file.ReadAt(buffer, start)
+	if err != nil && err != io.EOF {
+		return 0, nil, 0, err
+	}
+	buffer = buffer[:n]
+
+	// Unless at the beginning of the file, the line starts after the first line break
+	if pos > 0 {
+		i := bytes.IndexByte(buffer, '\n')
+		if i < 0 {
+			return b.size, nil, b.size, nil
+		}
+		buffer = buffer[i+1:]
+		start += int64(i + 1)
+	}
+
+	end := bytes.IndexByte(buffer, '\n')
+	if end < 0 {
+		if start+int64(len(buffer)) < b.size {
+			return 0, nil, 0, fmt.Errorf("Line too long at offset %d, is it a file of Have I Been Pwned?", start)
+		}
+		return start, bytes.TrimRight(buffer, "\r"), b.size, nil
+	}
+
+	return start, bytes.TrimRight(buffer[:end], "\r"), start + int64(end) + 1, nil
+}
+
+// Count looks for the hash of the password by bisection: the line sought always starts between lo and hi.
+func (b *breachText) Count(password []byte) (int, error) {
+	hash := sha1.Sum(password)
+	target := bytes.ToUpper([]byte(hex.EncodeToString(hash[:])))
+
+	lo, hi := int64(0), b.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, line, next, err := b.lineAt(mid)
+		if err != nil {
+			return 0, err
+		}
+		if start >= hi {
+			hi = mid
+			continue
+		}
+
+		lineHash, count, err := parseBreachLine(line)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid line at offset %d: %s", start, err)
+		}
+
+		switch bytes.Compare(lineHash, target) {
+		case 0:
+			return count, nil
+		case -1:
+			lo = next
+		default:
+			hi = mid
+		}
+	}
+
+	return 0, nil
+}
+
+// Splits a line of the text file into its upper case hash and its count
+func parseBreachLine(line []byte) ([]byte, int, error) {
+	i := bytes.IndexByte(line, ':')
+	if i != 2*sha1.Size {
+		return nil, 0, fmt.Errorf("expected a SHA-1 hash and a count, found %q", line)
+	}
+
+	count, err := strconv.Atoi(string(bytes.TrimSpace(line[i+1:])))
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid count in %q", line)
+	}
+
+	return bytes.ToUpper(line[:i]), count, nil
+}
+
+// An index built by BuildBreachIndex: sorted records of fixed size, searched by bisection over their numbers
+type breachIndex struct {
+	file    *os.File
+	records int64
+}
+
+// Close closes the file
+func (b *breachIndex) Close() error {
+	return b.file.Close()
+}
+
+// Count looks for the prefix of the hash of the password by bisection
+func (b *breachIndex) Count(password []byte) (int, error) {
+	hash := sha1.Sum(password)
+	target := hash[:breachPrefixSize]
+
+	record := make([]byte, breachRecordSize)
+	lo, hi := int64(0), b.records
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if _, err := b.file.ReadAt(record, int64(len(breachIndexMagic))+mid*breachRecordSize); err != nil {
+			return 0, err
+		}
+
+		switch bytes.Compare(record[:breachPrefixSize], target) {
+		case 0:
+			return int(binary.BigEndian.Uint32(record[breachPrefixSize:])), nil
+		case -1:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+
+	return 0, nil
+}
+
+// BuildBreachIndex converts the text file of Have I Been Pwned into a compact index, about 3 times smaller, and returns the number of passwords. The file must be the one ordered by hash. The index is written to a temporary file first, which then replaces the destination.
+func BuildBreachIndex(textPath string, indexPath string) (int64, error) {
+	in, err := os.Open(textPath)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	out, err := ioutil.TempFile(filepath.Dir(indexPath), filepath.Base(indexPath)+".tmp")
+	if err != nil {
+		return 0, err
+	}
+
+	// Only cleans up if something went wrong, the file has been renamed otherwise
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	writer := bufio.NewWriterSize(out, 1<<20)
+	if _, err = writer.WriteString(breachIndexMagic); err != nil {
+		return 0, err
+	}
+
+	var previous []byte
+	var records int64
+	scanner := bufio.NewScanner(bufio.NewReaderSize(in, 1<<20))
+	full := make([]byte, sha1.Size)
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimRight(scanner.Bytes(), "\r")
+		if len(text) == 0 {
+			continue
+		}
+
+		hash, count, err := parseBreachLine(text)
+		if err != nil {
+			return 0, fmt.Errorf("Line %d: %s", line, err)
+		}
+		if _, err = hex.Decode(full, hash); err != nil {
+			return 0, fmt.Errorf("Line %d: invalid hash %s", line, hash)
+		}
+		prefix := full[:breachPrefixSize]
+
+		// Hashes sharing their prefix are merged, their counts added
+		if previous != nil && bytes.Equal(previous[:breachPrefixSize], prefix) {
+			binary.BigEndian.PutUint32(previous[breachPrefixSize:], breachCount(uint64(binary.BigEndian.Uint32(previous[breachPrefixSize:]))+uint64(count)))
+			continue
+		}
+		if previous != nil && bytes.Compare(previous[:breachPrefixSize], prefix) > 0 {
+			return 0, fmt.Errorf("Line %d: the hashes are not sorted, download the file ordered by hash", line)
+		}
+
+		if previous != nil {
+			if _, err = writer.Write(previous); err != nil {
+				return 0, err
+			}
+		} else {
+			previous = make([]byte, breachRecordSize)
+		}
+		copy(previous, prefix)
+		binary.BigEndian.PutUint32(previous[breachPrefixSize:], breachCount(uint64(count)))
+		records++
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	if previous != nil {
+		if _, err = writer.Write(previous); err != nil {
+			return 0, err
+		}
+	}
+	if err = writer.Flush(); err != nil {
+		return 0, err
+	}
+	if err = out.Sync(); err != nil {
+		return 0, err
+	}
+	if err = out.Close(); err != nil {
+		return 0, err
+	}
+
+	return records, os.Rename(out.Name(), indexPath)
+}
+
+// Caps a count to what a record of the index holds
+func breachCount(count uint64) uint32 {
+	if count > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(count)
+}
+
+// BreachedEntry is an entry whose password appears in the breaches
+type BreachedEntry struct {
+	EntryRef
+	// Number of times the password appears in the breaches
+	Count int
+}
+
+// BreachReport tells which passwords of a storage appear in the breaches, see Storage.Breached. The entries of each list are sorted.
+type BreachReport struct {
+	// Number of passwords looked for
+	Checked int
+	// Entries whose password appears in the breaches
+	Breached []BreachedEntry
+	// Entries which can't be decrypted, they have been tampered with
+	Tampered []EntryRef
+}
+
+// Breached decrypts all the passwords of the storage and looks for them in the breaches. The entries which can't be decrypted are reported as tampered with, like Audit does, and the others are checked anyway.
+func (s *Storage) Breached(key []byte, db BreachDB) (*BreachReport, error) {
+	layout, err := s.decodeLayout(key)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &BreachReport{}
+	for section, entries := range layout {
+		for name, entry := range entries {
+			password, err := s.Transcoder(key, section, name).DecodePassword(entry.Password)
+			if err != nil {
+				if _, ok := err.(*AuthError); ok {
+					report.Tampered = append(report.Tampered, EntryRef{section, name})
+					continue
+				}
+				return nil, err
+			}
+
+			count, err := db.Count(password)
+			if err != nil {
+				return nil, err
+			}
+			if count > 0 {
+				report.Breached = append(report.Breached, BreachedEntry{EntryRef{section, name}, count})
+			}
+			report.Checked++
+		}
+	}
+
+	sort.Slice(report.Breached, func(i, j int) bool { return refLess(report.Breached[i].EntryRef, report.Breached[j].EntryRef) })
+	sortRefs(report.Tampered)
+	return report, nil
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Writes a small file of Have I Been Pwned, ordered by hash with CRLF line endings, and returns it with its passwords in the order of the file. The count of each password is its position plus one.
+func writeBreachFile(t *testing.T) (string, []string) {
+	passwords := make([]string, 50)
+	for i := range passwords {
+		passwords[i] = fmt.Sprintf("password%d", i)
+	}
+	hash := func(password string) string {
+		sum := sha1.Sum([]byte(password))
+		return strings.ToUpper(hex.EncodeToString(sum[:]))
+	}
+	sort.Slice(passwords, func(i, j int) bool { return hash(passwords[i]) < hash(passwords[j]) })
+
+	var content strings.Builder
+	for i, password := range passwords {
+		fmt.Fprintf(&content, "%s:%d\r\n", hash(password), i+1)
+	}
+
+	path := filepath.Join(t.TempDir(), "pwned-passwords.txt")
+	if err := ioutil.WriteFile(path, []byte(content.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, passwords
+}
+
+// Checks the counts of the first, last and middle lines of the file, and of a password which is not in it
+func checkBreachDB(t *testing.T, path string, passwords []string) {
+	db, err := OpenBreachDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		password string
+		count    int
+	}{
+		{passwords[0], 1},
+		{passwords[len(passwords)-1], len(passwords)},
+		{passwords[len(passwords)/2], len(passwords)/2 + 1},
+		{"not breached", 0},
+		{"", 0},
+	}
+
+	for _, test := range tests {
+		if count, err := db.Count([]byte(test.password)); err != nil || count != test.count {
+			t.Errorf("Count(%q) returned %d, %v instead of %d", test.password, count, err, test.count)
+		}
+	}
+	for i, password := range passwords {
+		if count, err := db.Count([]byte(password)); err != nil || count != i+1 {
+			t.Errorf("Count(%q) returned %d, %v instead of %d", password, count, err, i+1)
+		}
+	}
+}
+
+func TestBreachText(t *testing.T) {
+	path, passwords := writeBreachFile(t)
+	checkBreachDB(t, path, passwords)
+}
+
+func TestBreachIndex(t *testing.T) {
+	path, passwords := writeBreachFile(t)
+	index := filepath.Join(filepath.Dir(path), "pwned.idx")
+
+	records, err := BuildBreachIndex(path, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records != int64(len(passwords)) {
+		t.Fatalf("BuildBreachIndex indexed %d passwords instead of %d", records, len(passwords))
+	}
+
+	data, err := ioutil.ReadFile(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), breachIndexMagic) || len(data) != len(breachIndexMagic)+len(passwords)*breachRecordSize {
+		t.Fatalf("BuildBreachIndex wrote %d bytes", len(data))
+	}
+
+	checkBreachDB(t, index, passwords)
+
+	// A truncated index is refused
+	if err = ioutil.WriteFile(index, data[:len(data)-1], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = OpenBreachDB(index); err == nil {
+		t.Fatal("OpenBreachDB accepted a truncated index")
+	}
+}
+
+func TestBreachIndexUnsorted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pwned-passwords.txt")
+	content := "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF:1\n0000000000000000000000000000000000000000:2\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := BuildBreachIndex(path, path+".idx"); err == nil || !strings.Contains(err.Error(), "not sorted") {
+		t.Fatalf("BuildBreachIndex returned %v", err)
+	}
+}
+
+// The entries which can't be decrypted are reported, and the others checked anyway
+func TestBreachedTampered(t *testing.T) {
+	path, passwords := writeBreachFile(t)
+	db, err := OpenBreachDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	vault := newTestVault(t, "passphrase")
+	vault.put(t, "breached", passwords[0], "", time.Now(), time.Now())
+	vault.put(t, "safe", "not breached", "", time.Now(), time.Now())
+	vault.put(t, "tampered", passwords[1], "", time.Now(), time.Now())
+	vault.storage.Sections["web"]["tampered"].Password = vault.storage.Sections["web"]["safe"].Password
+
+	report, err := vault.storage.Breached(vault.key, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 2 || len(report.Breached) != 1 || report.Breached[0].Name != "breached" || report.Breached[0].Count != 1 {
+		t.Fatalf("Breached returned %+v", report)
+	}
+	if len(report.Tampered) != 1 || report.Tampered[0] != (EntryRef{"web", "tampered"}) {
+		t.Fatalf("Breached reported %v as tampered with", report.Tampered)
+	}
+}
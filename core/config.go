@@ -0,0 +1,199 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Config is the configuration of mpm, shared by all the vaults. It is stored in the user's configuration directory, for example $HOME/.config/mpm/config.json on Linux.
+type Config struct {
+	// Registry of the named vaults, name -> absolute path of the master file
+	Vaults map[string]string `json:"Vaults,omitempty"`
+	// Name of the vault in use, empty for the default one
+	Current string `json:"Current,omitempty"`
+	// Alphabets defined by the user, name -> characters. They come after the pre-defined ones, see Alphas.
+	Alphabets map[string]string `json:"Alphabets,omitempty"`
+	// Presets of the generator, name -> preset
+	Presets map[string]*Preset `json:"Presets,omitempty"`
+	// Socket of the agent started with 'mpm agent' while it runs, the secret keys are only given to it
+	Agent string `json:"Agent,omitempty"`
+}
+
+// A Preset names the way to generate a password, so that a team can share it
+type Preset struct {
+	// Name of the alphabet, pre-defined or user-defined. If empty, all the character classes are allowed.
+	Alphabet string `json:"Alphabet,omitempty"`
+	Length   int    `json:"Length"`
+	// Minimum number of characters of each class, see Policy
+	Require map[string]int `json:"Require,omitempty"`
+	// Characters which never appear
+	Exclude string `json:"Exclude,omitempty"`
+	// Whether the ambiguous characters never appear, see AmbiguousChars
+	NoAmbiguous bool `json:"NoAmbiguous,omitempty"`
+	// Maximum number of times a character is repeated in a row, 0 for no limit
+	MaxRun int `json:"MaxRun,omitempty"`
+}
+
+// ConfigPath returns the path of the configuration file.
+func ConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "mpm", "config.json"), nil
+}
+
+// LoadConfig reads the configuration file. A missing file is an empty configuration, as is a missing configuration directory.
+func LoadConfig() (*Config, error) {
+	config := &Config{Vaults: make(map[string]string)}
+
+	path, err := ConfigPath()
+	if err != nil {
+		return config, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("Invalid configuration file %s: %s", path, err)
+	}
+
+	if config.Vaults == nil {
+		config.Vaults = make(map[string]string)
+	}
+	return config, nil
+}
+
+// Save writes the configuration file, creating its directory if needed.
+func (c *Config) Save() error {
+	path, err := ConfigPath()
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, data)
+}
+
+// AddVault registers a vault under a name. The path is made absolute. If the name is already registered, an error is raised.
+func (c *Config) AddVault(name string, path string) error {
+	if _, ok := c.Vaults[name]; ok {
+		return fmt.Errorf("Vault %s already exists", name)
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	c.Vaults[name] = abs
+	return nil
+}
+
+// UseVault makes a registered vault the one in use. An empty name goes back to the default vault.
+func (c *Config) UseVault(name string) error {
+	if _, ok := c.Vaults[name]; !ok && name != "" {
+		return fmt.Errorf("Vault %s does not exists", name)
+	}
+
+	c.Current = name
+	return nil
+}
+
+// VaultNames lists the names of the registered vaults, sorted.
+func (c *Config) VaultNames() []string {
+	names := make([]string, 0, len(c.Vaults))
+	for name := range c.Vaults {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// AllAlphabets returns the pre-defined alphabets followed by the user-defined ones, sorted by name. A user-defined alphabet must be valid, and not reuse the name of a pre-defined one.
+func (c *Config) AllAlphabets() ([]Alphabet, error) {
+	alphabets := append([]Alphabet{}, Alphas...)
+
+	names := make([]string, 0, len(c.Alphabets))
+	for name := range c.Alphabets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, err := FindAlphabet(Alphas, name); err == nil {
+			return nil, fmt.Errorf("Alphabet %s is pre-defined, choose another name", name)
+		}
+
+		alphabet, err := NewAlphabet(name, c.Alphabets[name])
+		if err != nil {
+			return nil, err
+		}
+		alphabets = append(alphabets, alphabet)
+	}
+
+	return alphabets, nil
+}
+
+// PresetNames lists the names of the presets, sorted.
+func (c *Config) PresetNames() []string {
+	names := make([]string, 0, len(c.Presets))
+	for name := range c.Presets {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Policy returns the policy of a preset, checked by Policy.Validate
+func (c *Config) Policy(preset string) (*Policy, error) {
+	p, ok := c.Presets[preset]
+	if !ok || p == nil {
+		return nil, fmt.Errorf("Preset %s does not exists", preset)
+	}
+
+	policy := NewPolicy(p.Length)
+	policy.Exclude = p.Exclude
+	policy.NoAmbiguous = p.NoAmbiguous
+	policy.MaxRun = p.MaxRun
+	for class, min := range p.Require {
+		policy.Classes[class] = min
+	}
+
+	if p.Alphabet != "" {
+		alphabets, err := c.AllAlphabets()
+		if err != nil {
+			return nil, err
+		}
+		alphabet, err := FindAlphabet(alphabets, p.Alphabet)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid preset %s: %s", preset, err)
+		}
+		policy.Characters = alphabet.Characters()
+	}
+
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid preset %s: %s", preset, err)
+	}
+	return policy, nil
+}
@@ -0,0 +1,191 @@
+package core
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Entry is a password stored in a section, along with its details. Sensitive data is encrypted, only the timestamps are in clear.
+type Entry struct {
+	// Encrypted password
+	Password string `json:"Password"`
+	// Encrypted details, see EntryDetails. Empty if there is none.
+	Details string `json:"Details,omitempty"`
+	// Encrypted one-time password generator, see OTP. Empty if there is none.
+	OTP string `json:"OTP,omitempty"`
+	// When the entry was created, and when it was last modified. Zero for the entries created before they were recorded.
+	Created  time.Time `json:"Created"`
+	Modified time.Time `json:"Modified"`
+	// Previous passwords, the most recent first
+	History []Revision `json:"History,omitempty"`
+}
+
+// Revision is a previous password of an entry. It keeps the encryption it had as the current password.
+type Revision struct {
+	// Encrypted password
+	Password string `json:"Password"`
+	// When the password was set, and when it was replaced
+	Modified time.Time `json:"Modified"`
+	Replaced time.Time `json:"Replaced"`
+}
+
+// Number of previous passwords kept for each entry
+const historySize = 10
+
+// EntryDetails holds the details of an entry, they are encrypted together as a JSON document.
+type EntryDetails struct {
+	Username string            `json:"Username,omitempty"`
+	URLs     []string          `json:"URLs,omitempty"`
+	Notes    string            `json:"Notes,omitempty"`
+	Fields   map[string]string `json:"Fields,omitempty"`
+	// Policy the password was generated with, reused when it is generated again
+	Policy *Policy `json:"Policy,omitempty"`
+}
+
+// Names of the fields of an entry, as used by the transcoders' associated data. The password has none, for compatibility with the passwords encrypted before entries had fields.
+const (
+	fieldPassword = ""
+	fieldDetails  = "details"
+	fieldOTP      = "otp"
+)
+
+// bare tells whether the entry only holds a password, as in the original format of the storage.
+func (e *Entry) bare() bool {
+	return e.Details == "" && e.OTP == "" && e.Created.IsZero() && e.Modified.IsZero() && len(e.History) == 0
+}
+
+// MarshalJSON writes bare entries as their encrypted password alone, as in the original format. This keeps the storages written before entries existed byte for byte identical, and their MAC valid.
+func (e *Entry) MarshalJSON() ([]byte, error) {
+	if e.bare() {
+		return json.Marshal(e.Password)
+	}
+
+	// The alias does not have the methods of Entry, which avoids an infinite recursion
+	type entry Entry
+	return json.Marshal((*entry)(e))
+}
+
+// UnmarshalJSON reads both entries and encrypted passwords alone, from the original format.
+func (e *Entry) UnmarshalJSON(data []byte) error {
+	var password string
+	if err := json.Unmarshal(data, &password); err == nil {
+		*e = Entry{Password: password}
+		return nil
+	}
+
+	type entry Entry
+	return json.Unmarshal(data, (*entry)(e))
+}
+
+// fieldTranscoder creates the transcoder of one field of an entry. The field is bound as associated data along with the section and name, so the fields of an entry can't be swapped either.
+func (s *Storage) fieldTranscoder(key []byte, section string, name string, field string) PasswordTranscoder {
+	if field == fieldPassword {
+		return s.Transcoder(key, section, name)
+	}
+
+	return NewFieldTranscoder(key, section, name, field)
+}
+
+// DecodeDetails decrypts the details of an entry. An entry without details has empty ones.
+func (s *Storage) DecodeDetails(key []byte, section string, name string, entry *Entry) (*EntryDetails, error) {
+	details := &EntryDetails{}
+	if entry.Details == "" {
+		return details, nil
+	}
+
+	decoded, err := s.fieldTranscoder(key, section, name, fieldDetails).DecodePassword(entry.Details)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(decoded, details); err != nil {
+		return nil, err
+	}
+
+	return details, nil
+}
+
+// EncodeDetails encrypts the details of an entry. Empty details are not stored at all.
+func (s *Storage) EncodeDetails(key []byte, section string, name string, details *EntryDetails) (string, error) {
+	if details == nil || (details.Username == "" && len(details.URLs) == 0 && details.Notes == "" && len(details.Fields) == 0 && details.Policy == nil) {
+		return "", nil
+	}
+
+	data, err := json.Marshal(details)
+	if err != nil {
+		return "", err
+	}
+
+	encoded, err := s.fieldTranscoder(key, section, name, fieldDetails).EncodePassword(string(data))
+	return string(encoded), err
+}
+
+// encryptedField points to an encrypted field of an entry, along with its name
+type encryptedField struct {
+	name  string
+	value *string
+}
+
+// encryptedFields lists the encrypted fields of the entry which are set. The previous passwords are encrypted as the password.
+func (e *Entry) encryptedFields() []encryptedField {
+	fields := []encryptedField{{fieldPassword, &e.Password}}
+	if e.Details != "" {
+		fields = append(fields, encryptedField{fieldDetails, &e.Details})
+	}
+	if e.OTP != "" {
+		fields = append(fields, encryptedField{fieldOTP, &e.OTP})
+	}
+	for i := range e.History {
+		fields = append(fields, encryptedField{fieldPassword, &e.History[i].Password})
+	}
+
+	return fields
+}
+
+// legacy tells whether one of the encrypted fields of the entry uses the legacy transcoder.
+func (e *Entry) legacy() bool {
+	for _, field := range e.encryptedFields() {
+		if IsLegacy(*field.value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// replacePassword sets a new encrypted password, the current one is pushed to the history. The oldest passwords are dropped beyond historySize.
+func (e *Entry) replacePassword(data string) {
+	now := time.Now().UTC()
+	if e.Password != "" && e.Password != data {
+		history := []Revision{{Password: e.Password, Modified: e.Modified, Replaced: now}}
+		e.History = append(history, e.History...)
+		if len(e.History) > historySize {
+			e.History = e.History[:historySize]
+		}
+	}
+
+	e.Password = data
+	e.Modified = now
+}
+
+// reencrypt decrypts all the fields of an entry, and encrypts them again for another key, section or name. The timestamps are kept, the original entry is not modified.
+func (s *Storage) reencrypt(entry *Entry, oldKey []byte, oldSection string, oldName string, newKey []byte, newSection string, newName string) (*Entry, error) {
+	copied := *entry
+	copied.History = append([]Revision(nil), entry.History...)
+
+	for _, field := range copied.encryptedFields() {
+		decoded, err := s.fieldTranscoder(oldKey, oldSection, oldName, field.name).DecodePassword(*field.value)
+		if err != nil {
+			return nil, err
+		}
+
+		encoded, err := NewFieldTranscoder(newKey, newSection, newName, field.name).EncodePassword(string(decoded))
+		if err != nil {
+			return nil, err
+		}
+
+		*field.value = string(encoded)
+	}
+
+	return &copied, nil
+}
@@ -0,0 +1,183 @@
+package core
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Export decrypts all the entries of the storage, with their details, second factor and history. They are sorted by section, then by name.
+func (s *Storage) Export(key []byte) ([]ImportedEntry, error) {
+	layout, err := s.decodeLayout(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ImportedEntry
+	for section, sec := range layout {
+		for name, entry := range sec {
+			exported, err := s.exportEntry(key, section, name, entry)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, *exported)
+		}
+	}
+
+	sortImported(entries)
+	return entries, nil
+}
+
+// Decrypts an entry, with its details, second factor and history
+func (s *Storage) exportEntry(key []byte, section string, name string, entry *Entry) (*ImportedEntry, error) {
+	transcoder := s.Transcoder(key, section, name)
+	password, err := transcoder.DecodePassword(entry.Password)
+	if err != nil {
+		return nil, err
+	}
+	details, err := s.DecodeDetails(key, section, name, entry)
+	if err != nil {
+		return nil, err
+	}
+	otp, err := s.DecodeOTP(key, section, name, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	exported := &ImportedEntry{Section: section, Name: name, Password: string(password), Details: *details, OTP: otp, Created: entry.Created, Modified: entry.Modified}
+	for _, revision := range entry.History {
+		previous, err := transcoder.DecodePassword(revision.Password)
+		if err != nil {
+			return nil, err
+		}
+		exported.History = append(exported.History, ImportedRevision{string(previous), revision.Modified, revision.Replaced})
+	}
+
+	return exported, nil
+}
+
+// ExportCSV writes entries in clear in the CSV format of LastPass, which most password managers import. The sections become groups. There is a single URL column: the other URLs and the custom fields go to the notes, the policy and history are left out.
+func ExportCSV(entries []ImportedEntry) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	writer.Write([]string{"url", "username", "password", "totp", "extra", "name", "grouping", "fav"})
+
+	for _, entry := range entries {
+		var url, totp string
+		if len(entry.Details.URLs) > 0 {
+			url = entry.Details.URLs[0]
+		}
+		if entry.OTP != nil {
+			totp = entry.OTP.URI()
+		}
+
+		notes := []string{entry.Details.Notes}
+		for i := 1; i < len(entry.Details.URLs); i++ {
+			notes = append(notes, "URL: "+entry.Details.URLs[i])
+		}
+		keys := make([]string, 0, len(entry.Details.Fields))
+		for k := range entry.Details.Fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			notes = append(notes, fmt.Sprintf("%s: %s", k, entry.Details.Fields[k]))
+		}
+
+		writer.Write([]string{url, entry.Details.Username, entry.Password, totp, strings.TrimSpace(strings.Join(notes, "\n")), entry.Name, strings.Replace(entry.Section, "/", "\\", -1), "0"})
+	}
+
+	writer.Flush()
+	return buffer.Bytes(), writer.Error()
+}
+
+// The unencrypted JSON export of Bitwarden, see bitwardenImporter
+type bitwardenOutput struct {
+	Encrypted bool                    `json:"encrypted"`
+	Folders   []bitwardenOutputFolder `json:"folders"`
+	Items     []bitwardenOutputItem   `json:"items"`
+}
+
+type bitwardenOutputFolder struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type bitwardenOutputItem struct {
+	ID       string               `json:"id"`
+	FolderID string               `json:"folderId"`
+	Type     int                  `json:"type"`
+	Name     string               `json:"name"`
+	Notes    *string              `json:"notes"`
+	Favorite bool                 `json:"favorite"`
+	Fields   []bitwardenField     `json:"fields,omitempty"`
+	Login    bitwardenOutputLogin `json:"login"`
+	Created  time.Time            `json:"creationDate"`
+	Modified time.Time            `json:"revisionDate"`
+}
+
+type bitwardenField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Type  int    `json:"type"`
+}
+
+type bitwardenOutputLogin struct {
+	URIs     []bitwardenURI `json:"uris"`
+	Username *string        `json:"username"`
+	Password string         `json:"password"`
+	TOTP     *string        `json:"totp"`
+}
+
+type bitwardenURI struct {
+	Match *int   `json:"match"`
+	URI   string `json:"uri"`
+}
+
+// ExportJSON writes entries in clear in the unencrypted JSON format of Bitwarden, which other password managers import too. The sections become folders, and the policy and history are left out.
+func ExportJSON(entries []ImportedEntry) ([]byte, error) {
+	output := bitwardenOutput{Folders: []bitwardenOutputFolder{}, Items: []bitwardenOutputItem{}}
+	folders := make(map[string]string)
+
+	for i, entry := range entries {
+		folder, ok := folders[entry.Section]
+		if !ok {
+			folder = fmt.Sprintf("mpm-folder-%d", len(folders)+1)
+			folders[entry.Section] = folder
+			output.Folders = append(output.Folders, bitwardenOutputFolder{folder, entry.Section})
+		}
+
+		item := bitwardenOutputItem{ID: fmt.Sprintf("mpm-item-%d", i+1), FolderID: folder, Type: 1, Name: entry.Name, Created: entry.Created, Modified: entry.Modified}
+		item.Login = bitwardenOutputLogin{URIs: []bitwardenURI{}, Password: entry.Password}
+		if notes := entry.Details.Notes; notes != "" {
+			item.Notes = &notes
+		}
+		if username := entry.Details.Username; username != "" {
+			item.Login.Username = &username
+		}
+		if entry.OTP != nil {
+			uri := entry.OTP.URI()
+			item.Login.TOTP = &uri
+		}
+		for _, url := range entry.Details.URLs {
+			item.Login.URIs = append(item.Login.URIs, bitwardenURI{URI: url})
+		}
+
+		keys := make([]string, 0, len(entry.Details.Fields))
+		for k := range entry.Details.Fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			item.Fields = append(item.Fields, bitwardenField{k, entry.Details.Fields[k], 0})
+		}
+
+		output.Items = append(output.Items, item)
+	}
+
+	return json.MarshalIndent(output, "", "  ")
+}
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"encoding/base32"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// Helpers shared by the tests of several files
+
+// A KDF cheap enough for the tests
+func testKDF(t *testing.T) *KDF {
+	kdf, err := NewKDF(KDFArgon2id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kdf.Time, kdf.Memory, kdf.Threads = 1, 8, 1
+	return kdf
+}
+
+// A storage of the tests, with its secret key
+type testVault struct {
+	storage *Storage
+	key     []byte
+}
+
+func newTestVault(t *testing.T, passphrase string) *testVault {
+	storage, err := InitPassphrase([]byte(passphrase))
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.KDF = testKDF(t)
+
+	key, err := storage.DeriveKey(passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testVault{storage, key}
+}
+
+// Copies the storage, as another machine would have it
+func (v *testVault) clone(t *testing.T) *testVault {
+	v.seal(t)
+	data, err := json.Marshal(v.storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage, err := parseStorage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testVault{storage, v.key}
+}
+
+func (v *testVault) seal(t *testing.T) {
+	if err := v.storage.Seal(v.key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Writes an entry with its dates, its notes and its previous passwords, the most recent first
+func (v *testVault) put(t *testing.T, name string, password string, notes string, created time.Time, modified time.Time, history ...string) {
+	transcoder := v.storage.Transcoder(v.key, "web", name)
+	encoded, err := transcoder.EncodePassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := &Entry{Password: string(encoded), Created: created, Modified: modified}
+
+	if entry.Details, err = v.storage.EncodeDetails(v.key, "web", name, &EntryDetails{Notes: notes}); err != nil {
+		t.Fatal(err)
+	}
+	for _, previous := range history {
+		encoded, err := transcoder.EncodePassword(previous)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.History = append(entry.History, Revision{Password: string(encoded), Modified: created, Replaced: modified})
+	}
+
+	if err = v.storage.SetEntry("web", name, entry); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Removes an entry, leaving a tombstone with the given date, or none if it is zero as in the storages written before them
+func (v *testVault) remove(t *testing.T, name string, removed time.Time) {
+	if err := v.storage.Delete("web", name); err != nil {
+		t.Fatal(err)
+	}
+	if removed.IsZero() {
+		delete(v.storage.Deleted["web"], name)
+	} else {
+		v.storage.Deleted["web"][name] = removed
+	}
+}
+
+// Returns the password of an entry and its previous ones, empty if it doesn't exist
+func (v *testVault) password(t *testing.T, name string) (string, []string) {
+	entry, err := v.storage.GetEntry("web", name)
+	if err != nil {
+		return "", nil
+	}
+
+	transcoder := v.storage.Transcoder(v.key, "web", name)
+	password, err := transcoder.DecodePassword(entry.Password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var history []string
+	for _, revision := range entry.History {
+		previous, err := transcoder.DecodePassword(revision.Password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		history = append(history, string(previous))
+	}
+	return string(password), history
+}
+
+// Encodes a secret the way the sites give it
+func otpSecret(secret string) string {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte(secret))
+}
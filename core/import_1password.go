@@ -0,0 +1,202 @@
+package core
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// Reads the exports of 1Password: the 1PUX archive, where the vaults become sections, or the CSV file
+type onePasswordImporter struct{}
+
+// The parts of the export.data file of the 1PUX archive read by the importer
+type onePasswordExport struct {
+	Accounts []struct {
+		Vaults []struct {
+			Attrs struct {
+				Name string `json:"name"`
+			} `json:"attrs"`
+			Items []onePasswordItem `json:"items"`
+		} `json:"vaults"`
+	} `json:"accounts"`
+}
+
+type onePasswordItem struct {
+	Created  int64 `json:"createdAt"`
+	Modified int64 `json:"updatedAt"`
+	Overview struct {
+		Title string `json:"title"`
+		URL   string `json:"url"`
+		URLs  []struct {
+			URL string `json:"url"`
+		} `json:"urls"`
+	} `json:"overview"`
+	Details struct {
+		LoginFields []struct {
+			Name        string `json:"name"`
+			Value       string `json:"value"`
+			Designation string `json:"designation"`
+		} `json:"loginFields"`
+		Notes    string `json:"notesPlain"`
+		Password string `json:"password"`
+		Sections []struct {
+			Fields []struct {
+				Title string                     `json:"title"`
+				Value map[string]json.RawMessage `json:"value"`
+			} `json:"fields"`
+		} `json:"sections"`
+	} `json:"details"`
+}
+
+func (onePasswordImporter) Name() string {
+	return "1password"
+}
+
+func (onePasswordImporter) Description() string {
+	return "the 1PUX or CSV export of 1Password"
+}
+
+func (o onePasswordImporter) Read(path string, defaultSection string) ([]ImportedEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, 4)
+	_, err = file.Read(magic)
+	file.Close()
+
+	// The 1PUX archives are zip files, anything else is read as CSV
+	if err == nil && bytes.Equal(magic, []byte("PK\x03\x04")) {
+		return o.read1PUX(path, defaultSection)
+	}
+	return o.readCSV(path, defaultSection)
+}
+
+// Reads a 1PUX archive, the items of each vault going to the section named after it
+func (o onePasswordImporter) read1PUX(path string, defaultSection string) ([]ImportedEntry, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	var export *onePasswordExport
+	for _, file := range archive.File {
+		if file.Name != "export.data" {
+			continue
+		}
+
+		reader, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		export = &onePasswordExport{}
+		if err = json.Unmarshal(data, export); err != nil {
+			return nil, fmt.Errorf("Invalid 1PUX export %s: %s", path, err)
+		}
+	}
+	if export == nil {
+		return nil, fmt.Errorf("Invalid 1PUX export %s: export.data is missing", path)
+	}
+
+	var entries []ImportedEntry
+	for _, account := range export.Accounts {
+		for _, vault := range account.Vaults {
+			section := vault.Attrs.Name
+			if section == "" {
+				section = defaultSection
+			}
+
+			for _, item := range vault.Items {
+				entries = append(entries, o.readItem(item, section))
+			}
+		}
+	}
+	return entries, nil
+}
+
+// Reads an item of a 1PUX archive. The fields of its sections become custom fields, except its second factor.
+func (onePasswordImporter) readItem(item onePasswordItem, section string) ImportedEntry {
+	imported := ImportedEntry{Section: section, Name: item.Overview.Title, Password: item.Details.Password}
+	if item.Created > 0 {
+		imported.Created = time.Unix(item.Created, 0)
+	}
+	if item.Modified > 0 {
+		imported.Modified = time.Unix(item.Modified, 0)
+	}
+	imported.Details.Notes = item.Details.Notes
+
+	addImportedURL(&imported.Details, item.Overview.URL)
+	for _, url := range item.Overview.URLs {
+		addImportedURL(&imported.Details, url.URL)
+	}
+
+	for _, field := range item.Details.LoginFields {
+		switch field.Designation {
+		case "username":
+			imported.Details.Username = field.Value
+		case "password":
+			imported.Password = field.Value
+		default:
+			addImportedField(&imported.Details, field.Name, field.Value)
+		}
+	}
+
+	for _, sec := range item.Details.Sections {
+		for _, field := range sec.Fields {
+			for kind, raw := range field.Value {
+				var value string
+				if json.Unmarshal(raw, &value) != nil {
+					// The values which are not text, such as dates or addresses, are kept as they are
+					value = string(raw)
+				}
+
+				if kind == "totp" {
+					setImportedOTP(&imported, value)
+				} else {
+					addImportedField(&imported.Details, field.Title, value)
+				}
+			}
+		}
+	}
+
+	return imported
+}
+
+// Reads a CSV export, whose columns depend on the version of 1Password. There is no folder in it.
+func (onePasswordImporter) readCSV(path string, defaultSection string) ([]ImportedEntry, error) {
+	rows, err := readImportedCSV(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]ImportedEntry, 0, len(rows))
+	for _, row := range rows {
+		imported := ImportedEntry{Section: defaultSection, Name: csvValue(row, "title", "name"), Password: csvRawValue(row, "password")}
+		imported.Details.Username = csvValue(row, "username")
+		imported.Details.Notes = csvRawValue(row, "notes", "notesplain")
+		for _, url := range strings.Fields(csvValue(row, "url", "urls", "website")) {
+			addImportedURL(&imported.Details, url)
+		}
+		setImportedOTP(&imported, csvValue(row, "otpauth", "one-time password", "totp"))
+
+		entries = append(entries, imported)
+	}
+
+	return entries, nil
+}
+
+func init() {
+	RegisterImporter(onePasswordImporter{})
+}
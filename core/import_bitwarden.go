@@ -0,0 +1,112 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// Reads the unencrypted JSON export of Bitwarden. The folders become sections, or the collections for the exports of an organization.
+type bitwardenImporter struct{}
+
+// The parts of the JSON export read by the importer
+type bitwardenExport struct {
+	Encrypted bool `json:"encrypted"`
+	Folders   []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"folders"`
+	Collections []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"collections"`
+	Items []bitwardenItem `json:"items"`
+}
+
+type bitwardenItem struct {
+	Name          string   `json:"name"`
+	Notes         string   `json:"notes"`
+	FolderID      string   `json:"folderId"`
+	CollectionIDs []string `json:"collectionIds"`
+	Login         *struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		TOTP     string `json:"totp"`
+		URIs     []struct {
+			URI string `json:"uri"`
+		} `json:"uris"`
+	} `json:"login"`
+	Fields []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"fields"`
+	Created  time.Time `json:"creationDate"`
+	Modified time.Time `json:"revisionDate"`
+}
+
+func (bitwardenImporter) Name() string {
+	return "bitwarden"
+}
+
+func (bitwardenImporter) Description() string {
+	return "the unencrypted JSON export of Bitwarden"
+}
+
+func (bitwardenImporter) Read(path string, defaultSection string) ([]ImportedEntry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var export bitwardenExport
+	if err = json.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("Invalid Bitwarden export %s: %s", path, err)
+	}
+	if export.Encrypted {
+		return nil, fmt.Errorf("The Bitwarden export %s is encrypted, export your vault again as unencrypted JSON", path)
+	}
+
+	folders := make(map[string]string)
+	for _, folder := range export.Folders {
+		folders[folder.ID] = folder.Name
+	}
+	for _, collection := range export.Collections {
+		folders[collection.ID] = collection.Name
+	}
+
+	entries := make([]ImportedEntry, 0, len(export.Items))
+	for _, item := range export.Items {
+		imported := ImportedEntry{Section: defaultSection, Name: item.Name, Created: item.Created, Modified: item.Modified}
+		imported.Details.Notes = item.Notes
+
+		if folder, ok := folders[item.FolderID]; ok {
+			imported.Section = folder
+		} else if len(item.CollectionIDs) > 0 {
+			if collection, ok := folders[item.CollectionIDs[0]]; ok {
+				imported.Section = collection
+			}
+		}
+
+		// Only the logins have a password, the cards, identities and notes are skipped
+		if item.Login != nil {
+			imported.Password = item.Login.Password
+			imported.Details.Username = item.Login.Username
+			for _, uri := range item.Login.URIs {
+				addImportedURL(&imported.Details, uri.URI)
+			}
+			setImportedOTP(&imported, item.Login.TOTP)
+		}
+		for _, field := range item.Fields {
+			addImportedField(&imported.Details, field.Name, field.Value)
+		}
+
+		entries = append(entries, imported)
+	}
+
+	return entries, nil
+}
+
+func init() {
+	RegisterImporter(bitwardenImporter{})
+}
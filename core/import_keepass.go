@@ -0,0 +1,170 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reads the XML export of KeePass 2 and KeePassXC. The groups below the root one become sections, their path joined by slashes, and the recycle bin is left out.
+type keepassImporter struct{}
+
+// The parts of the XML export read by the importer
+type keepassFile struct {
+	RecycleBin string         `xml:"Meta>RecycleBinUUID"`
+	Groups     []keepassGroup `xml:"Root>Group"`
+}
+
+type keepassGroup struct {
+	UUID    string         `xml:"UUID"`
+	Name    string         `xml:"Name"`
+	Entries []keepassEntry `xml:"Entry"`
+	Groups  []keepassGroup `xml:"Group"`
+}
+
+type keepassEntry struct {
+	Strings []struct {
+		Key   string `xml:"Key"`
+		Value string `xml:"Value"`
+	} `xml:"String"`
+	Created  string `xml:"Times>CreationTime"`
+	Modified string `xml:"Times>LastModificationTime"`
+}
+
+// Seconds between the year 1 and the Unix epoch, the origin of the dates of KDBX 4
+const keepassEpoch = 62135596800
+
+func (keepassImporter) Name() string {
+	return "keepass"
+}
+
+func (keepassImporter) Description() string {
+	return "the XML export of KeePass 2 or KeePassXC"
+}
+
+func (k keepassImporter) Read(path string, defaultSection string) ([]ImportedEntry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file keepassFile
+	if err = xml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("Invalid KeePass XML export %s: %s", path, err)
+	}
+
+	var entries []ImportedEntry
+	for _, root := range file.Groups {
+		entries = k.readGroup(entries, root, "", defaultSection, file.RecycleBin)
+	}
+	return entries, nil
+}
+
+// Reads the entries of a group and of its subgroups, the path being the one of the group's section
+func (k keepassImporter) readGroup(entries []ImportedEntry, group keepassGroup, path string, defaultSection string, recycleBin string) []ImportedEntry {
+	section := path
+	if section == "" {
+		section = defaultSection
+	}
+
+	for _, entry := range group.Entries {
+		entries = append(entries, k.readEntry(entry, section))
+	}
+
+	for _, sub := range group.Groups {
+		if (recycleBin != "" && sub.UUID == recycleBin) || (recycleBin == "" && sub.Name == "Recycle Bin") {
+			continue
+		}
+
+		subPath := sub.Name
+		if path != "" {
+			subPath = path + "/" + sub.Name
+		}
+		entries = k.readGroup(entries, sub, subPath, defaultSection, recycleBin)
+	}
+
+	return entries
+}
+
+// Reads an entry, its standard strings become the details and the other ones custom fields
+func (keepassImporter) readEntry(entry keepassEntry, section string) ImportedEntry {
+	imported := ImportedEntry{Section: section, Created: keepassTime(entry.Created), Modified: keepassTime(entry.Modified)}
+
+	// The second factor set by KeePass itself, in several strings
+	var timeOTP OTP
+	for _, str := range entry.Strings {
+		switch str.Key {
+		case "Title":
+			imported.Name = str.Value
+		case "UserName":
+			imported.Details.Username = str.Value
+		case "Password":
+			imported.Password = str.Value
+		case "URL":
+			addImportedURL(&imported.Details, str.Value)
+		case "Notes":
+			imported.Details.Notes = str.Value
+		case "otp":
+			setImportedOTP(&imported, str.Value)
+		case "TimeOtp-Secret-Base32":
+			timeOTP.Secret = str.Value
+		case "TimeOtp-Length":
+			timeOTP.Digits, _ = strconv.Atoi(str.Value)
+		case "TimeOtp-Period":
+			timeOTP.Period, _ = strconv.Atoi(str.Value)
+		case "TimeOtp-Algorithm":
+			timeOTP.Algorithm = strings.TrimPrefix(str.Value, "HMAC-")
+		default:
+			addImportedField(&imported.Details, str.Key, str.Value)
+		}
+	}
+
+	if timeOTP.Secret != "" && imported.OTP == nil {
+		otp, err := NewOTP(timeOTP.Secret)
+		if err == nil {
+			if timeOTP.Digits != 0 {
+				otp.Digits = timeOTP.Digits
+			}
+			if timeOTP.Period != 0 {
+				otp.Period = timeOTP.Period
+			}
+			if timeOTP.Algorithm != "" {
+				otp.Algorithm = timeOTP.Algorithm
+			}
+			err = otp.normalize()
+		}
+
+		if err == nil {
+			imported.OTP = otp
+		} else {
+			addImportedField(&imported.Details, "otp", timeOTP.Secret)
+		}
+	}
+
+	return imported
+}
+
+// Reads a date of the export: RFC 3339 up to KDBX 3.1, then base64 encoded seconds since the year 1. Zero if it can't be read.
+func keepassTime(value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+
+	if date, err := time.Parse(time.RFC3339, value); err == nil {
+		return date
+	}
+
+	if raw, err := base64.StdEncoding.DecodeString(value); err == nil && len(raw) == 8 {
+		return time.Unix(int64(binary.LittleEndian.Uint64(raw))-keepassEpoch, 0).UTC()
+	}
+	return time.Time{}
+}
+
+func init() {
+	RegisterImporter(keepassImporter{})
+}
@@ -0,0 +1,49 @@
+package core
+
+import (
+	"strings"
+)
+
+// Reads the CSV export of LastPass. The groups become sections, the subfolders being separated by slashes instead of backslashes.
+type lastPassImporter struct{}
+
+// URL given by LastPass to the secure notes
+const lastPassNoteURL = "http://sn"
+
+func (lastPassImporter) Name() string {
+	return "lastpass"
+}
+
+func (lastPassImporter) Description() string {
+	return "the CSV export of LastPass"
+}
+
+func (lastPassImporter) Read(path string, defaultSection string) ([]ImportedEntry, error) {
+	rows, err := readImportedCSV(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]ImportedEntry, 0, len(rows))
+	for _, row := range rows {
+		imported := ImportedEntry{Section: defaultSection, Name: csvValue(row, "name"), Password: csvRawValue(row, "password")}
+		if group := csvValue(row, "grouping"); group != "" {
+			imported.Section = strings.Replace(group, "\\", "/", -1)
+		}
+
+		imported.Details.Username = csvValue(row, "username")
+		imported.Details.Notes = csvRawValue(row, "extra")
+		if url := csvValue(row, "url"); url != lastPassNoteURL {
+			addImportedURL(&imported.Details, url)
+		}
+		setImportedOTP(&imported, csvValue(row, "totp"))
+
+		entries = append(entries, imported)
+	}
+
+	return entries, nil
+}
+
+func init() {
+	RegisterImporter(lastPassImporter{})
+}
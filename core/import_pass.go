@@ -0,0 +1,134 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Reads the directory of the standard unix password manager, pass. Each file is decrypted with gpg, which asks for the passphrase of your key through its agent. The directories become sections, their path joined by slashes.
+type passImporter struct{}
+
+// Keys of the "key: value" lines of a pass file which go to the details, the other keys become custom fields
+var passUsernameKeys = map[string]bool{"login": true, "user": true, "username": true, "email": true}
+var passURLKeys = map[string]bool{"url": true, "website": true, "site": true}
+
+func (passImporter) Name() string {
+	return "pass"
+}
+
+func (passImporter) Description() string {
+	return "the directory of pass, usually $HOME/.password-store (needs gpg)"
+}
+
+func (p passImporter) Read(path string, defaultSection string) ([]ImportedEntry, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not the directory of a pass store", path)
+	}
+	if _, err = exec.LookPath("gpg"); err != nil {
+		return nil, fmt.Errorf("gpg is needed to decrypt the pass store: %s", err)
+	}
+
+	var entries []ImportedEntry
+	err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// The hidden directories hold the git repository and the extensions of pass
+		if info.IsDir() {
+			if file != path && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(file) != ".gpg" {
+			return nil
+		}
+
+		relative, err := filepath.Rel(path, file)
+		if err != nil {
+			return err
+		}
+		section := filepath.ToSlash(filepath.Dir(relative))
+		if section == "." {
+			section = defaultSection
+		}
+
+		content, err := p.decrypt(file)
+		if err != nil {
+			return err
+		}
+
+		imported := p.parse(content)
+		imported.Section = section
+		imported.Name = strings.TrimSuffix(filepath.Base(relative), ".gpg")
+		imported.Modified = info.ModTime()
+		entries = append(entries, imported)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Decrypts a file of the store with gpg
+func (passImporter) decrypt(file string) (string, error) {
+	var out bytes.Buffer
+	command := exec.Command("gpg", "--quiet", "--yes", "--batch", "--decrypt", file)
+	command.Stdout = &out
+	command.Stderr = os.Stderr
+
+	if err := command.Run(); err != nil {
+		return "", fmt.Errorf("Impossible to decrypt %s with gpg: %s", file, err)
+	}
+	return out.String(), nil
+}
+
+// Reads the content of a pass file: the password on the first line, then "key: value" lines, an otpauth URI, and notes
+func (passImporter) parse(content string) ImportedEntry {
+	lines := strings.Split(strings.Replace(content, "\r\n", "\n", -1), "\n")
+	imported := ImportedEntry{Password: lines[0]}
+
+	var notes []string
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(strings.ToLower(trimmed), "otpauth:") {
+			setImportedOTP(&imported, trimmed)
+			continue
+		}
+
+		if i := strings.Index(trimmed, ":"); i > 0 && !strings.Contains(trimmed[:i], " ") {
+			key, value := strings.ToLower(trimmed[:i]), strings.TrimSpace(trimmed[i+1:])
+			switch {
+			case passUsernameKeys[key] && imported.Details.Username == "":
+				imported.Details.Username = value
+				continue
+			case passURLKeys[key]:
+				addImportedURL(&imported.Details, value)
+				continue
+			case !strings.HasPrefix(value, "//"):
+				addImportedField(&imported.Details, trimmed[:i], value)
+				continue
+			}
+		}
+
+		notes = append(notes, line)
+	}
+
+	imported.Details.Notes = strings.TrimSpace(strings.Join(notes, "\n"))
+	return imported
+}
+
+func init() {
+	RegisterImporter(passImporter{})
+}
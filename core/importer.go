@@ -0,0 +1,329 @@
+package core
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ImportedEntry is an entry in clear, read from the export of another password manager or from an archive of mpm
+type ImportedEntry struct {
+	Section  string
+	Name     string
+	Password string
+	Details  EntryDetails
+	// Second factor, nil if there is none
+	OTP *OTP
+	// Dates given by the other password manager, zero if it doesn't record them
+	Created  time.Time
+	Modified time.Time
+	// Previous passwords, the most recent first. Only the archives of mpm have them.
+	History []ImportedRevision
+}
+
+// ImportedRevision is a previous password of an imported entry, in clear
+type ImportedRevision struct {
+	Password string
+	Modified time.Time
+	Replaced time.Time
+}
+
+// Importer reads the passwords exported by another password manager. The folders become sections, the entries outside of any folder go to a default section.
+type Importer interface {
+	// Name of the format, as given to 'mpm import --from'
+	Name() string
+	// What the importer reads, as shown by the help
+	Description() string
+	// Read reads the export found at a path. Items without a password, such as secure notes, are returned with an empty one: they are skipped when imported.
+	Read(path string, defaultSection string) ([]ImportedEntry, error)
+}
+
+// Importers by the name of their format, see RegisterImporter
+var importers = make(map[string]Importer)
+
+// RegisterImporter makes a format available to 'mpm import --from'. Each importer registers itself when the package is initialized.
+func RegisterImporter(importer Importer) {
+	importers[importer.Name()] = importer
+}
+
+// Importers returns the registered importers, sorted by name.
+func Importers() []Importer {
+	list := make([]Importer, 0, len(importers))
+	for _, importer := range importers {
+		list = append(list, importer)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+
+	return list
+}
+
+// FindImporter returns the importer of a format, or an error listing the known ones.
+func FindImporter(format string) (Importer, error) {
+	if importer, ok := importers[strings.ToLower(format)]; ok {
+		return importer, nil
+	}
+
+	names := make([]string, 0, len(importers))
+	for _, importer := range Importers() {
+		names = append(names, importer.Name())
+	}
+	return nil, fmt.Errorf("Unknown format %s, expected one of %s", format, strings.Join(names, ", "))
+}
+
+// SkippedEntry is an item of an export which can't be imported
+type SkippedEntry struct {
+	EntryRef
+	Reason string
+}
+
+// ImportPlan tells what importing entries will do to a storage, see Storage.PlanImport. The entries of each list are sorted.
+type ImportPlan struct {
+	// Entries which don't exist in the storage yet
+	New []ImportedEntry
+	// Entries which already exist in the storage with another password, they are only replaced on demand
+	Conflicts []ImportedEntry
+	// Entries which already exist in the storage with the same password, they are left untouched
+	Unchanged []EntryRef
+	// Items which can't be imported
+	Skipped []SkippedEntry
+	// Entries renamed because the export holds several of them with the same section and name, by their new name
+	Renamed map[EntryRef]string
+}
+
+// PlanImport sorts the imported entries between the new ones, those conflicting with the entries of the storage and those already there with the same password, without modifying the storage. The items without a password are skipped. Entries sharing their section and name in the export are told apart with a number, as in "name (2)". The storage must be unlocked if its names are encrypted.
+func (s *Storage) PlanImport(key []byte, entries []ImportedEntry) *ImportPlan {
+	plan := &ImportPlan{Renamed: make(map[EntryRef]string)}
+	seen := make(map[EntryRef]bool)
+
+	for _, entry := range entries {
+		entry.Section = strings.TrimSpace(entry.Section)
+		entry.Name = strings.TrimSpace(entry.Name)
+		if entry.Name == "" {
+			entry.Name = "untitled"
+		}
+
+		ref := EntryRef{entry.Section, entry.Name}
+		if entry.Password == "" {
+			plan.Skipped = append(plan.Skipped, SkippedEntry{ref, "no password"})
+			continue
+		}
+		if entry.Section == "" {
+			plan.Skipped = append(plan.Skipped, SkippedEntry{ref, "no section"})
+			continue
+		}
+
+		for i := 2; seen[EntryRef{entry.Section, entry.Name}]; i++ {
+			entry.Name = fmt.Sprintf("%s (%d)", ref.Name, i)
+		}
+		if entry.Name != ref.Name {
+			plan.Renamed[EntryRef{entry.Section, entry.Name}] = ref.Name
+		}
+		seen[EntryRef{entry.Section, entry.Name}] = true
+
+		existing, err := s.GetEntry(entry.Section, entry.Name)
+		if err != nil {
+			plan.New = append(plan.New, entry)
+			continue
+		}
+
+		// An entry which can't be decrypted is a conflict, importing it again repairs it
+		if password, err := s.Transcoder(key, entry.Section, entry.Name).DecodePassword(existing.Password); err == nil && string(password) == entry.Password {
+			plan.Unchanged = append(plan.Unchanged, EntryRef{entry.Section, entry.Name})
+		} else {
+			plan.Conflicts = append(plan.Conflicts, entry)
+		}
+	}
+
+	sortImported(plan.New)
+	sortImported(plan.Conflicts)
+	sortRefs(plan.Unchanged)
+	sort.Slice(plan.Skipped, func(i, j int) bool { return refLess(plan.Skipped[i].EntryRef, plan.Skipped[j].EntryRef) })
+	return plan
+}
+
+// Sorts imported entries by section, then by name
+func sortImported(entries []ImportedEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return refLess(EntryRef{entries[i].Section, entries[i].Name}, EntryRef{entries[j].Section, entries[j].Name})
+	})
+}
+
+// Import encrypts the entries of a plan into the storage, and returns how many were written. The conflicting entries are replaced if overwrite is set, their previous password kept in their history, and left untouched otherwise. The dates given by the other password manager are kept. The storage must be unlocked if its names are encrypted.
+func (s *Storage) Import(key []byte, plan *ImportPlan, overwrite bool) (int, error) {
+	entries := plan.New
+	if overwrite {
+		entries = append(append([]ImportedEntry(nil), plan.New...), plan.Conflicts...)
+	}
+
+	for _, imported := range entries {
+		if err := s.importEntry(key, imported); err != nil {
+			return 0, fmt.Errorf("Impossible to import %s/%s: %s", imported.Section, imported.Name, err)
+		}
+	}
+
+	return len(entries), nil
+}
+
+// Writes an imported entry, replacing the password and all the details of the existing one if any. The history of the imported entry is only kept for new entries, the existing ones keep theirs.
+func (s *Storage) importEntry(key []byte, imported ImportedEntry) error {
+	encoded, err := s.Transcoder(key, imported.Section, imported.Name).EncodePassword(imported.Password)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.GetEntry(imported.Section, imported.Name)
+	existed := err == nil
+	if err = s.Set(imported.Section, imported.Name, string(encoded)); err != nil {
+		return err
+	}
+
+	entry, err := s.GetEntry(imported.Section, imported.Name)
+	if err != nil {
+		return err
+	}
+
+	updated := *entry
+	if updated.Details, err = s.EncodeDetails(key, imported.Section, imported.Name, &imported.Details); err != nil {
+		return err
+	}
+	if updated.OTP, err = s.EncodeOTP(key, imported.Section, imported.Name, imported.OTP); err != nil {
+		return err
+	}
+	// Without a creation date, the entry existed at least since it was last modified
+	created := imported.Created
+	if created.IsZero() {
+		created = imported.Modified
+	}
+	if !existed && !created.IsZero() {
+		updated.Created = created.UTC()
+	}
+	if !existed && len(imported.History) > 0 {
+		updated.History = nil
+		for _, revision := range imported.History {
+			encoded, err := s.Transcoder(key, imported.Section, imported.Name).EncodePassword(revision.Password)
+			if err != nil {
+				return err
+			}
+			updated.History = append(updated.History, Revision{Password: string(encoded), Modified: revision.Modified.UTC(), Replaced: revision.Replaced.UTC()})
+		}
+		if len(updated.History) > historySize {
+			updated.History = updated.History[:historySize]
+		}
+	}
+	if !imported.Modified.IsZero() {
+		updated.Modified = imported.Modified.UTC()
+	}
+
+	return s.SetEntry(imported.Section, imported.Name, &updated)
+}
+
+// Sets the second factor found in an export, either an otpauth URI or a bare secret. If it can't be read, it is kept as the custom field "otp" instead.
+func setImportedOTP(entry *ImportedEntry, value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+
+	var otp *OTP
+	var err error
+	if strings.HasPrefix(strings.ToLower(value), "otpauth:") {
+		otp, err = ParseOTPURI(value)
+	} else {
+		otp, err = NewOTP(value)
+	}
+	if err != nil {
+		addImportedField(&entry.Details, "otp", value)
+		return
+	}
+	entry.OTP = otp
+}
+
+// Adds a custom field to imported details, the empty values are left out
+func addImportedField(details *EntryDetails, key string, value string) {
+	if key == "" || value == "" {
+		return
+	}
+
+	if details.Fields == nil {
+		details.Fields = make(map[string]string)
+	}
+	unique := key
+	for i := 2; details.Fields[unique] != ""; i++ {
+		unique = fmt.Sprintf("%s %d", key, i)
+	}
+	details.Fields[unique] = value
+}
+
+// Adds a URL to imported details, the empty ones and those already there are left out
+func addImportedURL(details *EntryDetails, url string) {
+	if url = strings.TrimSpace(url); url == "" {
+		return
+	}
+
+	for _, existing := range details.URLs {
+		if existing == url {
+			return
+		}
+	}
+	details.URLs = append(details.URLs, url)
+}
+
+// Reads a CSV export whose first line names the columns. Each row is returned as a map from the column, in lower case, to its value.
+func readImportedCSV(path string) ([]map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid CSV file %s: %s", path, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("The CSV file %s is empty", path)
+	}
+
+	header := records[0]
+	for i, column := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))
+	}
+
+	rows := make([]map[string]string, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := make(map[string]string, len(header))
+		for i, value := range record {
+			if i < len(header) {
+				row[header[i]] = value
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// Returns the first non-empty value of a CSV row among several names of its column, without its surrounding spaces. Passwords and notes must be read with csvRawValue instead.
+func csvValue(row map[string]string, columns ...string) string {
+	for _, column := range columns {
+		if value := strings.TrimSpace(row[column]); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Returns the first non-empty value of a CSV row among several names of its column, as it is: the spaces around a password are part of it
+func csvRawValue(row map[string]string, columns ...string) string {
+	for _, column := range columns {
+		if value := row[column]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,146 @@
+package core
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Reads an export written in a temporary file with the importer of its format
+func readExport(t *testing.T, format string, content string) ([]ImportedEntry, error) {
+	path := filepath.Join(t.TempDir(), "export")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	importer, err := FindImporter(format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return importer.Read(path, "imported")
+}
+
+func TestLastPassImporter(t *testing.T) {
+	secret := otpSecret("12345678901234567890")
+	entries, err := readExport(t, "LastPass", "\ufeffurl,username,password,totp,extra,name,grouping,fav\n"+
+		"https://mail.example.com,me,secret,"+secret+",\"two\nlines\",mail,Perso\\Web,0\n"+
+		"http://sn,,,,a note,note,,0\n"+
+		"https://bank.example.com,me,other,0189,,bank,,0\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Read returned %d entries instead of 3", len(entries))
+	}
+
+	mail := entries[0]
+	if mail.Section != "Perso/Web" || mail.Name != "mail" || mail.Password != "secret" {
+		t.Fatalf("Read returned %+v for the first entry", mail)
+	}
+	expected := EntryDetails{Username: "me", URLs: []string{"https://mail.example.com"}, Notes: "two\nlines"}
+	if !reflect.DeepEqual(mail.Details, expected) {
+		t.Fatalf("Read returned the details %+v instead of %+v", mail.Details, expected)
+	}
+	if mail.OTP == nil || mail.OTP.Secret != secret {
+		t.Fatalf("Read returned the second factor %+v", mail.OTP)
+	}
+
+	// Secure notes have no password nor URL, they are skipped when imported
+	if note := entries[1]; note.Section != "imported" || note.Password != "" || len(note.Details.URLs) != 0 || note.Details.Notes != "a note" {
+		t.Fatalf("Read returned %+v for the secure note", note)
+	}
+
+	// A second factor which can't be read is kept as a field
+	if bank := entries[2]; bank.Section != "imported" || bank.OTP != nil || bank.Details.Fields["otp"] != "0189" {
+		t.Fatalf("Read returned %+v for the invalid second factor", bank)
+	}
+}
+
+// The spaces around a password are part of it, while those around the names, usernames and URLs are left out
+func TestCSVImporterSpaces(t *testing.T) {
+	tests := []struct {
+		format  string
+		content string
+	}{
+		{"lastpass", "url,username,password,extra,name,grouping\n https://example.com , me ,  two spaces ,  indented note, mail ,\n"},
+		{"1password", "title,url,username,password,notes\n mail , https://example.com , me ,  two spaces ,  indented note\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			entries, err := readExport(t, test.format, test.content)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := ImportedEntry{Section: "imported", Name: "mail", Password: "  two spaces ", Details: EntryDetails{Username: "me", URLs: []string{"https://example.com"}, Notes: "  indented note"}}
+			if len(entries) != 1 || !reflect.DeepEqual(entries[0], expected) {
+				t.Fatalf("Read returned %+v instead of %+v", entries, expected)
+			}
+		})
+	}
+}
+
+func TestLastPassImporterInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"empty", ""},
+		{"unterminated quote", "url,username,password\n\"https://example.com,me,secret\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := readExport(t, "lastpass", test.content); err == nil {
+				t.Fatal("Read accepted an invalid export")
+			}
+		})
+	}
+}
+
+func TestBitwardenImporter(t *testing.T) {
+	entries, err := readExport(t, "bitwarden", `{
+		"encrypted": false,
+		"folders": [{"id": "f1", "name": "Web"}],
+		"items": [
+			{"name": "mail", "folderId": "f1", "notes": "hello", "creationDate": "2020-01-02T03:04:05Z", "revisionDate": "2021-01-02T03:04:05Z",
+			 "login": {"username": "me", "password": "secret", "uris": [{"uri": "https://a.example.com"}, {"uri": "https://a.example.com"}, {"uri": ""}]},
+			 "fields": [{"name": "pin", "value": "1234"}, {"name": "pin", "value": "5678"}, {"name": "empty", "value": ""}]},
+			{"name": "card", "folderId": null}
+		]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Read returned %d entries instead of 2", len(entries))
+	}
+
+	mail := entries[0]
+	if mail.Section != "Web" || mail.Name != "mail" || mail.Password != "secret" || mail.Created.Year() != 2020 || mail.Modified.Year() != 2021 {
+		t.Fatalf("Read returned %+v for the login", mail)
+	}
+	expected := EntryDetails{Username: "me", URLs: []string{"https://a.example.com"}, Notes: "hello", Fields: map[string]string{"pin": "1234", "pin 2": "5678"}}
+	if !reflect.DeepEqual(mail.Details, expected) {
+		t.Fatalf("Read returned the details %+v instead of %+v", mail.Details, expected)
+	}
+
+	if card := entries[1]; card.Section != "imported" || card.Password != "" {
+		t.Fatalf("Read returned %+v for the card", card)
+	}
+}
+
+func TestBitwardenImporterEncrypted(t *testing.T) {
+	if _, err := readExport(t, "bitwarden", `{"encrypted": true, "items": []}`); err == nil || !strings.Contains(err.Error(), "encrypted") {
+		t.Fatalf("Read returned %v for an encrypted export", err)
+	}
+}
+
+func TestFindImporterUnknown(t *testing.T) {
+	if _, err := FindImporter("unknown"); err == nil || !strings.Contains(err.Error(), "lastpass") {
+		t.Fatalf("FindImporter returned %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Label used to derive the MAC key from the secret key, so that the same key is never used for two purposes.
+const macLabel = "mpm storage integrity"
+
+// TamperError is raised when the content of the storage does not match its MAC: the file has been modified outside of mpm.
+type TamperError struct {
+	Reason string
+}
+
+func (e *TamperError) Error() string {
+	return fmt.Sprintf("The vault has been tampered with: %s", e.Reason)
+}
+
+// Sealed tells whether the storage is protected by a MAC. Only storages written by versions older than the MAC are not.
+func (s *Storage) Sealed() bool {
+	return s.MAC != ""
+}
+
+// Seal computes the MAC of the storage with the secret key. It must be called after any modification, before dumping the storage on the disk. Storages still using the legacy key derivation can't be sealed, see Verify.
+func (s *Storage) Seal(key []byte) error {
+	if s.KDF == nil {
+		return errors.New("The storage must use a key derivation function before it is sealed")
+	}
+
+	mac, err := s.computeMAC(key)
+	if err != nil {
+		return err
+	}
+
+	s.MAC = base64.StdEncoding.EncodeToString(mac)
+	return nil
+}
+
+// Verify checks the MAC of the storage with the secret key, without decrypting any password. Storages which have not been sealed yet are accepted, as long as they still use the legacy key derivation: they must be migrated to a key derivation function before they are sealed (see SetKDF), so that a sealed storage can't pass for one which never was.
+func (s *Storage) Verify(key []byte) error {
+	if err := s.checkSealed(); err != nil || !s.Sealed() {
+		return err
+	}
+
+	expected, err := base64.StdEncoding.DecodeString(s.MAC)
+	if err != nil {
+		return &TamperError{"the MAC is not valid base64"}
+	}
+
+	mac, err := s.computeMAC(key)
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal(mac, expected) {
+		return &TamperError{"its content does not match its MAC"}
+	}
+
+	return nil
+}
+
+// checkSealed verifies what can be verified without the key: storages created or migrated since the MAC exists must carry one, otherwise it has been stripped.
+func (s *Storage) checkSealed() error {
+	if s.KDF != nil && !s.Sealed() {
+		return &TamperError{"its MAC is missing"}
+	}
+
+	return nil
+}
+
+// computeMAC computes the HMAC-SHA256 of the serialized storage, its own MAC excluded.
+func (s *Storage) computeMAC(key []byte) ([]byte, error) {
+	unsealed := *s
+	unsealed.MAC = ""
+
+	data, err := json.Marshal(&unsealed)
+	if err != nil {
+		return nil, err
+	}
+
+	derive := hmac.New(sha256.New, key)
+	derive.Write([]byte(macLabel))
+
+	mac := hmac.New(sha256.New, derive.Sum(nil))
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSealVerify(t *testing.T) {
+	vault := newTestVault(t, "passphrase")
+	vault.put(t, "mail", "secret", "", time.Now(), time.Now())
+	vault.seal(t)
+
+	if err := vault.storage.Verify(vault.key); err != nil {
+		t.Fatalf("Verify rejected a sealed storage: %v", err)
+	}
+
+	other := newTestVault(t, "passphrase")
+	if err := vault.storage.Verify(other.key); err == nil {
+		t.Fatal("Verify accepted another key")
+	} else if _, ok := err.(*TamperError); !ok {
+		t.Fatalf("Verify returned %v for another key", err)
+	}
+}
+
+// Any modification made outside of mpm is detected, even to parts which are not encrypted
+func TestVerifyTampered(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(s *Storage)
+	}{
+		{"password", func(s *Storage) { s.Sections["web"]["mail"].Password = s.Sections["web"]["bank"].Password }},
+		{"dates", func(s *Storage) { s.Sections["web"]["mail"].Modified = time.Time{} }},
+		{"removed entry", func(s *Storage) { delete(s.Sections["web"], "bank") }},
+		{"tombstone", func(s *Storage) { s.Deleted = map[string]map[string]time.Time{"web": {"mail": time.Now()}} }},
+		{"passphrase hash", func(s *Storage) { s.Passphrase = "" }},
+		{"KDF", func(s *Storage) { s.KDF.Time++ }},
+		{"MAC", func(s *Storage) { s.MAC = "not base64" }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vault := newTestVault(t, "passphrase")
+			vault.put(t, "mail", "secret", "", time.Now(), time.Now())
+			vault.put(t, "bank", "other", "", time.Now(), time.Now())
+			vault = vault.clone(t)
+
+			test.tamper(vault.storage)
+			if err := vault.storage.Verify(vault.key); err == nil {
+				t.Fatal("Verify accepted a modified storage")
+			} else if _, ok := err.(*TamperError); !ok {
+				t.Fatalf("Verify returned %v", err)
+			}
+		})
+	}
+}
+
+// A sealed storage can't pass for one which never was: only storages still using the legacy key derivation may lack a MAC
+func TestStrippedMAC(t *testing.T) {
+	vault := newTestVault(t, "passphrase")
+	vault.put(t, "mail", "secret", "", time.Now(), time.Now())
+	vault.seal(t)
+	vault.storage.MAC = ""
+
+	data, err := json.Marshal(vault.storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = parseStorage(data); err == nil {
+		t.Fatal("parseStorage accepted a storage without its MAC")
+	} else if _, ok := err.(*TamperError); !ok {
+		t.Fatalf("parseStorage returned %v", err)
+	}
+
+	// Stripping the KDF as well doesn't help: the storage can't be sealed again, and its passwords don't decrypt with the legacy key
+	vault.storage.KDF = nil
+	if err = vault.storage.Seal(vault.key); err == nil {
+		t.Fatal("Seal accepted a storage using the legacy key derivation")
+	}
+	legacyKey, err := vault.storage.DeriveKey("passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = vault.storage.Transcoder(legacyKey, "web", "mail").DecodePassword(vault.storage.Sections["web"]["mail"].Password); err == nil {
+		t.Fatal("DecodePassword accepted the legacy key")
+	}
+}
@@ -0,0 +1,190 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Supported key derivation functions
+const (
+	KDFArgon2id = "argon2id"
+	KDFScrypt   = "scrypt"
+)
+
+// Size of the derived keys (AES-256) and of the random salts
+const (
+	keySize  = 32
+	saltSize = 16
+)
+
+// KDF holds the key derivation function and its parameters. It is stored in the header of the storage, so that the secret key can be derived again from the passphrase.
+type KDF struct {
+	// Either KDFArgon2id or KDFScrypt
+	Algorithm string `json:"Algo"`
+	// Random salt of the storage, encoded in base64
+	Salt string `json:"Salt"`
+	// Argon2id parameters: number of passes, memory in KiB and parallelism
+	Time    uint32 `json:"Time,omitempty"`
+	Memory  uint32 `json:"Memory,omitempty"`
+	Threads uint8  `json:"Threads,omitempty"`
+	// scrypt parameters: CPU/memory cost, block size and parallelism
+	N int `json:"N,omitempty"`
+	R int `json:"R,omitempty"`
+	P int `json:"P,omitempty"`
+}
+
+// NewKDF creates a KDF with a fresh random salt and the default parameters of the algorithm.
+func NewKDF(algorithm string) (*KDF, error) {
+	var kdf *KDF
+	switch algorithm {
+	case KDFArgon2id:
+		kdf = &KDF{Algorithm: KDFArgon2id, Time: 3, Memory: 64 * 1024, Threads: defaultThreads()}
+	case KDFScrypt:
+		kdf = &KDF{Algorithm: KDFScrypt, N: 1 << 15, R: 8, P: 1}
+	default:
+		return nil, fmt.Errorf("Unknown key derivation function %s", algorithm)
+	}
+
+	if err := kdf.Resalt(); err != nil {
+		return nil, err
+	}
+	return kdf, nil
+}
+
+// Argon2id's parallelism is capped to 4, so that a storage created on a large machine is still usable on a small one.
+func defaultThreads() uint8 {
+	if n := runtime.NumCPU(); n < 4 {
+		return uint8(n)
+	}
+	return 4
+}
+
+// Resalt replaces the salt with a new random one.
+func (k *KDF) Resalt() error {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return err
+	}
+
+	k.Salt = base64.StdEncoding.EncodeToString(salt)
+	return nil
+}
+
+// DeriveKey derives the secret key from the passphrase. A nil KDF is the legacy derivation, a single SHA512/256 hash of the passphrase. Parameters out of the bounds of Check are refused.
+func (k *KDF) DeriveKey(passphrase string) ([]byte, error) {
+	if k == nil {
+		key := sha512.Sum512_256([]byte(passphrase))
+		return key[:], nil
+	}
+	if err := k.Check(); err != nil {
+		return nil, err
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(k.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	switch k.Algorithm {
+	case KDFArgon2id:
+		if k.Time == 0 || k.Memory == 0 || k.Threads == 0 {
+			return nil, fmt.Errorf("Invalid argon2id parameters t=%d m=%d p=%d", k.Time, k.Memory, k.Threads)
+		}
+		return argon2.IDKey([]byte(passphrase), salt, k.Time, k.Memory, k.Threads, keySize), nil
+	case KDFScrypt:
+		return scrypt.Key([]byte(passphrase), salt, k.N, k.R, k.P, keySize)
+	default:
+		return nil, fmt.Errorf("Unknown key derivation function %s", k.Algorithm)
+	}
+}
+
+// String displays the algorithm and its parameters.
+func (k *KDF) String() string {
+	switch {
+	case k == nil:
+		return "sha512/256 (legacy)"
+	case k.Algorithm == KDFArgon2id:
+		return fmt.Sprintf("argon2id (time=%d, memory=%dMiB, threads=%d)", k.Time, k.Memory/1024, k.Threads)
+	case k.Algorithm == KDFScrypt:
+		return fmt.Sprintf("scrypt (N=%d, r=%d, p=%d)", k.N, k.R, k.P)
+	default:
+		return k.Algorithm
+	}
+}
+
+// Bounds of the parameters explored by TuneKDF
+const (
+	minArgonMemory = 16 * 1024
+	maxArgonTime   = 64
+	maxScryptN     = 1 << 22
+)
+
+// Bounds of the parameters accepted from a file, see Check. The memory is in bytes, it covers what TuneKDF can choose with scrypt.
+const (
+	maxKDFMemory    = 4 << 30
+	maxArgonThreads = 64
+	maxScryptR      = 32
+	maxScryptP      = 16
+)
+
+// Check asserts the parameters are within the bounds mpm can choose, so that a crafted header can't make the derivation exhaust the memory or run for ever. A nil KDF is the legacy derivation, which has none.
+func (k *KDF) Check() error {
+	if k == nil {
+		return nil
+	}
+
+	switch k.Algorithm {
+	case KDFArgon2id:
+		if k.Time > maxArgonTime || uint64(k.Memory)*1024 > maxKDFMemory || k.Threads > maxArgonThreads {
+			return fmt.Errorf("The argon2id parameters t=%d m=%d p=%d exceed what mpm accepts (t=%d m=%d p=%d)", k.Time, k.Memory, k.Threads, maxArgonTime, maxKDFMemory/1024, maxArgonThreads)
+		}
+	case KDFScrypt:
+		if k.N > maxScryptN || k.R > maxScryptR || k.P > maxScryptP || uint64(k.N)*uint64(k.R)*128 > maxKDFMemory {
+			return fmt.Errorf("The scrypt parameters N=%d r=%d p=%d exceed what mpm accepts (N=%d r=%d p=%d, %dMiB)", k.N, k.R, k.P, maxScryptN, maxScryptR, maxScryptP, maxKDFMemory>>20)
+		}
+	}
+	return nil
+}
+
+// TuneKDF benchmarks the algorithm on the current machine, and returns the strongest parameters whose derivation takes at least the target duration (or the largest explored if the target can't be reached), along with the measured duration.
+func TuneKDF(algorithm string, target time.Duration) (*KDF, time.Duration, error) {
+	kdf, err := NewKDF(algorithm)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	measure := func() (time.Duration, error) {
+		start := time.Now()
+		_, err := kdf.DeriveKey("mpm benchmark")
+		return time.Since(start), err
+	}
+
+	switch algorithm {
+	case KDFArgon2id:
+		// Start with a single pass, lower the memory if even that is too slow for the target
+		kdf.Time = 1
+		elapsed, err := measure()
+		for ; err == nil && elapsed > target && kdf.Memory/2 >= minArgonMemory; elapsed, err = measure() {
+			kdf.Memory /= 2
+		}
+		for ; err == nil && elapsed < target && kdf.Time < maxArgonTime; elapsed, err = measure() {
+			kdf.Time++
+		}
+		return kdf, elapsed, err
+	default:
+		kdf.N = 1 << 14
+		elapsed, err := measure()
+		for ; err == nil && elapsed < target && kdf.N < maxScryptN; elapsed, err = measure() {
+			kdf.N <<= 1
+		}
+		return kdf, elapsed, err
+	}
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Lock is an advisory lock on the master file, held by the process modifying it. Only mpm processes honour it.
+type Lock struct {
+	file *os.File
+}
+
+// LockedError is raised when the master file is already locked by another process.
+type LockedError struct {
+	Path string
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("Your storage is being modified by another mpm process (lock file %s)", e.Path)
+}
+
+// lockName returns the path of the lock file. The master file itself can't be locked, as it is replaced on each write.
+func lockName() string {
+	return fileName + ".lock"
+}
+
+// LockStorage acquires the lock on the master file, without waiting, creating its directory if needed. If another process holds it, a *LockedError is raised. The lock is released by Unlock, or when the process exits.
+func LockStorage() (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(fileName), 0700); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(lockName(), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = lockFile(file); err != nil {
+		file.Close()
+		if err == errWouldBlock {
+			return nil, &LockedError{lockName()}
+		}
+		return nil, err
+	}
+
+	return &Lock{file}, nil
+}
+
+// Unlock releases the lock. The lock file is left in place, removing it would let two processes lock two different files.
+func (l *Lock) Unlock() error {
+	if err := unlockFile(l.file); err != nil {
+		l.file.Close()
+		return err
+	}
+
+	return l.file.Close()
+}
+
+// ConflictError is raised when the master file changed between the moment it was read and the moment it is written, by a process which did not take the lock.
+type ConflictError struct {
+	Path string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s has been modified by another process since it was read", e.Path)
+}
+
+// fileVersion identifies the content of a file by its SHA-256 hash. A missing file has an empty version.
+func fileVersion(file string) (string, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return dataVersion(data), nil
+}
+
+// dataVersion identifies the content of a file by its SHA-256 hash.
+func dataVersion(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,19 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+
+package core
+
+import (
+	"errors"
+	"os"
+)
+
+// There is no advisory locking on these platforms, only the version check of DumpOnDisk protects the master file.
+var errWouldBlock = errors.New("would block")
+
+func lockFile(file *os.File) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
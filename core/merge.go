@@ -0,0 +1,342 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// MergeReport tells what merging another version of the storage changed in this one, see Merge.
+type MergeReport struct {
+	// Entries added, modified or removed on the other side only, whose change was applied
+	Added   []EntryRef
+	Updated []EntryRef
+	Deleted []EntryRef
+	// Entries changed differently on both sides, and how they were solved
+	Conflicts []MergeConflict
+}
+
+// Changes returns the number of entries changed by the merge, conflicts included.
+func (r *MergeReport) Changes() int {
+	return len(r.Added) + len(r.Updated) + len(r.Deleted) + len(r.Conflicts)
+}
+
+// MergeConflict is an entry modified differently on both sides of a merge, or modified on one side and removed on the other.
+type MergeConflict struct {
+	EntryRef
+	// When each version was last modified, or removed. Zero if the date of a removal is unknown.
+	Ours   time.Time
+	Theirs time.Time
+	// Whether each side removed the entry
+	OursRemoved   bool
+	TheirsRemoved bool
+	// Whether both versions have the same password, if none was removed
+	SamePassword bool
+	// Whether the version of the other side was kept, once solved
+	TookTheirs bool
+}
+
+// MergeResolver solves a conflict of a merge, it returns true to keep the version of the other side.
+type MergeResolver func(conflict *MergeConflict) (bool, error)
+
+// MergeStrategy solves all the conflicts of a merge the same way, see Resolve.
+type MergeStrategy string
+
+// Strategies of a merge: the most recent version of an entry, or always the one of a side
+const (
+	MergeNewest MergeStrategy = "newest"
+	MergeOurs   MergeStrategy = "ours"
+	MergeTheirs MergeStrategy = "theirs"
+)
+
+// Resolve solves a conflict with the strategy, it can be given to Merge. Newest keeps ours if both were changed at the same time.
+func (m MergeStrategy) Resolve(conflict *MergeConflict) (bool, error) {
+	switch m {
+	case MergeNewest:
+		return conflict.Theirs.After(conflict.Ours), nil
+	case MergeOurs:
+		return false, nil
+	case MergeTheirs:
+		return true, nil
+	}
+	return false, fmt.Errorf("Unknown merge strategy %s, expected %s, %s or %s", m, MergeNewest, MergeOurs, MergeTheirs)
+}
+
+// MergeKeyError is raised when the other version of the storage can't be read with the secret key given for it.
+type MergeKeyError struct{}
+
+func (e *MergeKeyError) Error() string {
+	return "The other version of your storage has another passphrase, or has been tampered with"
+}
+
+// A version of an entry in a merge, the entry being nil if it was removed (with a tombstone) or never existed
+type mergeVersion struct {
+	entry   *Entry
+	removed time.Time
+	// Decrypted content of the entry, which compares versions encrypted with different keys, and its current and previous passwords
+	content   string
+	passwords map[string]bool
+	password  string
+}
+
+// Merge merges another version of the storage into this one, entry by entry. The entries are compared once decrypted, so both versions can have different passphrases: theirKey is the secret key of the other one, the entries taken from it are encrypted again with key.
+// With their common ancestor (as recorded by the synchronization, nil if unknown), an entry changed on one side only takes that change. Without it, the modification dates and the tombstones of the removed entries tell which side changed: a version more recent than the other one, whose password replaced the one of the other version (kept in its history), is an update of it, and a removal more recent than the last modification of the entry on the other side removes it.
+// The other entries changed on both sides are conflicts, solved by resolve. When both versions still exist, the one kept is dated from the merge, and the password of the other one is pushed to its history. The settings of this storage (passphrase, names, ...) are kept, it must be sealed again afterwards.
+func (s *Storage) Merge(key []byte, base *Storage, theirs *Storage, theirKey []byte, resolve MergeResolver) (*MergeReport, error) {
+	if err := theirs.Verify(theirKey); err != nil {
+		return nil, &MergeKeyError{}
+	}
+
+	ours, ourSections, err := s.mergeVersions(key, key)
+	if err != nil {
+		return nil, err
+	}
+	other, otherSections, err := theirs.mergeVersions(theirKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// The ancestor can't be used if it can be read with neither key, as after a change of passphrase
+	var ancestor map[EntryRef]*mergeVersion
+	ancestorSections := make(map[string]bool)
+	if base != nil {
+		for _, baseKey := range [][]byte{key, theirKey} {
+			if base.Verify(baseKey) != nil {
+				continue
+			}
+			if versions, sections, err := base.mergeVersions(baseKey, key); err == nil {
+				ancestor, ancestorSections = versions, sections
+				break
+			}
+		}
+	}
+
+	refs := make(map[EntryRef]bool)
+	for _, versions := range []map[EntryRef]*mergeVersion{ancestor, ours, other} {
+		for ref := range versions {
+			refs[ref] = true
+		}
+	}
+	sorted := make([]EntryRef, 0, len(refs))
+	for ref := range refs {
+		sorted = append(sorted, ref)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return refLess(sorted[i], sorted[j]) })
+
+	report := &MergeReport{}
+	merged := make(map[string]map[string]*Entry)
+	tombstones := make(map[string]map[string]time.Time)
+	for _, ref := range sorted {
+		o, t, b := versionOf(ours, ref), versionOf(other, ref), versionOf(ancestor, ref)
+
+		// The version kept, and whether it is the one of the other side
+		kept, took, conflicted := o, false, false
+		switch {
+		case o.same(t):
+		case ancestor != nil && b.same(t):
+		case ancestor != nil && b.same(o):
+			kept, took = t, true
+		case ancestor == nil && o.entry == nil && t.entry != nil && o.removed.IsZero():
+			kept, took = t, true
+		case ancestor == nil && t.entry == nil && o.entry != nil && t.removed.IsZero():
+		case ancestor == nil && o.entry == nil && t.entry != nil && !t.entry.Modified.After(o.removed):
+		case ancestor == nil && t.entry == nil && o.entry != nil && !o.entry.Modified.After(t.removed):
+			kept, took = t, true
+		case ancestor == nil && o.entry != nil && t.entry != nil && t.updates(o):
+			kept, took = t, true
+		case ancestor == nil && o.entry != nil && t.entry != nil && o.updates(t):
+		default:
+			conflict := MergeConflict{EntryRef: ref, Ours: o.date(), Theirs: t.date(), OursRemoved: o.entry == nil, TheirsRemoved: t.entry == nil}
+			conflict.SamePassword = o.entry != nil && t.entry != nil && o.password == t.password
+			if conflict.TookTheirs, err = resolve(&conflict); err != nil {
+				return nil, err
+			}
+			report.Conflicts = append(report.Conflicts, conflict)
+			conflicted = true
+
+			kept, took = o, conflict.TookTheirs
+			if took {
+				kept = t
+			}
+			if o.entry != nil && t.entry != nil {
+				loser := t
+				if took {
+					loser = o
+				}
+				kept = kept.mergedWith(loser)
+			}
+		}
+
+		if took && !conflicted {
+			switch {
+			case t.entry == nil && o.entry != nil:
+				report.Deleted = append(report.Deleted, ref)
+			case o.entry == nil && t.entry != nil:
+				report.Added = append(report.Added, ref)
+			case t.entry != nil:
+				report.Updated = append(report.Updated, ref)
+			}
+		}
+
+		if kept.entry != nil {
+			if merged[ref.Section] == nil {
+				merged[ref.Section] = make(map[string]*Entry)
+			}
+			merged[ref.Section][ref.Name] = kept.entry
+			continue
+		}
+
+		// A removed entry keeps its most recent tombstone, the date of the merge if neither side has one
+		removed := o.removed
+		if t.removed.After(removed) {
+			removed = t.removed
+		}
+		if removed.IsZero() && (o.entry != nil || t.entry != nil) {
+			removed = time.Now().UTC()
+		}
+		if !removed.IsZero() {
+			if tombstones[ref.Section] == nil {
+				tombstones[ref.Section] = make(map[string]time.Time)
+			}
+			tombstones[ref.Section][ref.Name] = removed
+		}
+	}
+
+	// Empty sections are merged the same way, the ones holding entries are always kept
+	for _, sections := range []map[string]bool{ourSections, otherSections} {
+		for section := range sections {
+			inOurs, inTheirs, inBase := ourSections[section], otherSections[section], ancestorSections[section]
+			if _, ok := merged[section]; !ok && ((inOurs && (inTheirs || !inBase)) || (inTheirs && !inBase)) {
+				merged[section] = make(map[string]*Entry)
+			}
+		}
+	}
+
+	if err = s.encodeLayout(key, merged); err != nil {
+		return nil, err
+	}
+	if err = s.encodeTombstones(key, tombstones); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// MergeImported merges entries read from an archive (see ReadArchive) into the storage, as Merge does with another version of the storage whose ancestor is unknown: the entries are compared once decrypted, with their details, second factor and history. The entries without section or password are left out.
+func (s *Storage) MergeImported(key []byte, entries []ImportedEntry, resolve MergeResolver) (*MergeReport, error) {
+	// The entries are written to a storage of their own, sealed with the same key so that it can be merged. It only needs a key derivation to be sealed, the key is never derived.
+	theirs := &Storage{Sections: make(map[string]map[string]*Entry), KDF: &KDF{}}
+	for _, entry := range entries {
+		if entry.Section == "" || entry.Password == "" {
+			continue
+		}
+		if err := theirs.importEntry(key, entry); err != nil {
+			return nil, fmt.Errorf("Impossible to read %s/%s: %s", entry.Section, entry.Name, err)
+		}
+	}
+	if err := theirs.Seal(key); err != nil {
+		return nil, err
+	}
+
+	return s.Merge(key, nil, theirs, key, resolve)
+}
+
+// Reads the versions of the entries of a storage and its tombstones, along with its sections. The entries are encrypted again with the key of the merged storage if it is another one.
+func (s *Storage) mergeVersions(key []byte, mergedKey []byte) (map[EntryRef]*mergeVersion, map[string]bool, error) {
+	layout, err := s.decodeLayout(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	tombstones, err := s.decodeTombstones(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	versions := make(map[EntryRef]*mergeVersion)
+	sections := make(map[string]bool)
+	for section, sec := range tombstones {
+		for name, removed := range sec {
+			versions[EntryRef{section, name}] = &mergeVersion{removed: removed}
+		}
+	}
+
+	for section, sec := range layout {
+		sections[section] = true
+		for name, entry := range sec {
+			exported, err := s.exportEntry(key, section, name, entry)
+			if err != nil {
+				return nil, nil, err
+			}
+			content, err := json.Marshal(exported)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if !bytes.Equal(key, mergedKey) {
+				if entry, err = s.reencrypt(entry, key, section, name, mergedKey, section, name); err != nil {
+					return nil, nil, err
+				}
+			}
+
+			version := &mergeVersion{entry: entry, content: string(content), password: exported.Password, passwords: map[string]bool{exported.Password: true}}
+			for _, revision := range exported.History {
+				version.passwords[revision.Password] = true
+			}
+			versions[EntryRef{section, name}] = version
+		}
+	}
+
+	return versions, sections, nil
+}
+
+// Returns the version of an entry, an empty one if it doesn't exist
+func versionOf(versions map[EntryRef]*mergeVersion, ref EntryRef) *mergeVersion {
+	if version, ok := versions[ref]; ok {
+		return version
+	}
+	return &mergeVersion{}
+}
+
+// Tells whether two versions have the same content, or both don't exist
+func (v *mergeVersion) same(other *mergeVersion) bool {
+	if v.entry == nil || other.entry == nil {
+		return v.entry == other.entry
+	}
+	return v.content == other.content
+}
+
+// Tells whether a version is an update of another one: more recent, of the same entry, with another password which replaced the other one (still in its history). With the same password, nothing tells which side changed the details.
+func (v *mergeVersion) updates(other *mergeVersion) bool {
+	return v.entry.Modified.After(other.entry.Modified) && v.entry.Created.Equal(other.entry.Created) && v.password != other.password && v.passwords[other.password]
+}
+
+// When the version was last modified, or removed
+func (v *mergeVersion) date() time.Time {
+	if v.entry != nil {
+		return v.entry.Modified
+	}
+	return v.removed
+}
+
+// Returns the version solving a conflict with another one: it is modified by the merge, so that it is an update of both, and the password of the other one is pushed to its history if it differs
+func (v *mergeVersion) mergedWith(other *mergeVersion) *mergeVersion {
+	now := time.Now().UTC()
+	copied := *v.entry
+	copied.Modified = now
+	copied.History = append([]Revision(nil), v.entry.History...)
+
+	passwords := make(map[string]bool)
+	for password := range v.passwords {
+		passwords[password] = true
+	}
+	if other.password != v.password {
+		copied.History = append([]Revision{{Password: other.entry.Password, Modified: other.entry.Modified, Replaced: now}}, copied.History...)
+		if len(copied.History) > historySize {
+			copied.History = copied.History[:historySize]
+		}
+		passwords[other.password] = true
+	}
+
+	return &mergeVersion{entry: &copied, password: v.password, passwords: passwords}
+}
@@ -0,0 +1,315 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMerge(t *testing.T) {
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	before, after := created.Add(time.Hour), created.Add(2*time.Hour)
+
+	tests := []struct {
+		name string
+		// Builds the common ancestor, then changes both sides. Without ancestor, the sides start from the same empty storage.
+		ancestor func(t *testing.T, v *testVault)
+		changes  func(t *testing.T, ours *testVault, theirs *testVault)
+		strategy MergeStrategy
+		// Passwords expected after the merge, empty for a removed entry, and the history expected of some of them
+		expected map[string]string
+		history  map[string][]string
+		// Number of entries in each part of the report
+		added, updated, deleted, conflicts int
+		// Tombstone expected after the merge
+		tombstones []string
+	}{
+		{
+			name:     "edited on their side only",
+			ancestor: func(t *testing.T, v *testVault) { v.put(t, "mail", "old", "", created, created) },
+			changes: func(t *testing.T, ours *testVault, theirs *testVault) {
+				theirs.put(t, "mail", "new", "", created, after, "old")
+				theirs.put(t, "bank", "added", "", after, after)
+			},
+			strategy: MergeOurs,
+			expected: map[string]string{"mail": "new", "bank": "added"},
+			added:    1, updated: 1,
+		},
+		{
+			name:     "edited on both sides, newest wins",
+			ancestor: func(t *testing.T, v *testVault) { v.put(t, "mail", "old", "", created, created) },
+			changes: func(t *testing.T, ours *testVault, theirs *testVault) {
+				ours.put(t, "mail", "ours", "", created, before, "old")
+				theirs.put(t, "mail", "theirs", "", created, after, "old")
+			},
+			strategy:  MergeNewest,
+			expected:  map[string]string{"mail": "theirs"},
+			history:   map[string][]string{"mail": {"ours", "old"}},
+			conflicts: 1,
+		},
+		{
+			name:     "edited on both sides, ours kept",
+			ancestor: func(t *testing.T, v *testVault) { v.put(t, "mail", "old", "", created, created) },
+			changes: func(t *testing.T, ours *testVault, theirs *testVault) {
+				ours.put(t, "mail", "ours", "", created, before, "old")
+				theirs.put(t, "mail", "theirs", "", created, after, "old")
+			},
+			strategy:  MergeOurs,
+			expected:  map[string]string{"mail": "ours"},
+			history:   map[string][]string{"mail": {"theirs", "old"}},
+			conflicts: 1,
+		},
+		{
+			name:     "edited on both sides the same way",
+			ancestor: func(t *testing.T, v *testVault) { v.put(t, "mail", "old", "", created, created) },
+			changes: func(t *testing.T, ours *testVault, theirs *testVault) {
+				ours.put(t, "mail", "new", "", created, after, "old")
+				theirs.put(t, "mail", "new", "", created, after, "old")
+			},
+			strategy: MergeTheirs,
+			expected: map[string]string{"mail": "new"},
+		},
+		{
+			name: "updated on their side, without ancestor",
+			changes: func(t *testing.T, ours *testVault, theirs *testVault) {
+				ours.put(t, "mail", "old", "", created, before)
+				theirs.put(t, "mail", "new", "", created, after, "old")
+			},
+			strategy: MergeOurs,
+			expected: map[string]string{"mail": "new"},
+			updated:  1,
+		},
+		{
+			name: "same password with other details, without ancestor",
+			changes: func(t *testing.T, ours *testVault, theirs *testVault) {
+				ours.put(t, "mail", "same", "our notes", created, before)
+				theirs.put(t, "mail", "same", "their notes", created, after)
+			},
+			strategy:  MergeOurs,
+			expected:  map[string]string{"mail": "same"},
+			conflicts: 1,
+		},
+		{
+			name: "edited on our side, removed later on theirs",
+			changes: func(t *testing.T, ours *testVault, theirs *testVault) {
+				ours.put(t, "mail", "ours", "", created, before)
+				theirs.put(t, "mail", "ours", "", created, before)
+				theirs.remove(t, "mail", after)
+			},
+			strategy:   MergeOurs,
+			expected:   map[string]string{"mail": ""},
+			deleted:    1,
+			tombstones: []string{"mail"},
+		},
+		{
+			name: "removed on our side, edited later on theirs",
+			changes: func(t *testing.T, ours *testVault, theirs *testVault) {
+				ours.put(t, "mail", "old", "", created, created)
+				ours.remove(t, "mail", before)
+				theirs.put(t, "mail", "new", "", created, after, "old")
+			},
+			strategy:  MergeNewest,
+			expected:  map[string]string{"mail": "new"},
+			conflicts: 1,
+		},
+		{
+			name: "removed on our side, edited later on theirs, ours kept",
+			changes: func(t *testing.T, ours *testVault, theirs *testVault) {
+				ours.put(t, "mail", "old", "", created, created)
+				ours.remove(t, "mail", before)
+				theirs.put(t, "mail", "new", "", created, after, "old")
+			},
+			strategy:   MergeOurs,
+			expected:   map[string]string{"mail": ""},
+			conflicts:  1,
+			tombstones: []string{"mail"},
+		},
+		{
+			name:     "removed on their side with ancestor",
+			ancestor: func(t *testing.T, v *testVault) { v.put(t, "mail", "old", "", created, created) },
+			changes: func(t *testing.T, ours *testVault, theirs *testVault) {
+				theirs.remove(t, "mail", after)
+			},
+			strategy:   MergeOurs,
+			expected:   map[string]string{"mail": ""},
+			deleted:    1,
+			tombstones: []string{"mail"},
+		},
+		{
+			name:     "removed without date on their side, with ancestor",
+			ancestor: func(t *testing.T, v *testVault) { v.put(t, "mail", "old", "", created, created) },
+			changes: func(t *testing.T, ours *testVault, theirs *testVault) {
+				theirs.remove(t, "mail", time.Time{})
+			},
+			strategy:   MergeOurs,
+			expected:   map[string]string{"mail": ""},
+			deleted:    1,
+			tombstones: []string{"mail"},
+		},
+		{
+			name: "removed without date, without ancestor",
+			changes: func(t *testing.T, ours *testVault, theirs *testVault) {
+				ours.put(t, "mail", "ours", "", created, before)
+				theirs.put(t, "mail", "ours", "", created, before)
+				theirs.remove(t, "mail", time.Time{})
+				theirs.put(t, "bank", "theirs", "", created, before)
+			},
+			strategy: MergeTheirs,
+			expected: map[string]string{"mail": "ours", "bank": "theirs"},
+			added:    1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ours := newTestVault(t, "passphrase")
+			var base *Storage
+			if test.ancestor != nil {
+				test.ancestor(t, ours)
+				base = ours.clone(t).storage
+			}
+			theirs := ours.clone(t)
+
+			test.changes(t, ours, theirs)
+			theirs.seal(t)
+
+			report, err := ours.storage.Merge(ours.key, base, theirs.storage, theirs.key, test.strategy.Resolve)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(report.Added) != test.added || len(report.Updated) != test.updated || len(report.Deleted) != test.deleted || len(report.Conflicts) != test.conflicts {
+				t.Errorf("got %d added, %d updated, %d deleted and %d conflicts, expected %d, %d, %d and %d", len(report.Added), len(report.Updated), len(report.Deleted), len(report.Conflicts), test.added, test.updated, test.deleted, test.conflicts)
+			}
+			for name, expected := range test.expected {
+				if password, _ := ours.password(t, name); password != expected {
+					t.Errorf("%s: got %q, expected %q", name, password, expected)
+				}
+			}
+			for name, expected := range test.history {
+				if _, history := ours.password(t, name); len(history) < len(expected) || history[0] != expected[0] {
+					t.Errorf("%s: got the history %q, expected %q", name, history, expected)
+				}
+			}
+			for _, name := range test.tombstones {
+				if _, ok := ours.storage.Deleted["web"][name]; !ok {
+					t.Errorf("%s: no tombstone", name)
+				}
+			}
+
+			// Merging again changes nothing
+			ours.seal(t)
+			again, err := ours.clone(t).storage.Merge(ours.key, nil, ours.clone(t).storage, ours.key, MergeOurs.Resolve)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again.Changes() != 0 {
+				t.Errorf("merging the result with itself changed %d entries", again.Changes())
+			}
+		})
+	}
+}
+
+func TestMergeConflictDetails(t *testing.T) {
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	ours := newTestVault(t, "passphrase")
+	theirs := ours.clone(t)
+	ours.put(t, "mail", "same", "our notes", created, created.Add(time.Hour))
+	theirs.put(t, "mail", "same", "their notes", created, created.Add(2*time.Hour))
+	theirs.seal(t)
+
+	var conflict *MergeConflict
+	_, err := ours.storage.Merge(ours.key, nil, theirs.storage, theirs.key, func(c *MergeConflict) (bool, error) {
+		conflict = c
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if conflict == nil || conflict.Section != "web" || conflict.Name != "mail" || !conflict.SamePassword || conflict.OursRemoved || conflict.TheirsRemoved {
+		t.Fatalf("got the conflict %+v", conflict)
+	}
+	entry, _ := ours.storage.GetEntry("web", "mail")
+	details, err := ours.storage.DecodeDetails(ours.key, "web", "mail", entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.Notes != "their notes" {
+		t.Errorf("got the notes %q, expected theirs", details.Notes)
+	}
+}
+
+func TestMergeOtherPassphrase(t *testing.T) {
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	ours := newTestVault(t, "passphrase")
+	ours.put(t, "mail", "ours", "", created, created)
+
+	theirs := newTestVault(t, "another passphrase")
+	theirs.seal(t)
+	if err := theirs.storage.Unlock(theirs.key); err != nil {
+		t.Fatal(err)
+	}
+	if err := theirs.storage.SetEncryptedNames(true); err != nil {
+		t.Fatal(err)
+	}
+	theirs.put(t, "bank", "theirs", "their notes", created, created)
+	theirs.seal(t)
+
+	if _, err := ours.storage.Merge(ours.key, nil, theirs.storage, ours.key, MergeOurs.Resolve); err == nil {
+		t.Fatal("merged with the wrong key")
+	} else if _, ok := err.(*MergeKeyError); !ok {
+		t.Fatalf("got %v with the wrong key", err)
+	}
+
+	report, err := ours.storage.Merge(ours.key, nil, theirs.storage, theirs.key, MergeOurs.Resolve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Added) != 1 || report.Added[0] != (EntryRef{"web", "bank"}) {
+		t.Fatalf("got %+v", report)
+	}
+
+	// The entry taken from them is encrypted with our key, under its name in clear
+	if password, _ := ours.password(t, "mail"); password != "ours" {
+		t.Errorf("mail: got %q", password)
+	}
+	if password, _ := ours.password(t, "bank"); password != "theirs" {
+		t.Errorf("bank: got %q", password)
+	}
+	entry, _ := ours.storage.GetEntry("web", "bank")
+	if details, err := ours.storage.DecodeDetails(ours.key, "web", "bank", entry); err != nil || details.Notes != "their notes" {
+		t.Errorf("bank: got the details %+v (%v)", details, err)
+	}
+}
+
+func TestMergeEmptySections(t *testing.T) {
+	ours := newTestVault(t, "passphrase")
+	for _, section := range []string{"kept", "removed by them"} {
+		if err := ours.storage.AddSection(section); err != nil {
+			t.Fatal(err)
+		}
+	}
+	base := ours.clone(t).storage
+	theirs := ours.clone(t)
+
+	if err := ours.storage.AddSection("added by us"); err != nil {
+		t.Fatal(err)
+	}
+	if err := theirs.storage.AddSection("added by them"); err != nil {
+		t.Fatal(err)
+	}
+	delete(theirs.storage.Sections, "removed by them")
+	theirs.seal(t)
+
+	if _, err := ours.storage.Merge(ours.key, base, theirs.storage, theirs.key, MergeOurs.Resolve); err != nil {
+		t.Fatal(err)
+	}
+
+	for section, expected := range map[string]bool{"kept": true, "added by us": true, "added by them": true, "removed by them": false} {
+		if ours.storage.HasSection(section) != expected {
+			t.Errorf("%s: got %v, expected %v", section, !expected, expected)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+)
+
+// In a storage with encrypted names, sections and passwords are not stored under their names but under tokens: a keyed hash of the name, which is deterministic so Get still works. The names themselves are encrypted in Storage.Names, to be listed.
+
+// Prefix of the tokens, to tell them from names when reading the master file
+const tokenPrefix = "~"
+
+// Labels used to derive the keys of the tokens and of the encrypted names from the secret key
+const (
+	tokenLabel = "mpm name tokens"
+	nameLabel  = "mpm names"
+)
+
+// ErrNamesLocked is raised when the names of a storage are encrypted, and it has not been unlocked.
+var ErrNamesLocked = errors.New("The names of this storage are encrypted, it must be unlocked first")
+
+// Unlock verifies the storage with the secret key (see Verify), and keeps the key to read and write encrypted names.
+func (s *Storage) Unlock(key []byte) error {
+	if err := s.Verify(key); err != nil {
+		return err
+	}
+
+	s.key = key
+	return nil
+}
+
+// SetEncryptedNames enables or disables the encryption of the names. The storage must be unlocked. If there is an error during this operation, nothing is comitted.
+func (s *Storage) SetEncryptedNames(enabled bool) error {
+	if s.key == nil {
+		return ErrNamesLocked
+	}
+
+	layout, err := s.decodeLayout(s.key)
+	if err != nil {
+		return err
+	}
+	tombstones, err := s.decodeTombstones(s.key)
+	if err != nil {
+		return err
+	}
+
+	s.EncryptedNames = enabled
+	s.Names, s.Deleted = nil, nil
+	if err = s.encodeLayout(s.key, layout); err != nil {
+		return err
+	}
+	return s.encodeTombstones(s.key, tombstones)
+}
+
+// subKey derives a key dedicated to one purpose from the secret key.
+func subKey(key []byte, label string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+// token computes the token of a section (name empty) or of a password. Each part is length-prefixed, and a leading byte tells sections from passwords.
+func token(key []byte, section string, name *string) string {
+	data := []byte{'s'}
+	if name != nil {
+		data[0] = 'p'
+	}
+
+	for _, part := range []*string{&section, name} {
+		if part != nil {
+			data = binary.AppendUvarint(data, uint64(len(*part)))
+			data = append(data, *part...)
+		}
+	}
+
+	mac := hmac.New(sha256.New, subKey(key, tokenLabel))
+	mac.Write(data)
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// storedSection returns the key of a section in Storage.Sections.
+func (s *Storage) storedSection(section string) (string, error) {
+	if !s.EncryptedNames {
+		return section, nil
+	}
+
+	if s.key == nil {
+		return "", ErrNamesLocked
+	}
+
+	return token(s.key, section, nil), nil
+}
+
+// storedName returns the key of a password in its section of Storage.Sections.
+func (s *Storage) storedName(section string, name string) (string, error) {
+	if !s.EncryptedNames {
+		return name, nil
+	}
+
+	if s.key == nil {
+		return "", ErrNamesLocked
+	}
+
+	return token(s.key, section, &name), nil
+}
+
+// Names are padded with NUL bytes up to a multiple of this size, so that their encryption does not reveal their length
+const namePadding = 32
+
+// nameTranscoder encrypts the name stored under a token. The token is bound as associated data, so names can't be swapped.
+func nameTranscoder(key []byte, token string) PasswordTranscoder {
+	return NewEntryTranscoder(subKey(key, nameLabel), token, "")
+}
+
+// encryptName pads then encrypts the name stored under a token.
+func encryptName(key []byte, token string, name string) (string, error) {
+	padded := name + strings.Repeat("\x00", namePadding-len(name)%namePadding)
+	encoded, err := nameTranscoder(key, token).EncodePassword(padded)
+	return string(encoded), err
+}
+
+// clearName decrypts the name stored under a token.
+func (s *Storage) clearName(key []byte, token string) (string, error) {
+	encoded, ok := s.Names[token]
+	if !ok {
+		return "", &TamperError{"the name of " + token + " is missing"}
+	}
+
+	decoded, err := nameTranscoder(key, token).DecodePassword(encoded)
+	if err != nil {
+		return "", &TamperError{"the name of " + token + " does not authenticate"}
+	}
+
+	return strings.TrimRight(string(decoded), "\x00"), nil
+}
+
+// registerName encrypts the name stored under a token, unless it already is. Encrypted names don't change on each write, which keeps the diffs of the master file small.
+func (s *Storage) registerName(key []byte, token string, name string) error {
+	if _, ok := s.Names[token]; ok {
+		return nil
+	}
+
+	encoded, err := encryptName(key, token, name)
+	if err != nil {
+		return err
+	}
+
+	if s.Names == nil {
+		s.Names = make(map[string]string)
+	}
+	s.Names[token] = encoded
+	return nil
+}
+
+// decodeLayout returns a deep copy of the sections of the storage under their clear names: section -> name -> entry.
+func (s *Storage) decodeLayout(key []byte) (map[string]map[string]*Entry, error) {
+	layout := make(map[string]map[string]*Entry)
+	if !s.EncryptedNames {
+		for section, sec := range s.Sections {
+			layout[section] = make(map[string]*Entry)
+			for name, v := range sec {
+				copied := *v
+				layout[section][name] = &copied
+			}
+		}
+		return layout, nil
+	}
+
+	for secToken, sec := range s.Sections {
+		section, err := s.clearName(key, secToken)
+		if err != nil {
+			return nil, err
+		}
+
+		if token(key, section, nil) != secToken {
+			return nil, &TamperError{"section " + secToken + " does not match its name"}
+		}
+
+		layout[section] = make(map[string]*Entry)
+		for passToken, v := range sec {
+			name, err := s.clearName(key, passToken)
+			if err != nil {
+				return nil, err
+			}
+
+			// A token moved to another section would be decrypted under a wrong name
+			if token(key, section, &name) != passToken {
+				return nil, &TamperError{"password " + passToken + " is not in its section"}
+			}
+			copied := *v
+			layout[section][name] = &copied
+		}
+	}
+
+	return layout, nil
+}
+
+// encodeLayout replaces the sections of the storage with the given ones, stored under their clear names or their tokens depending on the mode of the storage. Encrypted names no longer used are dropped, the others keep their encryption. If there is an error during this operation, nothing is comitted.
+func (s *Storage) encodeLayout(key []byte, layout map[string]map[string]*Entry) error {
+	if !s.EncryptedNames {
+		s.Sections = layout
+		s.Names = nil
+		return nil
+	}
+
+	names := make(map[string]string)
+	encode := func(token string, name string) error {
+		if encoded, ok := s.Names[token]; ok {
+			names[token] = encoded
+			return nil
+		}
+
+		encoded, err := encryptName(key, token, name)
+		names[token] = encoded
+		return err
+	}
+
+	sections := make(map[string]map[string]*Entry)
+	for section, sec := range layout {
+		secToken := token(key, section, nil)
+		if err := encode(secToken, section); err != nil {
+			return err
+		}
+
+		sections[secToken] = make(map[string]*Entry)
+		for name, v := range sec {
+			passToken := token(key, section, &name)
+			if err := encode(passToken, name); err != nil {
+				return err
+			}
+			sections[secToken][passToken] = v
+		}
+	}
+
+	// The tombstones keep their names, see encodeTombstones
+	keep := func(token string) {
+		if encoded, ok := s.Names[token]; ok {
+			names[token] = encoded
+		}
+	}
+	for secToken, sec := range s.Deleted {
+		keep(secToken)
+		for passToken := range sec {
+			keep(passToken)
+		}
+	}
+
+	s.Sections = sections
+	s.Names = names
+	return nil
+}
+
+// decodeTombstones returns a copy of the tombstones of the storage under their clear names: section -> name -> when the password was removed.
+func (s *Storage) decodeTombstones(key []byte) (map[string]map[string]time.Time, error) {
+	tombstones := make(map[string]map[string]time.Time)
+	for storedSec, sec := range s.Deleted {
+		section := storedSec
+		if s.EncryptedNames {
+			var err error
+			if section, err = s.clearName(key, storedSec); err != nil {
+				return nil, err
+			}
+			if token(key, section, nil) != storedSec {
+				return nil, &TamperError{"the tombstones of section " + storedSec + " do not match its name"}
+			}
+		}
+
+		tombstones[section] = make(map[string]time.Time)
+		for storedPass, date := range sec {
+			name := storedPass
+			if s.EncryptedNames {
+				var err error
+				if name, err = s.clearName(key, storedPass); err != nil {
+					return nil, err
+				}
+				if token(key, section, &name) != storedPass {
+					return nil, &TamperError{"the tombstone " + storedPass + " is not in its section"}
+				}
+			}
+			tombstones[section][name] = date
+		}
+	}
+
+	return tombstones, nil
+}
+
+// encodeTombstones replaces the tombstones of the storage with the given ones, stored like the sections. It must be called after encodeLayout, which drops the names it doesn't know about.
+func (s *Storage) encodeTombstones(key []byte, tombstones map[string]map[string]time.Time) error {
+	deleted := make(map[string]map[string]time.Time)
+	for section, sec := range tombstones {
+		if len(sec) == 0 {
+			continue
+		}
+
+		storedSec := section
+		if s.EncryptedNames {
+			storedSec = token(key, section, nil)
+			if err := s.registerName(key, storedSec, section); err != nil {
+				return err
+			}
+		}
+
+		deleted[storedSec] = make(map[string]time.Time)
+		for name, date := range sec {
+			storedPass := name
+			if s.EncryptedNames {
+				storedPass = token(key, section, &name)
+				if err := s.registerName(key, storedPass, name); err != nil {
+					return err
+				}
+			}
+			deleted[storedSec][storedPass] = date
+		}
+	}
+
+	if len(deleted) == 0 {
+		deleted = nil
+	}
+	s.Deleted = deleted
+	return nil
+}
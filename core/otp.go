@@ -0,0 +1,280 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Types of one-time passwords: time-based (RFC 6238) or counter-based (RFC 4226)
+const (
+	OTPTime    = "totp"
+	OTPCounter = "hotp"
+)
+
+// Hash functions of the one-time passwords
+var otpAlgorithms = map[string]func() hash.Hash{
+	"SHA1":   sha1.New,
+	"SHA256": sha256.New,
+	"SHA512": sha512.New,
+}
+
+// OTP generates the one-time passwords of a second factor, from the secret shared with the site
+type OTP struct {
+	// OTPTime or OTPCounter
+	Type string `json:"Type"`
+	// Shared secret, encoded in base32 without padding
+	Secret string `json:"Secret"`
+	// SHA1, SHA256 or SHA512
+	Algorithm string `json:"Algorithm"`
+	// Number of digits of the codes
+	Digits int `json:"Digits"`
+	// Lifetime of the codes in seconds, for the time-based ones
+	Period int `json:"Period,omitempty"`
+	// Counter of the next code, for the counter-based ones
+	Counter uint64 `json:"Counter,omitempty"`
+	// Site and account, as given by the otpauth URI
+	Issuer  string `json:"Issuer,omitempty"`
+	Account string `json:"Account,omitempty"`
+}
+
+// NewOTP creates a time-based generator from a base32 secret, with the usual parameters: SHA1, 6 digits, every 30 seconds.
+func NewOTP(secret string) (*OTP, error) {
+	otp := &OTP{Type: OTPTime, Secret: secret, Algorithm: "SHA1", Digits: 6, Period: 30}
+	if err := otp.normalize(); err != nil {
+		return nil, err
+	}
+
+	return otp, nil
+}
+
+// ParseOTPURI reads a generator from an otpauth URI, as encoded in the QR codes shown by the sites: otpauth://totp/Issuer:account?secret=...&issuer=...&algorithm=SHA1&digits=6&period=30, or otpauth://hotp/...&counter=0
+func ParseOTPURI(uri string) (*OTP, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "otpauth" {
+		return nil, fmt.Errorf("Expected an otpauth:// URI, received %s://", parsed.Scheme)
+	}
+
+	query := parsed.Query()
+	otp := &OTP{Type: strings.ToLower(parsed.Host), Secret: query.Get("secret"), Algorithm: "SHA1", Digits: 6, Issuer: query.Get("issuer")}
+	if otp.Type == OTPTime {
+		otp.Period = 30
+	}
+
+	// The label is the account, possibly prefixed by the issuer
+	label := strings.TrimPrefix(parsed.Path, "/")
+	if i := strings.Index(label, ":"); i >= 0 {
+		if otp.Issuer == "" {
+			otp.Issuer = strings.TrimSpace(label[:i])
+		}
+		label = label[i+1:]
+	}
+	otp.Account = strings.TrimSpace(label)
+
+	if algorithm := query.Get("algorithm"); algorithm != "" {
+		otp.Algorithm = algorithm
+	}
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{{"digits", &otp.Digits}, {"period", &otp.Period}} {
+		if value := query.Get(param.name); value != "" {
+			if *param.value, err = strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("Invalid %s %s", param.name, value)
+			}
+		}
+	}
+	if counter := query.Get("counter"); counter != "" {
+		if otp.Counter, err = strconv.ParseUint(counter, 10, 64); err != nil {
+			return nil, fmt.Errorf("Invalid counter %s", counter)
+		}
+	} else if otp.Type == OTPCounter {
+		return nil, fmt.Errorf("The counter of an hotp URI is required")
+	}
+
+	if err = otp.normalize(); err != nil {
+		return nil, err
+	}
+	return otp, nil
+}
+
+// URI encodes the generator as an otpauth URI, see ParseOTPURI
+func (o *OTP) URI() string {
+	label := o.Account
+	if o.Issuer != "" {
+		label = o.Issuer + ":" + o.Account
+	}
+
+	query := url.Values{}
+	query.Set("secret", o.Secret)
+	if o.Issuer != "" {
+		query.Set("issuer", o.Issuer)
+	}
+	query.Set("algorithm", o.Algorithm)
+	query.Set("digits", strconv.Itoa(o.Digits))
+	if o.Type == OTPTime {
+		query.Set("period", strconv.Itoa(o.Period))
+	} else {
+		query.Set("counter", strconv.FormatUint(o.Counter, 10))
+	}
+
+	return (&url.URL{Scheme: "otpauth", Host: o.Type, Path: "/" + label, RawQuery: query.Encode()}).String()
+}
+
+// Normalizes the secret and the algorithm, then validates the generator
+func (o *OTP) normalize() error {
+	o.Secret = strings.TrimRight(strings.ToUpper(strings.Join(strings.Fields(o.Secret), "")), "=")
+	o.Algorithm = strings.ToUpper(strings.Replace(o.Algorithm, "-", "", -1))
+
+	return o.Validate()
+}
+
+// Returns the shared secret, decoded
+func (o *OTP) key() ([]byte, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(o.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("The secret must be encoded in base32")
+	}
+
+	return key, nil
+}
+
+// Validate checks the parameters of the generator
+func (o *OTP) Validate() error {
+	if o.Type != OTPTime && o.Type != OTPCounter {
+		return fmt.Errorf("Unknown one-time password type %s, expected %s or %s", o.Type, OTPTime, OTPCounter)
+	}
+	if key, err := o.key(); err != nil {
+		return err
+	} else if len(key) == 0 {
+		return fmt.Errorf("The secret can't be empty")
+	}
+	if _, ok := otpAlgorithms[o.Algorithm]; !ok {
+		return fmt.Errorf("Unknown algorithm %s, expected SHA1, SHA256 or SHA512", o.Algorithm)
+	}
+	// The truncated HMAC has 31 bits, more than 9 digits would always start with 0: like most authenticators, mpm stops at 8
+	if o.Digits < 6 || o.Digits > 8 {
+		return fmt.Errorf("The number of digits must be between 6 and 8, received %d", o.Digits)
+	}
+	if o.Type == OTPTime && o.Period <= 0 {
+		return fmt.Errorf("The period must be positive, received %d", o.Period)
+	}
+
+	return nil
+}
+
+// Code computes the code at a given time for the time-based generators, or the code of the current counter for the counter-based ones. The caller must then move the counter forward, see Next.
+func (o *OTP) Code(now time.Time) (string, error) {
+	if err := o.Validate(); err != nil {
+		return "", err
+	}
+
+	counter := o.Counter
+	if o.Type == OTPTime {
+		counter = uint64(now.Unix()) / uint64(o.Period)
+	}
+
+	return o.hotp(counter)
+}
+
+// Next moves the counter of a counter-based generator forward, once its code was used
+func (o *OTP) Next() {
+	if o.Type == OTPCounter {
+		o.Counter++
+	}
+}
+
+// Remaining returns how long the code of a time-based generator stays valid
+func (o *OTP) Remaining(now time.Time) time.Duration {
+	if o.Type != OTPTime || o.Period <= 0 {
+		return 0
+	}
+
+	period := int64(o.Period) * int64(time.Second)
+	return time.Duration(period - now.UnixNano()%period)
+}
+
+// Computes the code of a counter, as defined by RFC 4226: the HMAC of the counter, dynamically truncated to 31 bits then to the digits
+func (o *OTP) hotp(counter uint64) (string, error) {
+	key, err := o.key()
+	if err != nil {
+		return "", err
+	}
+
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, counter)
+	mac := hmac.New(otpAlgorithms[o.Algorithm], key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	truncated := uint64(binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff)
+
+	modulo := uint64(1)
+	for i := 0; i < o.Digits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", o.Digits, truncated%modulo), nil
+}
+
+// String describes the generator without its secret
+func (o *OTP) String() string {
+	description := fmt.Sprintf("%s, %s, %d digits", strings.ToUpper(o.Type), o.Algorithm, o.Digits)
+	if o.Type == OTPTime {
+		description += fmt.Sprintf(", every %ds", o.Period)
+	} else {
+		description += fmt.Sprintf(", counter %d", o.Counter)
+	}
+	if o.Issuer != "" || o.Account != "" {
+		description += fmt.Sprintf(" (%s)", strings.Trim(o.Issuer+":"+o.Account, ":"))
+	}
+
+	return description
+}
+
+// DecodeOTP decrypts the one-time password generator of an entry, nil if it has none.
+func (s *Storage) DecodeOTP(key []byte, section string, name string, entry *Entry) (*OTP, error) {
+	if entry.OTP == "" {
+		return nil, nil
+	}
+
+	decoded, err := s.fieldTranscoder(key, section, name, fieldOTP).DecodePassword(entry.OTP)
+	if err != nil {
+		return nil, err
+	}
+
+	otp := &OTP{}
+	if err = json.Unmarshal(decoded, otp); err != nil {
+		return nil, err
+	}
+
+	return otp, nil
+}
+
+// EncodeOTP encrypts the one-time password generator of an entry. A nil generator is not stored at all.
+func (s *Storage) EncodeOTP(key []byte, section string, name string, otp *OTP) (string, error) {
+	if otp == nil {
+		return "", nil
+	}
+
+	data, err := json.Marshal(otp)
+	if err != nil {
+		return "", err
+	}
+
+	encoded, err := s.fieldTranscoder(key, section, name, fieldOTP).EncodePassword(string(data))
+	return string(encoded), err
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// RFC 4226, appendix D
+func TestHOTPVectors(t *testing.T) {
+	expected := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+
+	otp := &OTP{Type: OTPCounter, Secret: otpSecret("12345678901234567890"), Algorithm: "SHA1", Digits: 6}
+	for counter, code := range expected {
+		got, err := otp.Code(time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != code {
+			t.Errorf("counter %d: got %s, expected %s", counter, got, code)
+		}
+		otp.Next()
+	}
+}
+
+// RFC 6238, appendix B
+func TestTOTPVectors(t *testing.T) {
+	secrets := map[string]string{
+		"SHA1":   "12345678901234567890",
+		"SHA256": "12345678901234567890123456789012",
+		"SHA512": "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+	tests := []struct {
+		time  int64
+		codes map[string]string
+	}{
+		{59, map[string]string{"SHA1": "94287082", "SHA256": "46119246", "SHA512": "90693936"}},
+		{1111111109, map[string]string{"SHA1": "07081804", "SHA256": "68084774", "SHA512": "25091201"}},
+		{1111111111, map[string]string{"SHA1": "14050471", "SHA256": "67062674", "SHA512": "99943326"}},
+		{1234567890, map[string]string{"SHA1": "89005924", "SHA256": "91819424", "SHA512": "93441116"}},
+		{2000000000, map[string]string{"SHA1": "69279037", "SHA256": "90698825", "SHA512": "38618901"}},
+		{20000000000, map[string]string{"SHA1": "65353130", "SHA256": "77737706", "SHA512": "47863826"}},
+	}
+
+	for _, test := range tests {
+		for algorithm, code := range test.codes {
+			otp := &OTP{Type: OTPTime, Secret: otpSecret(secrets[algorithm]), Algorithm: algorithm, Digits: 8, Period: 30}
+			got, err := otp.Code(time.Unix(test.time, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != code {
+				t.Errorf("%s at %d: got %s, expected %s", algorithm, test.time, got, code)
+			}
+		}
+	}
+}
+
+func TestParseOTPURI(t *testing.T) {
+	tests := []struct {
+		uri      string
+		expected OTP
+	}{
+		{
+			"otpauth://totp/Example:alice@example.com?secret=JBSWY3DPEHPK3PXP&issuer=Example",
+			OTP{Type: OTPTime, Secret: "JBSWY3DPEHPK3PXP", Algorithm: "SHA1", Digits: 6, Period: 30, Issuer: "Example", Account: "alice@example.com"},
+		},
+		{
+			"otpauth://totp/ACME%20Co:john.doe@email.com?secret=hxdm%20vjec%20jjws%20rb3h%20wizr%204ifu%20gftm%20xboz&algorithm=sha-256&digits=8&period=60",
+			OTP{Type: OTPTime, Secret: "HXDMVJECJJWSRB3HWIZR4IFUGFTMXBOZ", Algorithm: "SHA256", Digits: 8, Period: 60, Issuer: "ACME Co", Account: "john.doe@email.com"},
+		},
+		{
+			"otpauth://hotp/bob?secret=GEZDGNBVGY3TQOJQ&counter=42&algorithm=SHA512",
+			OTP{Type: OTPCounter, Secret: "GEZDGNBVGY3TQOJQ", Algorithm: "SHA512", Digits: 6, Counter: 42, Account: "bob"},
+		},
+	}
+
+	for _, test := range tests {
+		otp, err := ParseOTPURI(test.uri)
+		if err != nil {
+			t.Fatalf("%s: %s", test.uri, err)
+		}
+		if !reflect.DeepEqual(*otp, test.expected) {
+			t.Errorf("%s: got %+v, expected %+v", test.uri, *otp, test.expected)
+		}
+
+		// The URI written back is read as the same generator
+		again, err := ParseOTPURI(otp.URI())
+		if err != nil {
+			t.Fatalf("%s: %s", otp.URI(), err)
+		}
+		if !reflect.DeepEqual(again, otp) {
+			t.Errorf("%s: read back as %+v, expected %+v", otp.URI(), again, otp)
+		}
+	}
+}
+
+func TestParseOTPURIInvalid(t *testing.T) {
+	for _, uri := range []string{
+		"https://totp/alice?secret=JBSWY3DPEHPK3PXP",
+		"otpauth://totp/alice",
+		"otpauth://totp/alice?secret=not-base32!",
+		"otpauth://motp/alice?secret=JBSWY3DPEHPK3PXP",
+		"otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP&algorithm=MD5",
+		"otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP&digits=5",
+		"otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP&digits=9",
+		"otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP&period=0",
+		"otpauth://hotp/alice?secret=JBSWY3DPEHPK3PXP",
+	} {
+		if otp, err := ParseOTPURI(uri); err == nil {
+			t.Errorf("%s: accepted as %+v", uri, *otp)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package core
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// Character classes of a policy
+const (
+	ClassLower  = "lower"
+	ClassUpper  = "upper"
+	ClassDigit  = "digit"
+	ClassSymbol = "symbol"
+)
+
+// Classes lists the character classes, in the order they are checked
+var Classes = []string{ClassLower, ClassUpper, ClassDigit, ClassSymbol}
+
+// Characters of each class. The space is left out of the symbols, it is too easily lost when copying a password.
+var classChars = map[string]string{
+	ClassLower:  "abcdefghijklmnopqrstuvwxyz",
+	ClassUpper:  "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	ClassDigit:  "0123456789",
+	ClassSymbol: "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~",
+}
+
+// Characters which are easily mistaken for one another
+const AmbiguousChars = "0O1Il|"
+
+// How many passwords are drawn at most before giving up on the maximum run of a policy, see Generate
+const policyAttempts = 100000
+
+// Longest password mpm generates
+const MaxLength = 1000
+
+// Policy describes the passwords to generate: the sites with composition rules accept all of them.
+type Policy struct {
+	Length int `json:"Length"`
+	// Characters allowed, for a policy made from an alphabet. If empty, those of the classes are.
+	Characters string `json:"Characters,omitempty"`
+	// Character classes allowed, with the minimum number of characters of each one. With Characters, only the minimums count.
+	Classes map[string]int `json:"Classes"`
+	// Characters which never appear
+	Exclude string `json:"Exclude,omitempty"`
+	// Whether the ambiguous characters never appear, see AmbiguousChars
+	NoAmbiguous bool `json:"NoAmbiguous,omitempty"`
+	// Maximum number of times a character is repeated in a row, 0 for no limit
+	MaxRun int `json:"MaxRun,omitempty"`
+}
+
+// NewPolicy creates a policy allowing all the classes, without constraint
+func NewPolicy(length int) *Policy {
+	classes := make(map[string]int)
+	for _, class := range Classes {
+		classes[class] = 0
+	}
+
+	return &Policy{Length: length, Classes: classes}
+}
+
+// Removes the characters excluded by the policy
+func (p *Policy) filter(chars string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(p.Exclude, r) || (p.NoAmbiguous && strings.ContainsRune(AmbiguousChars, r)) {
+			return -1
+		}
+		return r
+	}, chars)
+}
+
+// Returns the characters allowed for a class
+func (p *Policy) classCharset(class string) string {
+	chars := p.filter(classChars[class])
+	if p.Characters == "" {
+		return chars
+	}
+
+	return strings.Map(func(r rune) rune {
+		if !strings.ContainsRune(p.Characters, r) {
+			return -1
+		}
+		return r
+	}, chars)
+}
+
+// Charset returns all the characters allowed by the policy
+func (p *Policy) Charset() string {
+	if p.Characters != "" {
+		return p.filter(p.Characters)
+	}
+
+	var charset strings.Builder
+	for _, class := range Classes {
+		if _, ok := p.Classes[class]; ok {
+			charset.WriteString(p.classCharset(class))
+		}
+	}
+
+	return charset.String()
+}
+
+// Validate checks the policy can be satisfied
+func (p *Policy) Validate() error {
+	if p.Length <= 0 || p.Length > MaxLength {
+		return fmt.Errorf("The length must be comprised between 1 and %d, received %d", MaxLength, p.Length)
+	}
+
+	if p.Characters != "" {
+		if err := ValidateCharacters(p.Characters); err != nil {
+			return fmt.Errorf("Invalid characters: %s", err)
+		}
+	}
+
+	required := 0
+	for class, min := range p.Classes {
+		if _, ok := classChars[class]; !ok {
+			return fmt.Errorf("Unknown character class %s, expected one of %s", class, strings.Join(Classes, ", "))
+		}
+		if min < 0 {
+			return fmt.Errorf("The minimum number of %s characters can't be negative", class)
+		}
+		if min > 0 && p.classCharset(class) == "" {
+			return fmt.Errorf("All the %s characters are excluded, none can be required", class)
+		}
+		required += min
+	}
+
+	if required > p.Length {
+		return fmt.Errorf("The policy requires %d characters, more than the length %d", required, p.Length)
+	}
+
+	charset := p.Charset()
+	if charset == "" {
+		return fmt.Errorf("The policy allows no character")
+	}
+	if p.MaxRun < 0 {
+		return fmt.Errorf("The maximum run can't be negative")
+	}
+	if p.MaxRun > 0 && len(charset) == 1 && p.Length > p.MaxRun {
+		return fmt.Errorf("A single character is allowed, it must be repeated more than %d times", p.MaxRun)
+	}
+
+	return nil
+}
+
+// Generate creates a random password satisfying the policy, all the satisfying passwords being equally likely. The number of characters of each class with a minimum is drawn first, weighted by how many passwords have it (see passwordCounter), then each position gets a random character of its class. Forcing the required characters in at random positions instead would make some passwords more likely than others.
+// Only the maximum run is enforced by drawing again, if the policy is too strict no password may satisfy it within policyAttempts draws and an error is returned.
+func (p *Policy) Generate() (string, error) {
+	if err := p.Validate(); err != nil {
+		return "", err
+	}
+
+	groups, others := p.groups()
+	counter := newPasswordCounter(groups, others)
+	pass := make([]byte, p.Length)
+
+	for attempt := 0; attempt < policyAttempts; attempt++ {
+		// Class of each position, the others being len(groups)
+		classes := make([]int, 0, p.Length)
+		left := p.Length
+		for i := range groups {
+			count, err := counter.draw(i, left)
+			if err != nil {
+				return "", err
+			}
+			for j := 0; j < count; j++ {
+				classes = append(classes, i)
+			}
+			left -= count
+		}
+		for len(classes) < p.Length {
+			classes = append(classes, len(groups))
+		}
+
+		// Shuffles the classes, then draws the characters
+		for i := len(classes) - 1; i > 0; i-- {
+			j, err := randomInt(i + 1)
+			if err != nil {
+				return "", err
+			}
+			classes[i], classes[j] = classes[j], classes[i]
+		}
+		for i, class := range classes {
+			chars := others
+			if class < len(groups) {
+				chars = groups[class].chars
+			}
+			next, err := randomInt(len(chars))
+			if err != nil {
+				return "", err
+			}
+			pass[i] = chars[next]
+		}
+
+		if p.satisfied(pass) {
+			return string(pass), nil
+		}
+	}
+
+	return "", fmt.Errorf("No password satisfying the policy was found after %d tries, its maximum run is too strict", policyAttempts)
+}
+
+// A class of characters with a minimum, see passwordCounter
+type charGroup struct {
+	chars string
+	min   int
+}
+
+// Splits the charset of the policy between the classes with a minimum, and the other characters
+func (p *Policy) groups() ([]charGroup, string) {
+	var groups []charGroup
+	others := p.Charset()
+	for _, class := range Classes {
+		if min := p.Classes[class]; min > 0 {
+			chars := p.classCharset(class)
+			groups = append(groups, charGroup{chars, min})
+			others = strings.Map(func(r rune) rune {
+				if strings.ContainsRune(chars, r) {
+					return -1
+				}
+				return r
+			}, others)
+		}
+	}
+
+	return groups, others
+}
+
+// Precision of the counts of passwords: the weights of the numbers of characters of each class are off by far less than anything measurable, while the counts of the longest policies are computed in a fraction of a second
+const countPrecision = 128
+
+// Counts the passwords holding at least the minimum of each group, to draw how many characters of each group a password has.
+type passwordCounter struct {
+	groups []charGroup
+	// Number of characters in no group
+	others int
+	// Counts already computed, by first group and length
+	memo map[[2]int]*big.Float
+}
+
+func newPasswordCounter(groups []charGroup, others string) *passwordCounter {
+	return &passwordCounter{groups: groups, others: len(others), memo: make(map[[2]int]*big.Float)}
+}
+
+// count returns the number of passwords of length n made of the groups from the i-th one, each with at least its minimum of characters, and of the other characters.
+func (c *passwordCounter) count(i int, n int) *big.Float {
+	key := [2]int{i, n}
+	if count, ok := c.memo[key]; ok {
+		return count
+	}
+
+	count := new(big.Float).SetPrec(countPrecision)
+	if i == len(c.groups) {
+		count.SetInt(new(big.Int).Exp(big.NewInt(int64(c.others)), big.NewInt(int64(n)), nil))
+	} else {
+		c.weights(i, n, func(k int, weight *big.Float) bool {
+			count.Add(count, weight)
+			return true
+		})
+	}
+
+	c.memo[key] = count
+	return count
+}
+
+// weights calls f with the number of passwords of length n counted by count(i, n) which have exactly k characters of the i-th group, from its minimum up, until f returns false
+func (c *passwordCounter) weights(i int, n int, f func(k int, weight *big.Float) bool) {
+	min, size := c.groups[i].min, int64(len(c.groups[i].chars))
+	if min > n {
+		return
+	}
+
+	// Number of ways to place and draw k characters of the group, updated from one k to the next
+	ways := new(big.Int).Binomial(int64(n), int64(min))
+	ways.Mul(ways, new(big.Int).Exp(big.NewInt(size), big.NewInt(int64(min)), nil))
+	placed := new(big.Float).SetPrec(countPrecision).SetInt(ways)
+
+	weight := new(big.Float).SetPrec(countPrecision)
+	for k := min; k <= n; k++ {
+		if k > min {
+			placed.Mul(placed, new(big.Float).SetInt64(size*int64(n-k+1)))
+			placed.Quo(placed, new(big.Float).SetInt64(int64(k)))
+		}
+		if !f(k, weight.Mul(placed, c.count(i+1, n-k))) {
+			return
+		}
+	}
+}
+
+// draw returns how many of the n characters left belong to the i-th group, each number weighted by how many passwords have it
+func (c *passwordCounter) draw(i int, n int) (int, error) {
+	// Uniform in [0, count(i, n)), with as many random bits as the precision
+	random, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), countPrecision))
+	if err != nil {
+		return 0, err
+	}
+	x := new(big.Float).SetPrec(countPrecision).SetMantExp(new(big.Float).SetInt(random), -countPrecision)
+	x.Mul(x, c.count(i, n))
+
+	drawn := n
+	c.weights(i, n, func(k int, weight *big.Float) bool {
+		if x.Cmp(weight) < 0 {
+			drawn = k
+			return false
+		}
+		x.Sub(x, weight)
+		return true
+	})
+
+	return drawn, nil
+}
+
+// Tells whether a password satisfies the minimum counts and maximum run of the policy
+func (p *Policy) satisfied(pass []byte) bool {
+	counts := make(map[string]int)
+	run := 0
+	for i, c := range pass {
+		for _, class := range Classes {
+			if strings.IndexByte(classChars[class], c) >= 0 {
+				counts[class]++
+			}
+		}
+
+		if i > 0 && pass[i-1] == c {
+			run++
+		} else {
+			run = 1
+		}
+		if p.MaxRun > 0 && run > p.MaxRun {
+			return false
+		}
+	}
+
+	for class, min := range p.Classes {
+		if counts[class] < min {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Describes the minimum counts of the classes
+func (p *Policy) required() []string {
+	var required []string
+	for class, min := range p.Classes {
+		if min > 0 {
+			required = append(required, fmt.Sprintf("at least %d %s", min, class))
+		}
+	}
+
+	sort.Strings(required)
+	return required
+}
+
+// String describes the policy in one line
+func (p *Policy) String() string {
+	classes := make([]string, 0, len(p.Classes))
+	for class, min := range p.Classes {
+		if min > 0 {
+			classes = append(classes, fmt.Sprintf("%s (at least %d)", class, min))
+		} else {
+			classes = append(classes, class)
+		}
+	}
+	sort.Strings(classes)
+
+	description := fmt.Sprintf("%d characters: %s", p.Length, strings.Join(classes, ", "))
+	if p.Characters != "" {
+		description = fmt.Sprintf("%d characters among %q", p.Length, p.Characters)
+		if required := p.required(); len(required) > 0 {
+			description += ", " + strings.Join(required, ", ")
+		}
+	}
+	if p.Exclude != "" {
+		description += fmt.Sprintf(", excluding %q", p.Exclude)
+	}
+	if p.NoAmbiguous {
+		description += ", no ambiguous character"
+	}
+	if p.MaxRun > 0 {
+		description += fmt.Sprintf(", at most %d identical characters in a row", p.MaxRun)
+	}
+
+	return description
+}
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPolicyGenerate(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+	}{
+		{"no constraint", *NewPolicy(20)},
+		{"strict", Policy{Length: 16, Classes: map[string]int{ClassDigit: 8, ClassSymbol: 8}}},
+		{"strict among all classes", Policy{Length: 16, Classes: map[string]int{ClassLower: 4, ClassUpper: 4, ClassDigit: 4, ClassSymbol: 4}}},
+		{"excluded characters", Policy{Length: 64, Classes: map[string]int{ClassLower: 0, ClassDigit: 10}, Exclude: "aeiou0123", NoAmbiguous: true}},
+		{"alphabet", Policy{Length: 12, Characters: "abcdef0123", Classes: map[string]int{ClassDigit: 11}}},
+		{"maximum run", Policy{Length: 20, Characters: "abc", Classes: map[string]int{ClassLower: 0}, MaxRun: 2}},
+		{"longest", Policy{Length: MaxLength, Classes: map[string]int{ClassLower: 200, ClassUpper: 200, ClassDigit: 200, ClassSymbol: 200}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start := time.Now()
+			for i := 0; i < 5; i++ {
+				pass, err := test.policy.Generate()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(pass) != test.policy.Length || !test.policy.satisfied([]byte(pass)) {
+					t.Fatalf("Generate returned %q, which does not satisfy the policy", pass)
+				}
+				for _, c := range pass {
+					if !strings.ContainsRune(test.policy.Charset(), c) {
+						t.Fatalf("Generate returned %q, %q is not allowed", pass, c)
+					}
+				}
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Fatalf("Generate took %s", elapsed)
+			}
+		})
+	}
+}
+
+// The number of passwords satisfying the policy is the one found by trying them all
+func TestPolicyCount(t *testing.T) {
+	tests := []Policy{
+		{Length: 4, Characters: "ab12!", Classes: map[string]int{ClassDigit: 1, ClassSymbol: 1}},
+		{Length: 3, Characters: "aB1!", Classes: map[string]int{ClassLower: 1, ClassUpper: 1, ClassDigit: 1}},
+		{Length: 5, Characters: "a12", Classes: map[string]int{ClassDigit: 4}},
+		{Length: 2, Characters: "ab", Classes: map[string]int{}},
+	}
+
+	for _, policy := range tests {
+		charset := policy.Charset()
+		expected := 0
+		pass := make([]byte, policy.Length)
+		var try func(i int)
+		try = func(i int) {
+			if i == len(pass) {
+				if policy.satisfied(pass) {
+					expected++
+				}
+				return
+			}
+			for j := 0; j < len(charset); j++ {
+				pass[i] = charset[j]
+				try(i + 1)
+			}
+		}
+		try(0)
+
+		groups, others := policy.groups()
+		if count, _ := newPasswordCounter(groups, others).count(0, policy.Length).Int64(); count != int64(expected) {
+			t.Errorf("%s: counted %d passwords instead of %d", &policy, count, expected)
+		}
+	}
+}
+
+// All the satisfying passwords are equally likely, those with more required characters than the minimum included
+func TestPolicyUniform(t *testing.T) {
+	policy := Policy{Length: 2, Characters: "a1", Classes: map[string]int{ClassDigit: 1}}
+	draws := 3000
+
+	counts := make(map[string]int)
+	for i := 0; i < draws; i++ {
+		pass, err := policy.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[pass]++
+	}
+
+	if len(counts) != 3 {
+		t.Fatalf("Generate returned %v", counts)
+	}
+	for pass, count := range counts {
+		// About 18 standard deviations around the expected 1000
+		if count < 800 || count > 1200 {
+			t.Fatalf("Generate returned %q %d times out of %d", pass, count, draws)
+		}
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+	}{
+		{"empty", Policy{Length: 0, Classes: map[string]int{ClassLower: 0}}},
+		{"too long", Policy{Length: MaxLength + 1, Classes: map[string]int{ClassLower: 0}}},
+		{"too many required", Policy{Length: 8, Classes: map[string]int{ClassDigit: 5, ClassSymbol: 4}}},
+		{"excluded class required", Policy{Length: 8, Classes: map[string]int{ClassDigit: 1}, Exclude: "23456789", NoAmbiguous: true}},
+		{"unknown class", Policy{Length: 8, Classes: map[string]int{"emoji": 1}}},
+		{"single repeated character", Policy{Length: 8, Characters: "ab", Classes: map[string]int{}, Exclude: "b", MaxRun: 2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.policy.Validate(); err == nil {
+				t.Fatal("Validate accepted an invalid policy")
+			}
+			if _, err := test.policy.Generate(); err == nil {
+				t.Fatal("Generate accepted an invalid policy")
+			}
+		})
+	}
+}
+
+func TestPolicyStrength(t *testing.T) {
+	if strength, expected := NewPolicy(16).Strength(), RandomStrength(94, 16); math.Abs(strength.Entropy-expected.Entropy) > 1e-9 {
+		t.Fatalf("Strength returned %f bits instead of %f", strength.Entropy, expected.Entropy)
+	}
+
+	// 16 choose 8 layouts, 10^8 digits and 32^8 symbols
+	policy := Policy{Length: 16, Classes: map[string]int{ClassDigit: 8, ClassSymbol: 8}}
+	expected := math.Log2(12870) + 8*math.Log2(10) + 8*math.Log2(32)
+	if strength := policy.Strength(); math.Abs(strength.Entropy-expected) > 1e-9 {
+		t.Fatalf("Strength returned %f bits instead of %f", strength.Entropy, expected)
+	}
+}
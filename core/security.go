@@ -4,9 +4,12 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
-	"crypto/sha512"
 	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"strings"
 )
 
 // Interface to be implemented for an encryption and encoding scheme
@@ -17,13 +20,33 @@ type PasswordTranscoder interface {
 	EncodePassword(pass string) ([]byte, error)
 }
 
+// Prefix of the passwords encrypted with AES-256-GCM. Legacy passwords (AES-256-CTR) have no prefix, base64 never produces a ':' so both can't be mistaken.
+const aeadPrefix = "v2:"
+
+// AuthError is raised when an encrypted password does not authenticate: it was either modified, moved from another entry, or encrypted with another passphrase.
+type AuthError struct {
+	Section string
+	Name    string
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("Password %s in section %s failed authentication, it may have been tampered with", e.Name, e.Section)
+}
+
+// IsLegacy tells whether an encoded password was produced by the legacy, unauthenticated, transcoder.
+func IsLegacy(encoded string) bool {
+	return !strings.HasPrefix(encoded, aeadPrefix)
+}
+
+// Legacy transcoder, AES-256 in CTR mode. It is only kept to read passwords written by older versions.
 type transcoder struct {
-	passphrase string
+	key []byte
 	*base64.Encoding
 }
 
-func NewTranscoder(s string) PasswordTranscoder {
-	return &transcoder{s, base64.StdEncoding}
+// NewTranscoder creates the legacy transcoder from a secret key. Passwords it produces are not authenticated, use NewEntryTranscoder instead.
+func NewTranscoder(key []byte) PasswordTranscoder {
+	return &transcoder{key, base64.StdEncoding}
 }
 
 func (d *transcoder) DecodePassword(pass string) ([]byte, error) {
@@ -32,11 +55,12 @@ func (d *transcoder) DecodePassword(pass string) ([]byte, error) {
 		return nil, err
 	}
 
-	key := sha512.Sum512_256([]byte(d.passphrase))
-
-	block, _ := aes.NewCipher(key[:])
+	block, err := aes.NewCipher(d.key)
+	if err != nil {
+		return nil, err
+	}
 	if len(ciphertext) < aes.BlockSize {
-		panic("No IV in the ciphertext !!!")
+		return nil, errors.New("The legacy password is corrupt, it is too short to hold its IV")
 	}
 
 	iv, ciphertext := ciphertext[:aes.BlockSize], ciphertext[aes.BlockSize:]
@@ -48,15 +72,16 @@ func (d *transcoder) DecodePassword(pass string) ([]byte, error) {
 }
 
 func (e *transcoder) EncodePassword(pass string) ([]byte, error) {
-	key := sha512.Sum512_256([]byte(e.passphrase))
-
-	block, _ := aes.NewCipher(key[:])
+	block, err := aes.NewCipher(e.key)
+	if err != nil {
+		return nil, err
+	}
 
 	ciphertext := make([]byte, aes.BlockSize+len(pass))
 	iv := ciphertext[:aes.BlockSize]
 
 	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	stream := cipher.NewCTR(block, iv)
@@ -67,3 +92,99 @@ func (e *transcoder) EncodePassword(pass string) ([]byte, error) {
 
 	return res, nil
 }
+
+// Authenticated transcoder, AES-256 in GCM mode. The section and the name of the entry are bound as associated data, so a password can't be moved to another entry without being detected.
+type entryTranscoder struct {
+	section string
+	name    string
+	field   string
+	aead    cipher.AEAD
+	legacy  PasswordTranscoder
+	*base64.Encoding
+}
+
+// NewEntryTranscoder creates the transcoder for the password stored under section and name, from a secret key. Legacy passwords are rejected, see Storage.Transcoder to read them.
+func NewEntryTranscoder(key []byte, section string, name string) PasswordTranscoder {
+	return newEntryTranscoder(key, section, name, false)
+}
+
+// NewFieldTranscoder creates the transcoder for another field of the entry stored under section and name. The field is bound as associated data too, the empty field being the password itself.
+func NewFieldTranscoder(key []byte, section string, name string, field string) PasswordTranscoder {
+	t := newEntryTranscoder(key, section, name, false).(*entryTranscoder)
+	t.field = field
+	return t
+}
+
+// newEntryTranscoder creates the authenticated transcoder. If legacy is set, it still reads legacy passwords, but always writes authenticated ones.
+func newEntryTranscoder(key []byte, section string, name string, legacy bool) PasswordTranscoder {
+	t := &entryTranscoder{section: section, name: name, Encoding: base64.StdEncoding}
+	if legacy {
+		t.legacy = NewTranscoder(key)
+	}
+
+	// Keys are always derived with the right size, errors can't happen here
+	block, _ := aes.NewCipher(key)
+	t.aead, _ = cipher.NewGCM(block)
+	return t
+}
+
+// additionalData builds the associated data of the entry. Each part is length-prefixed so that ("ab", "c") and ("a", "bc") differ. The password has no field, so that its associated data did not change when fields were introduced.
+func (t *entryTranscoder) additionalData() []byte {
+	parts := []string{t.section, t.name}
+	if t.field != "" {
+		parts = append(parts, t.field)
+	}
+
+	ad := []byte(aeadPrefix)
+	for _, part := range parts {
+		ad = binary.AppendUvarint(ad, uint64(len(part)))
+		ad = append(ad, part...)
+	}
+	return ad
+}
+
+func (t *entryTranscoder) DecodePassword(pass string) ([]byte, error) {
+	if IsLegacy(pass) {
+		if t.legacy == nil {
+			return nil, &AuthError{t.section, t.name}
+		}
+		// A legacy password which can't even be decoded has been modified
+		plaintext, err := t.legacy.DecodePassword(pass)
+		if err != nil {
+			return nil, &AuthError{t.section, t.name}
+		}
+		return plaintext, nil
+	}
+
+	data, err := t.DecodeString(strings.TrimPrefix(pass, aeadPrefix))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < t.aead.NonceSize() {
+		return nil, &AuthError{t.section, t.name}
+	}
+
+	nonce, ciphertext := data[:t.aead.NonceSize()], data[t.aead.NonceSize():]
+	plaintext, err := t.aead.Open(nil, nonce, ciphertext, t.additionalData())
+	if err != nil {
+		return nil, &AuthError{t.section, t.name}
+	}
+
+	return plaintext, nil
+}
+
+func (t *entryTranscoder) EncodePassword(pass string) ([]byte, error) {
+	nonce := make([]byte, t.aead.NonceSize(), t.aead.NonceSize()+len(pass)+t.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := t.aead.Seal(nonce, nonce, []byte(pass), t.additionalData())
+
+	res := make([]byte, len(aeadPrefix)+t.EncodedLen(len(sealed)))
+	copy(res, aeadPrefix)
+	t.Encode(res[len(aeadPrefix):], sealed)
+
+	return res, nil
+}
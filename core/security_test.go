@@ -0,0 +1,119 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestEntryTranscoderRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+
+	encoded, err := NewEntryTranscoder(key, "web", "mail").EncodePassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if IsLegacy(string(encoded)) {
+		t.Fatalf("EncodePassword wrote a legacy password %s", encoded)
+	}
+
+	decoded, err := NewEntryTranscoder(key, "web", "mail").DecodePassword(string(encoded))
+	if err != nil || string(decoded) != "secret" {
+		t.Fatalf("DecodePassword returned %q, %v", decoded, err)
+	}
+}
+
+// The ciphertext is bound to its entry: moved to another entry, or to another field of the same entry, it must not decrypt
+func TestEntryTranscoderAdditionalData(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+
+	encoded, err := NewEntryTranscoder(key, "web", "mail").EncodePassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		transcoder PasswordTranscoder
+	}{
+		{"other name", NewEntryTranscoder(key, "web", "bank")},
+		{"other section", NewEntryTranscoder(key, "work", "mail")},
+		{"shifted parts", NewEntryTranscoder(key, "webm", "ail")},
+		{"other field", NewFieldTranscoder(key, "web", "mail", "notes")},
+		{"other key", NewEntryTranscoder(bytes.Repeat([]byte{2}, 32), "web", "mail")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := test.transcoder.DecodePassword(string(encoded)); err == nil {
+				t.Fatal("DecodePassword accepted a password of another entry")
+			} else if _, ok := err.(*AuthError); !ok {
+				t.Fatalf("DecodePassword returned %v", err)
+			}
+		})
+	}
+}
+
+func TestEntryTranscoderTampered(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	transcoder := NewEntryTranscoder(key, "web", "mail")
+
+	encoded, err := transcoder.EncodePassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(string(encoded), aeadPrefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 1
+
+	if _, err = transcoder.DecodePassword(aeadPrefix + base64.StdEncoding.EncodeToString(data)); err == nil {
+		t.Fatal("DecodePassword accepted a modified password")
+	} else if _, ok := err.(*AuthError); !ok {
+		t.Fatalf("DecodePassword returned %v", err)
+	}
+}
+
+// Legacy passwords are only read by the transcoders of the storages still using the legacy key derivation
+func TestEntryTranscoderLegacy(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+
+	encoded, err := NewTranscoder(key).EncodePassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsLegacy(string(encoded)) {
+		t.Fatalf("IsLegacy rejected %s", encoded)
+	}
+
+	if _, err = NewEntryTranscoder(key, "web", "mail").DecodePassword(string(encoded)); err == nil {
+		t.Fatal("DecodePassword accepted a legacy password")
+	}
+
+	legacy := &Storage{}
+	decoded, err := legacy.Transcoder(key, "web", "mail").DecodePassword(string(encoded))
+	if err != nil || string(decoded) != "secret" {
+		t.Fatalf("DecodePassword returned %q, %v for a legacy storage", decoded, err)
+	}
+}
+
+// A truncated legacy password is reported like any modified one, instead of crashing every command decoding it
+func TestLegacyTranscoderTruncated(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	truncated := base64.StdEncoding.EncodeToString([]byte("short"))
+
+	if _, err := NewTranscoder(key).DecodePassword(truncated); err == nil {
+		t.Fatal("DecodePassword accepted a truncated legacy password")
+	}
+
+	legacy := &Storage{}
+	for _, encoded := range []string{truncated, "not base64!", ""} {
+		if _, err := legacy.Transcoder(key, "web", "mail").DecodePassword(encoded); err == nil {
+			t.Fatalf("DecodePassword accepted the legacy password %q", encoded)
+		} else if _, ok := err.(*AuthError); !ok {
+			t.Fatalf("DecodePassword returned %v for the legacy password %q", err, encoded)
+		}
+	}
+}
@@ -7,7 +7,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"path"
+	"sort"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -18,58 +19,117 @@ type Storage struct {
 	Passphrase string `json:"Pass"`
 	// The different sections of the file. The format is the following:
 	//     - section_name_1:
-	//         * pass_name_1: encrypted_pass_1
-	//         * pass_name_2: encrypted_pass_2
+	//         * pass_name_1: entry_1
+	//         * pass_name_2: entry_2
 	//     - section_name_2:
-	//         * pass_name_1: encrypted_pass_1
-	//         * pass_name_2: encrypted_pass_2
-	Sections map[string]map[string]string `json:"Sections"`
+	//         * pass_name_1: entry_1
+	//         * pass_name_2: entry_2
+	// If the names are encrypted, sections and passwords are stored under tokens instead of their names, see names.go. Entries only holding a password are stored as the encrypted password alone, see Entry.
+	Sections map[string]map[string]*Entry `json:"Sections"`
+	// Whether the names of the sections and passwords are encrypted
+	EncryptedNames bool `json:"EncryptedNames,omitempty"`
+	// Encrypted names of the sections and passwords, token -> encrypted name. Only used if the names are encrypted.
+	Names map[string]string `json:"Names,omitempty"`
+	// Tombstones of the passwords removed from the storage: section -> name -> when it was removed, stored under tokens like Sections if the names are encrypted. They tell a removed password from one which never existed when merging another copy of the storage, see Merge.
+	Deleted map[string]map[string]time.Time `json:"Deleted,omitempty"`
+	// Key derivation function used to derive the secret key from the passphrase. Storages created before it existed have none, their key is a single SHA512/256 hash.
+	KDF *KDF `json:"KDF,omitempty"`
+	// HMAC-SHA256 of the whole storage, encoded in base64. It is keyed with the secret key, see Seal and Verify.
+	MAC string `json:"MAC,omitempty"`
+
+	// Version of the master file this storage was read from, see DumpOnDisk. Empty for a new storage.
+	version string
+	// Secret key, once unlocked. It is only needed to read and write encrypted names.
+	key []byte
 }
 
-// Path to the master file
-var fileName string = path.Join(os.Getenv("HOME"), ".mpm")
-
 // Default bcrypt cost
 const bcryptCost = 10
 
-// InitPassphrase creates a new storage with given passphrase
-func InitPassphrase(new []byte) *Storage {
-	hashed, _ := bcrypt.GenerateFromPassword(new, bcryptCost)
+// InitPassphrase creates a new storage with given passphrase, its key is derived with argon2id.
+func InitPassphrase(new []byte) (*Storage, error) {
+	hashed, err := bcrypt.GenerateFromPassword(new, bcryptCost)
+	if err != nil {
+		return nil, err
+	}
+
+	kdf, err := NewKDF(KDFArgon2id)
+	if err != nil {
+		return nil, err
+	}
 
-	return &Storage{string(hashed), make(map[string]map[string]string)}
+	return &Storage{Passphrase: string(hashed), Sections: make(map[string]map[string]*Entry), KDF: kdf}, nil
 }
 
-// GetStorage reads the master file, and creates the matching storage object if possible. If not, an error is raised (invalid permissions, non-existent file, wrong formatting, etc ...
+// GetStorage reads the master file, and creates the matching storage object if possible. If not, an error is raised (invalid permissions, non-existent file, wrong formatting, missing MAC, etc ...). The MAC itself can only be checked with the secret key, see Verify.
 func GetStorage() (*Storage, error) {
-	_, err := os.Stat(fileName)
+	return readStorage(fileName)
+}
+
+// GetStorageAt reads another master file than the selected one, the same way GetStorage does, to merge it into the selected one for instance. It can't be dumped on the disk in place of the selected one.
+func GetStorageAt(path string) (*Storage, error) {
+	return readStorage(path)
+}
+
+// readStorage reads and parses a storage file, either the master file or one of its backups.
+func readStorage(file string) (*Storage, error) {
+	_, err := os.Stat(file)
 	if err != nil {
-		return nil, fmt.Errorf("File %s does not exists", fileName)
+		return nil, fmt.Errorf("File %s does not exists", file)
 	}
 
-	data, err := ioutil.ReadFile(fileName)
+	data, err := ioutil.ReadFile(file)
 	if err != nil {
 		return nil, err
 	}
 
+	return parseStorage(data)
+}
+
+// parseStorage parses the content of a storage file, its version is the one of the data.
+func parseStorage(data []byte) (*Storage, error) {
 	store := &Storage{}
-	err = json.Unmarshal(data, store)
+	err := json.Unmarshal(data, store)
 
 	if err != nil {
 		return nil, err
 	}
 
+	if err = store.checkSealed(); err != nil {
+		return nil, err
+	}
+
+	store.version = dataVersion(data)
 	return store, nil
 }
 
-// Saves the file on the disk, raises an error if necessary with JSON formatting
+// Saves the file on the disk, raises an error if necessary with JSON formatting. The storage should have been sealed first. The previous version of the file is kept as the most recent backup, and the new one replaces it atomically: whatever happens, the master file is either the old or the new one.
+// If the master file changed since this storage was read (or was created meanwhile, for a new storage), a *ConflictError is raised and nothing is written.
 func (s *Storage) DumpOnDisk() error {
 	data, err := json.Marshal(s)
 	if err != nil {
 		return err
 	}
 
-	err = ioutil.WriteFile(fileName, data, 0600)
-	return err
+	current, err := fileVersion(fileName)
+	if err != nil {
+		return err
+	}
+
+	if current != s.version {
+		return &ConflictError{fileName}
+	}
+
+	if err = rotateBackups(); err != nil {
+		return err
+	}
+
+	if err = writeFileAtomic(fileName, data); err != nil {
+		return err
+	}
+
+	s.version = dataVersion(data)
+	return nil
 }
 
 // CheckPassphrase verifies the given passphrase against the stored hash using bcrypt's hash function.
@@ -77,119 +137,444 @@ func (s *Storage) CheckPassphrase(pass string) error {
 	return bcrypt.CompareHashAndPassword([]byte(s.Passphrase), []byte(pass))
 }
 
-// SetNewPassphrase changes from the old passphrase to the new one. It checks for validity of the old one first, then decrypts all passwords and re-encrypts them with the new passphrase. If there is an error during this operation, nothing is comitted.
+// DeriveKey derives the secret key of the storage from the passphrase, using the key derivation function of its header.
+func (s *Storage) DeriveKey(passphrase string) ([]byte, error) {
+	return s.KDF.DeriveKey(passphrase)
+}
+
+// Transcoder creates the transcoder for the password stored under section and name. Legacy passwords can only be read from storages which still use the legacy key derivation, as they can't have been written with a newer one.
+func (s *Storage) Transcoder(key []byte, section string, name string) PasswordTranscoder {
+	return newEntryTranscoder(key, section, name, s.KDF == nil)
+}
+
+// SetNewPassphrase changes from the old passphrase to the new one. It checks for validity of the old one first, then decrypts all passwords and re-encrypts them with the new passphrase. Storages still using the legacy key derivation are migrated to argon2id, the others keep their parameters with a new salt. If there is an error during this operation, nothing is comitted.
 func (s *Storage) SetNewPassphrase(old string, new string) error {
 	err := bcrypt.CompareHashAndPassword([]byte(s.Passphrase), []byte(old))
 	if err != nil {
 		return err
 	}
 
-	// Decode with old one, encode with new one.
-	dec, enc := NewTranscoder(old), NewTranscoder(new)
+	var kdf *KDF
+	if s.KDF == nil {
+		if kdf, err = NewKDF(KDFArgon2id); err != nil {
+			return err
+		}
+	} else {
+		copied := *s.KDF
+		kdf = &copied
+		if err = kdf.Resalt(); err != nil {
+			return err
+		}
+	}
 
-	// Iterate through all passwords and make a deep copy of the map
-	updated := make(map[string]map[string]string)
-	for name, section := range s.Sections {
-		updated[name] = make(map[string]string)
-		for k, v := range section {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(new), bcryptCost)
+	if err != nil {
+		return err
+	}
 
-			decoded, err := dec.DecodePassword(v)
-			if err != nil {
-				panic(err)
-			}
+	if err = s.rekey(old, new, kdf); err != nil {
+		return err
+	}
 
-			encoded, err := enc.EncodePassword(string(decoded))
-			if err != nil {
-				panic(err)
-			}
+	s.Passphrase = string(hashed)
+	return nil
+}
 
-			updated[name][k] = string(encoded)
-		}
+// SetKDF re-encrypts all the passwords with a key derived by the given KDF, the passphrase does not change. If there is an error during this operation, nothing is comitted.
+func (s *Storage) SetKDF(passphrase string, kdf *KDF) error {
+	if err := s.CheckPassphrase(passphrase); err != nil {
+		return err
 	}
 
-	s.Sections = updated
+	return s.rekey(passphrase, passphrase, kdf)
+}
 
-	hashed, err := bcrypt.GenerateFromPassword([]byte(new), bcryptCost)
+// rekey decrypts all passwords with the current key, then re-encrypts them with a key derived from the new passphrase and KDF. Encrypted names are re-encrypted too. The storage is only modified once everything succeeded.
+func (s *Storage) rekey(old string, new string, kdf *KDF) error {
+	oldKey, err := s.DeriveKey(old)
 	if err != nil {
 		return err
 	}
 
-	s.Passphrase = string(hashed)
+	newKey, err := kdf.DeriveKey(new)
+	if err != nil {
+		return err
+	}
+
+	layout, err := s.decodeLayout(oldKey)
+	if err != nil {
+		return err
+	}
+	tombstones, err := s.decodeTombstones(oldKey)
+	if err != nil {
+		return err
+	}
+
+	// Iterate through all entries, the layout is already a deep copy
+	for name, section := range layout {
+		for k, v := range section {
+			// Decode with old one, encode with new one.
+			if section[k], err = s.reencrypt(v, oldKey, name, k, newKey, name, k); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Names are encrypted with the old key, none of them can be kept
+	sections, names, deleted := s.Sections, s.Names, s.Deleted
+	s.Names, s.Deleted = nil, nil
+	if err = s.encodeLayout(newKey, layout); err == nil {
+		err = s.encodeTombstones(newKey, tombstones)
+	}
+	if err != nil {
+		s.Sections, s.Names, s.Deleted = sections, names, deleted
+		return err
+	}
+
+	s.KDF = kdf
+	if s.key != nil {
+		s.key = newKey
+	}
 	return nil
 }
 
-// ListSections retrieves all the sections from the storage.
-func (s *Storage) ListSections() []string {
+// Upgrade re-encrypts the passwords still using the legacy scheme with the authenticated one, and returns how many were upgraded. If there is an error during this operation, nothing is comitted.
+func (s *Storage) Upgrade(key []byte) (int, error) {
+	layout, err := s.decodeLayout(key)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for name, section := range layout {
+		for k, v := range section {
+			if !v.legacy() {
+				continue
+			}
+
+			if section[k], err = s.reencrypt(v, key, name, k, key, name, k); err != nil {
+				return 0, err
+			}
+			count++
+		}
+	}
+
+	if count == 0 {
+		return 0, nil
+	}
+
+	return count, s.encodeLayout(key, layout)
+}
+
+// ListSections retrieves all the sections from the storage, sorted. The storage must be unlocked if its names are encrypted.
+func (s *Storage) ListSections() ([]string, error) {
+	all, err := s.ListAll()
+	if err != nil {
+		return nil, err
+	}
+
 	sections := make([]string, 0)
-	for k, _ := range s.Sections {
+	for k, _ := range all {
 		sections = append(sections, k)
 	}
-	return sections
+	sort.Strings(sections)
+	return sections, nil
 }
 
-// ListPasswords lists all the password contained in the section.
-func (s *Storage) ListPasswords(section string) []string {
-	res := make([]string, 0)
-	sec, ok := s.Sections[section]
-	if !ok {
-		return res
+// ListPasswords lists all the password contained in the section, sorted. The storage must be unlocked if its names are encrypted.
+func (s *Storage) ListPasswords(section string) ([]string, error) {
+	all, err := s.ListAll()
+	if err != nil {
+		return nil, err
 	}
 
-	for k, _ := range sec {
-		res = append(res, k)
+	res, ok := all[section]
+	if !ok {
+		return make([]string, 0), nil
 	}
 
-	return res
+	return res, nil
 }
 
-// List all lists all the sections and their passwords from the storage.
-func (s *Storage) ListAll() map[string][]string {
-	res := make(map[string][]string)
+// List all lists all the sections and their passwords from the storage, the passwords sorted. The storage must be unlocked if its names are encrypted.
+func (s *Storage) ListAll() (map[string][]string, error) {
+	if s.EncryptedNames && s.key == nil {
+		return nil, ErrNamesLocked
+	}
 
-	for k, v := range s.Sections {
+	layout, err := s.decodeLayout(s.key)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string][]string)
+	for k, v := range layout {
 		res[k] = make([]string, 0)
 		for pass, _ := range v {
 			res[k] = append(res[k], pass)
 		}
+		sort.Strings(res[k])
+	}
+
+	return res, nil
+}
+
+// HasSection tells whether the section exists. The storage must be unlocked if its names are encrypted, otherwise no section exists.
+func (s *Storage) HasSection(section string) bool {
+	stored, err := s.storedSection(section)
+	if err != nil {
+		return false
 	}
 
-	return res
+	_, ok := s.Sections[stored]
+	return ok
 }
 
-// AddSection adds a section to the storage.
+// AddSection adds a section to the storage. The storage must be unlocked if its names are encrypted.
 func (s *Storage) AddSection(section string) error {
-	if _, ok := s.Sections[section]; ok {
+	stored, err := s.storedSection(section)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := s.Sections[stored]; ok {
 		return fmt.Errorf("Section %s already exists", section)
 	}
 
-	s.Sections[section] = make(map[string]string)
+	if s.EncryptedNames {
+		if err = s.registerName(s.key, stored, section); err != nil {
+			return err
+		}
+	}
+
+	s.Sections[stored] = make(map[string]*Entry)
 	return nil
 }
 
-// Get retrieves an encrypted password from the storage. If the section or the password do not exist, an error is raised.
+// Get retrieves an encrypted password from the storage. If the section or the password do not exist, an error is raised. The storage must be unlocked if its names are encrypted.
 func (s *Storage) Get(section string, password string) (string, error) {
-	sec, ok := s.Sections[section]
+	entry, err := s.GetEntry(section, password)
+	if err != nil {
+		return "", err
+	}
+
+	return entry.Password, nil
+}
+
+// GetEntry retrieves an entry from the storage, its fields are still encrypted. If the section or the password do not exist, an error is raised. The storage must be unlocked if its names are encrypted.
+func (s *Storage) GetEntry(section string, password string) (*Entry, error) {
+	storedSec, err := s.storedSection(section)
+	if err != nil {
+		return nil, err
+	}
+
+	sec, ok := s.Sections[storedSec]
 
 	if !ok {
-		return "", fmt.Errorf("Section %s does not exists", section)
+		return nil, fmt.Errorf("Section %s does not exists", section)
+	}
+
+	storedPass, err := s.storedName(section, password)
+	if err != nil {
+		return nil, err
 	}
 
-	pass, ok := sec[password]
+	entry, ok := sec[storedPass]
 	if !ok {
-		return "", fmt.Errorf("Password %s does not exists", section)
+		return nil, fmt.Errorf("Password %s does not exists", password)
 	}
 
-	return pass, nil
+	return entry, nil
 }
 
-// Set puts a new encrypted password on the storage. Be extra-careful, it does not actually encrypts and encode it.
-func (s *Storage) Set(section string, password string, data string) {
-	sec, ok := s.Sections[section]
+// Set puts a new encrypted password on the storage. Be extra-careful, it does not actually encrypts and encode it. If the entry already exists, only its password and modification date change, the previous password is kept in its history. The storage must be unlocked if its names are encrypted.
+func (s *Storage) Set(section string, password string, data string) error {
+	entry, err := s.GetEntry(section, password)
+	if err != nil {
+		entry = &Entry{Created: time.Now().UTC()}
+	}
+
+	copied := *entry
+	copied.History = append([]Revision(nil), entry.History...)
+	copied.replacePassword(data)
+	return s.SetEntry(section, password, &copied)
+}
+
+// History returns the previous passwords of an entry, the most recent first.
+func (s *Storage) History(section string, password string) ([]Revision, error) {
+	entry, err := s.GetEntry(section, password)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry.History, nil
+}
+
+// Restore sets back a previous password of an entry, 1 being the most recent one. The current password is pushed to the history, so a restore can be undone.
+func (s *Storage) Restore(section string, password string, version int) error {
+	entry, err := s.GetEntry(section, password)
+	if err != nil {
+		return err
+	}
+
+	if version < 1 || version > len(entry.History) {
+		return fmt.Errorf("Password %s has no version %d", password, version)
+	}
+
+	copied := *entry
+	copied.History = append(append([]Revision(nil), entry.History[:version-1]...), entry.History[version:]...)
+	copied.replacePassword(entry.History[version-1].Password)
+	return s.SetEntry(section, password, &copied)
+}
+
+// SetEntry puts an entry on the storage, replacing the existing one if any. Be extra-careful, its fields must be encrypted already. The storage must be unlocked if its names are encrypted.
+func (s *Storage) SetEntry(section string, password string, entry *Entry) error {
+	if !s.HasSection(section) {
+		if err := s.AddSection(section); err != nil {
+			return err
+		}
+	}
+
+	storedSec, _ := s.storedSection(section)
+	storedPass, err := s.storedName(section, password)
+	if err != nil {
+		return err
+	}
+
+	if s.EncryptedNames {
+		if err = s.registerName(s.key, storedPass, password); err != nil {
+			return err
+		}
+	}
+
+	s.Sections[storedSec][storedPass] = entry
+	s.unbury(storedSec, storedPass)
+	return nil
+}
+
+// Delete removes a password from the storage. If the section or the password do not exist, an error is raised. The storage must be unlocked if its names are encrypted.
+func (s *Storage) Delete(section string, password string) error {
+	if _, err := s.Get(section, password); err != nil {
+		return err
+	}
+
+	storedSec, _ := s.storedSection(section)
+	storedPass, _ := s.storedName(section, password)
+	delete(s.Sections[storedSec], storedPass)
+	s.bury(storedSec, storedPass)
+	return nil
+}
+
+// DeleteSection removes a section and all of its passwords from the storage. If the section does not exist, an error is raised. The storage must be unlocked if its names are encrypted.
+func (s *Storage) DeleteSection(section string) error {
+	if !s.HasSection(section) {
+		return fmt.Errorf("Section %s does not exists", section)
+	}
+
+	stored, _ := s.storedSection(section)
+	for storedPass := range s.Sections[stored] {
+		s.bury(stored, storedPass)
+	}
+
+	// The names of the passwords are kept for their tombstones, and the one of the section as long as it has some
+	delete(s.Sections, stored)
+	if len(s.Deleted[stored]) == 0 {
+		delete(s.Names, stored)
+	}
+	return nil
+}
+
+// bury records the tombstone of a password just removed, under its stored section and name.
+func (s *Storage) bury(storedSec string, storedPass string) {
+	if s.Deleted == nil {
+		s.Deleted = make(map[string]map[string]time.Time)
+	}
+	if s.Deleted[storedSec] == nil {
+		s.Deleted[storedSec] = make(map[string]time.Time)
+	}
+	s.Deleted[storedSec][storedPass] = time.Now().UTC()
+}
+
+// unbury removes the tombstone of a password set again, if it has one.
+func (s *Storage) unbury(storedSec string, storedPass string) {
+	if _, ok := s.Deleted[storedSec][storedPass]; !ok {
+		return
+	}
+
+	delete(s.Deleted[storedSec], storedPass)
+	if len(s.Deleted[storedSec]) == 0 {
+		delete(s.Deleted, storedSec)
+	}
+	if len(s.Deleted) == 0 {
+		s.Deleted = nil
+	}
+}
+
+// Move moves a password to another section and/or name. As the section and name are authenticated along with the password, it is re-encrypted with the secret key. If the password does not exist, or if the destination already exists, an error is raised. The storage must be unlocked if its names are encrypted.
+func (s *Storage) Move(key []byte, fromSection string, fromName string, toSection string, toName string) error {
+	entry, err := s.GetEntry(fromSection, fromName)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.Get(toSection, toName); err == nil {
+		return fmt.Errorf("Password %s already exists in section %s", toName, toSection)
+	}
+
+	moved, err := s.reencrypt(entry, key, fromSection, fromName, key, toSection, toName)
+	if err != nil {
+		return err
+	}
+
+	if err = s.SetEntry(toSection, toName, moved); err != nil {
+		return err
+	}
+
+	return s.Delete(fromSection, fromName)
+}
+
+// Rename renames a password inside its section, see Move.
+func (s *Storage) Rename(key []byte, section string, oldName string, newName string) error {
+	return s.Move(key, section, oldName, section, newName)
+}
+
+// RenameSection renames a section, all of its passwords are re-encrypted with the secret key. If the section does not exist, or if the new one already exists, an error is raised. If there is an error during this operation, nothing is comitted. The storage must be unlocked if its names are encrypted.
+func (s *Storage) RenameSection(key []byte, oldSection string, newSection string) error {
+	layout, err := s.decodeLayout(key)
+	if err != nil {
+		return err
+	}
+
+	sec, ok := layout[oldSection]
 	if !ok {
-		sec = make(map[string]string)
-		s.Sections[section] = sec
+		return fmt.Errorf("Section %s does not exists", oldSection)
 	}
 
-	sec[password] = data
+	if _, ok := layout[newSection]; ok {
+		return fmt.Errorf("Section %s already exists", newSection)
+	}
 
+	tombstones, err := s.decodeTombstones(key)
+	if err != nil {
+		return err
+	}
+	if tombstones[oldSection] == nil {
+		tombstones[oldSection] = make(map[string]time.Time)
+	}
+
+	renamed := make(map[string]*Entry)
+	for k, v := range sec {
+		if renamed[k], err = s.reencrypt(v, key, oldSection, k, key, newSection, k); err != nil {
+			return err
+		}
+		tombstones[oldSection][k] = time.Now().UTC()
+		delete(tombstones[newSection], k)
+	}
+
+	delete(layout, oldSection)
+	layout[newSection] = renamed
+	if err = s.encodeLayout(key, layout); err != nil {
+		return err
+	}
+	return s.encodeTombstones(key, tombstones)
 }
@@ -0,0 +1,440 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Strength levels, from the entropy: the minimum number of bits of each one
+var strengthLevels = []struct {
+	Bits  float64
+	Label string
+}{
+	{80, "very strong"},
+	{60, "strong"},
+	{40, "fair"},
+	{28, "weak"},
+	{0, "very weak"},
+}
+
+// WeakEntropy is the entropy below which a password is weak, in bits: a stolen storage gives an attacker all the time needed, and guessing 2^40 passwords is within reach of a single computer.
+const WeakEntropy = 40
+
+// Strength is an estimate of how hard a password is to guess
+type Strength struct {
+	// Entropy in bits, the base 2 logarithm of the number of guesses needed to find the password
+	Entropy float64
+	// Weaknesses found in the password, like a common password or a keyboard pattern
+	Patterns []string
+}
+
+// Level describes the strength in a couple of words, from very weak to very strong
+func (s Strength) Level() string {
+	for _, level := range strengthLevels {
+		if s.Entropy >= level.Bits {
+			return level.Label
+		}
+	}
+
+	return strengthLevels[len(strengthLevels)-1].Label
+}
+
+// Weak tells whether the password is too easily guessed, see WeakEntropy
+func (s Strength) Weak() bool {
+	return s.Entropy < WeakEntropy
+}
+
+// String describes the strength in one line
+func (s Strength) String() string {
+	description := fmt.Sprintf("Strength: %.1f bits, %s", s.Entropy, s.Level())
+	if len(s.Patterns) > 0 {
+		description += " (" + strings.Join(s.Patterns, ", ") + ")"
+	}
+
+	return description
+}
+
+// RandomStrength returns the strength of a password whose characters are drawn uniformly and independently from a set
+func RandomStrength(charset int, length int) Strength {
+	if charset < 2 {
+		return Strength{}
+	}
+
+	return Strength{Entropy: float64(length) * math.Log2(float64(charset))}
+}
+
+// Strength returns the strength of the passwords of the given length generated from the alphabet
+func (a Alphabet) Strength(length int) Strength {
+	return RandomStrength(len(a.choices), length)
+}
+
+// Strength returns the strength of the passwords generated with the policy, from the number of passwords satisfying its minimums. The passwords breaking its maximum run are drawn again, so it is slightly overestimated for the strictest ones.
+func (p *Policy) Strength() Strength {
+	if p.Validate() != nil {
+		return RandomStrength(len(p.Charset()), p.Length)
+	}
+
+	groups, others := p.groups()
+	mant := new(big.Float)
+	exp := newPasswordCounter(groups, others).count(0, p.Length).MantExp(mant)
+	f, _ := mant.Float64()
+	return Strength{Entropy: math.Log2(f) + float64(exp)}
+}
+
+// Strength returns the strength of the generated passphrases, see Entropy
+func (d *Diceware) Strength() Strength {
+	return Strength{Entropy: d.Entropy()}
+}
+
+// The most common passwords of the leaks, the most common first. An attacker tries them before anything else.
+var commonPasswords = []string{
+	"123456", "password", "12345678", "qwerty", "123456789", "12345", "1234", "111111", "1234567", "dragon",
+	"123123", "baseball", "abc123", "football", "monkey", "letmein", "696969", "shadow", "master", "666666",
+	"qwertyuiop", "123321", "mustang", "1234567890", "michael", "654321", "superman", "1qaz2wsx", "7777777", "121212",
+	"000000", "qazwsx", "123qwe", "killer", "trustno1", "jordan", "jennifer", "zxcvbnm", "asdfgh", "hunter",
+	"buster", "soccer", "harley", "batman", "andrew", "tigger", "sunshine", "iloveyou", "2000", "charlie",
+	"robert", "thomas", "hockey", "ranger", "daniel", "starwars", "klaster", "112233", "george", "computer",
+	"michelle", "jessica", "pepper", "1111", "zxcvbn", "555555", "11111111", "131313", "freedom", "777777",
+	"pass", "maggie", "159753", "aaaaaa", "ginger", "princess", "joshua", "cheese", "amanda", "summer",
+	"love", "ashley", "nicole", "chelsea", "biteme", "matthew", "access", "yankees", "987654321", "dallas",
+	"austin", "thunder", "taylor", "matrix", "admin", "welcome", "login", "hello", "secret", "azerty",
+}
+
+// Rows of the usual keyboards, for the keyboard patterns
+var keyboardRows = []string{
+	"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./",
+	"azertyuiop", "qsdfghjklm", "wxcvbn", "qwertzuiop", "yxcvbnm",
+}
+
+// Substitutions of the l33t speak, undone before looking up the words
+var leetSubstitutions = map[rune]rune{
+	'4': 'a', '@': 'a', '8': 'b', '(': 'c', '3': 'e', '6': 'g', '1': 'i', '!': 'i',
+	'0': 'o', '$': 's', '5': 's', '7': 't', '+': 't', '2': 'z',
+}
+
+// Ranks of the words an attacker tries, computed once: the common passwords, then the words of the EFF list
+var strengthDictionary map[string]int
+var strengthDictionaryOnce sync.Once
+
+// Returns the rank of a word in the dictionary, 0 if it isn't there
+func dictionaryRank(word string) int {
+	strengthDictionaryOnce.Do(func() {
+		strengthDictionary = make(map[string]int)
+		for i, password := range commonPasswords {
+			strengthDictionary[password] = i + 1
+		}
+		for i, word := range EFFWordList() {
+			if _, ok := strengthDictionary[word]; !ok {
+				strengthDictionary[word] = len(commonPasswords) + i + 1
+			}
+		}
+	})
+
+	return strengthDictionary[word]
+}
+
+// A pattern found in the runes [start, end) of a password, and the entropy it leaves
+type strengthMatch struct {
+	start, end int
+	entropy    float64
+	pattern    string
+}
+
+// Returns the class of a character, empty if it is not an ASCII one
+func charClass(r rune) string {
+	for _, class := range Classes {
+		if strings.ContainsRune(classChars[class], r) {
+			return class
+		}
+	}
+
+	if r == ' ' {
+		return ClassSymbol
+	}
+	return ""
+}
+
+// Size of the set of characters an attacker tries for a character, from its class. Beyond ASCII, the usual accented letters are tried.
+func charsetSize(r rune) int {
+	if class := charClass(r); class != "" {
+		return len(classChars[class])
+	}
+
+	return 100
+}
+
+// Number of characters in which EstimateStrength looks for patterns, so that long passwords are estimated as fast as the others
+const estimatedLength = 128
+
+// EstimateStrength estimates the strength of a password which was not generated, like an imported one. In the spirit of zxcvbn, it looks for the patterns people use, common passwords and words (even reversed or in l33t speak), sequences, repeated characters, keyboard patterns and dates, and counts the guesses an attacker trying them first would need. The characters outside of any pattern are counted as random ones, as are all those after the first estimatedLength ones.
+func EstimateStrength(password string) Strength {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return Strength{Patterns: []string{"empty password"}}
+	}
+	rest := 0
+	if len(runes) > estimatedLength {
+		runes, rest = runes[:estimatedLength], len(runes)-estimatedLength
+	}
+
+	// The characters are guessed among all those of the classes found in the password
+	classes := make(map[string]bool)
+	pool := 0
+	for _, r := range password {
+		if class := charClass(r); !classes[class] {
+			classes[class] = true
+			pool += charsetSize(r)
+		}
+	}
+	random := math.Log2(float64(pool))
+
+	matches := dictionaryMatches(runes)
+	matches = append(matches, sequenceMatches(runes)...)
+	matches = append(matches, repeatMatches(runes)...)
+	matches = append(matches, keyboardMatches(runes)...)
+	matches = append(matches, dateMatches(runes)...)
+
+	// Finds the cheapest way to guess each prefix: random characters or patterns, a bit per pattern to choose it
+	ending := make([][]*strengthMatch, len(runes)+1)
+	for i := range matches {
+		ending[matches[i].end] = append(ending[matches[i].end], &matches[i])
+	}
+	best := make([]float64, len(runes)+1)
+	last := make([]*strengthMatch, len(runes)+1)
+	for end := 1; end <= len(runes); end++ {
+		best[end] = best[end-1] + random
+		last[end] = nil
+		for _, m := range ending[end] {
+			if best[m.start]+m.entropy+1 < best[end] {
+				best[end] = best[m.start] + m.entropy + 1
+				last[end] = m
+			}
+		}
+	}
+
+	var patterns []string
+	for end := len(runes); end > 0; {
+		if m := last[end]; m != nil {
+			patterns = append([]string{m.pattern}, patterns...)
+			end = m.start
+		} else {
+			end--
+		}
+	}
+
+	return Strength{Entropy: best[len(runes)] + float64(rest)*random, Patterns: patterns}
+}
+
+// Finds the common passwords and words, possibly capitalized, reversed or in l33t speak
+func dictionaryMatches(runes []rune) []strengthMatch {
+	var matches []strengthMatch
+	for start := 0; start < len(runes); start++ {
+		for end := start + 3; end <= len(runes) && end-start <= 20; end++ {
+			word := runes[start:end]
+
+			// Capitalizing the first letter or the whole word is the first thing tried, other cases cost a bit per upper case letter
+			upper := 0
+			for _, r := range word {
+				if unicode.IsUpper(r) {
+					upper++
+				}
+			}
+			variations := 0.0
+			if upper == len(word) || (upper == 1 && unicode.IsUpper(word[0])) {
+				variations = 1
+			} else if upper > 0 {
+				variations = float64(upper)
+			}
+
+			lower := strings.ToLower(string(word))
+			leet := 0
+			unleet := strings.Map(func(r rune) rune {
+				if sub, ok := leetSubstitutions[r]; ok {
+					leet++
+					return sub
+				}
+				return r
+			}, lower)
+			reversed := []rune(lower)
+			for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+				reversed[i], reversed[j] = reversed[j], reversed[i]
+			}
+
+			for _, candidate := range []struct {
+				word    string
+				extra   float64
+				pattern string
+			}{
+				{lower, 0, "%s %q"},
+				{unleet, float64(leet), "%s %q in l33t speak"},
+				{string(reversed), 1, "reversed %s %q"},
+			} {
+				if candidate.word == lower && candidate.pattern != "%s %q" {
+					continue
+				}
+				rank := dictionaryRank(candidate.word)
+				if rank == 0 {
+					continue
+				}
+
+				kind := "word"
+				if rank <= len(commonPasswords) {
+					kind = "common password"
+				}
+				matches = append(matches, strengthMatch{start, end, math.Log2(float64(rank)) + variations + candidate.extra, fmt.Sprintf(candidate.pattern, kind, candidate.word)})
+			}
+		}
+	}
+
+	return matches
+}
+
+// Finds the sequences of at least 3 characters, like abc or 987
+func sequenceMatches(runes []rune) []strengthMatch {
+	var matches []strengthMatch
+	for start := 0; start+2 < len(runes); {
+		step := runes[start+1] - runes[start]
+		end := start + 1
+		if step == 1 || step == -1 {
+			for end+1 < len(runes) && runes[end+1]-runes[end] == step && charClass(runes[end+1]) == charClass(runes[start]) {
+				end++
+			}
+		}
+
+		if end-start+1 >= 3 {
+			entropy := math.Log2(float64(charsetSize(runes[start]))) + math.Log2(float64(end-start+1))
+			if step < 0 {
+				entropy++
+			}
+			matches = append(matches, strengthMatch{start, end + 1, entropy, fmt.Sprintf("sequence %q", string(runes[start:end+1]))})
+			start = end
+		} else {
+			start++
+		}
+	}
+
+	return matches
+}
+
+// Finds the characters repeated at least 3 times in a row
+func repeatMatches(runes []rune) []strengthMatch {
+	var matches []strengthMatch
+	for start := 0; start < len(runes); {
+		end := start + 1
+		for end < len(runes) && runes[end] == runes[start] {
+			end++
+		}
+
+		if end-start >= 3 {
+			entropy := math.Log2(float64(charsetSize(runes[start]))) + math.Log2(float64(end-start))
+			matches = append(matches, strengthMatch{start, end, entropy, fmt.Sprintf("repeated %q", string(runes[start:end]))})
+		}
+		start = end
+	}
+
+	return matches
+}
+
+// Finds the runs of at least 4 neighbouring keys of a keyboard row, like qwer or lkjh
+func keyboardMatches(runes []rune) []strengthMatch {
+	keys, longest := 0, 0
+	for _, row := range keyboardRows {
+		keys += len(row)
+		if len(row) > longest {
+			longest = len(row)
+		}
+	}
+
+	lower := []rune(strings.ToLower(string(runes)))
+	var matches []strengthMatch
+	for start := 0; start+3 < len(lower); start++ {
+		// A run longer than the longest row can't be in one
+		end := start + longest
+		if end > len(lower) {
+			end = len(lower)
+		}
+		for ; end-start >= 4; end-- {
+			run := string(lower[start:end])
+			reversed := []rune(run)
+			for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+				reversed[i], reversed[j] = reversed[j], reversed[i]
+			}
+
+			found := false
+			for _, row := range keyboardRows {
+				if strings.Contains(row, run) || strings.Contains(row, string(reversed)) {
+					found = true
+					break
+				}
+			}
+
+			if found {
+				entropy := math.Log2(float64(keys)) + math.Log2(float64(end-start)) + 1
+				matches = append(matches, strengthMatch{start, end, entropy, fmt.Sprintf("keyboard pattern %q", string(runes[start:end]))})
+				break
+			}
+		}
+	}
+
+	return matches
+}
+
+// Finds the years from 1900 to 2099, and the dates: day, month and year in any usual order, with 8 digits or separated by one of /-. and space
+func dateMatches(runes []rune) []strengthMatch {
+	number := func(digits []rune) int {
+		n := 0
+		for _, r := range digits {
+			if r < '0' || r > '9' {
+				return -1
+			}
+			n = n*10 + int(r-'0')
+		}
+		return n
+	}
+	year := func(digits []rune) bool {
+		n := number(digits)
+		return n >= 1900 && n <= 2099
+	}
+	dayMonth := func(day, month []rune) bool {
+		d, m := number(day), number(month)
+		return d >= 1 && d <= 31 && m >= 1 && m <= 12
+	}
+	date := func(d []rune) bool {
+		return (year(d[4:]) && (dayMonth(d[:2], d[2:4]) || dayMonth(d[2:4], d[:2]))) || (year(d[:4]) && dayMonth(d[6:], d[4:6]))
+	}
+	separator := func(a, b rune) bool {
+		return a == b && strings.ContainsRune("/-. ", a)
+	}
+	entropy := math.Log2(31 * 12 * 200 * 3)
+
+	var matches []strengthMatch
+	for start := 0; start+4 <= len(runes); start++ {
+		if year(runes[start : start+4]) {
+			matches = append(matches, strengthMatch{start, start + 4, math.Log2(200), fmt.Sprintf("year %s", string(runes[start:start+4]))})
+		}
+
+		if start+8 <= len(runes) && date(runes[start:start+8]) {
+			matches = append(matches, strengthMatch{start, start + 8, entropy, fmt.Sprintf("date %s", string(runes[start:start+8]))})
+		}
+
+		if start+10 > len(runes) {
+			continue
+		}
+		d := runes[start : start+10]
+		var digits []rune
+		if separator(d[2], d[5]) {
+			digits = append(append(append(digits, d[:2]...), d[3:5]...), d[6:]...)
+		} else if separator(d[4], d[7]) {
+			digits = append(append(append(digits, d[:4]...), d[5:7]...), d[8:]...)
+		}
+		if digits != nil && date(digits) {
+			matches = append(matches, strengthMatch{start, start + 10, entropy + 2, fmt.Sprintf("date %s", string(d))})
+		}
+	}
+
+	return matches
+}
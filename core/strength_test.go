@@ -0,0 +1,49 @@
+package core
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEstimateStrengthPatterns(t *testing.T) {
+	tests := []struct {
+		password string
+		pattern  string
+	}{
+		{"7][poiuy7", `keyboard pattern "][poiuy"`},
+		{"x;lkjhgfdsay", `keyboard pattern ";lkjhgfdsa"`},
+		{"abcdefgh", `sequence "abcdefgh"`},
+		{"zzzzzz", `repeated "zzzzzz"`},
+		{"P@ssw0rd1987", `year 1987`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.password, func(t *testing.T) {
+			strength := EstimateStrength(test.password)
+			found := false
+			for _, pattern := range strength.Patterns {
+				found = found || pattern == test.pattern
+			}
+			if !found {
+				t.Fatalf("EstimateStrength found %v instead of %s", strength.Patterns, test.pattern)
+			}
+		})
+	}
+}
+
+// Long passwords are estimated as fast as the others, the characters beyond those searched for patterns counting as random ones
+func TestEstimateStrengthLong(t *testing.T) {
+	password := strings.Repeat("qwertyuiop[]\\asdfghjkl;'zxcvbnm,./Password1987", 1000/45+1)[:1000]
+
+	start := time.Now()
+	strength := EstimateStrength(password)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("EstimateStrength took %s for %d characters", elapsed, len(password))
+	}
+
+	head := EstimateStrength(password[:estimatedLength])
+	if strength.Entropy <= head.Entropy {
+		t.Fatalf("EstimateStrength returned %f bits for %d characters, and %f for the first %d", strength.Entropy, len(password), head.Entropy, estimatedLength)
+	}
+}
@@ -0,0 +1,295 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// The storage is synchronized through a bare git repository next to the master file, driven with the git binary. Each version of the master file is a commit holding it as its only file, so git never sees the names or passwords in clear, only the storage as written on the disk.
+// As the repository is bare, it is never checked out: the master file stays the only copy mpm reads, and git is only asked for commits and blobs.
+
+// Name of the master file in the commits, the name of the remote, and the default branch
+const (
+	syncFile          = "vault.json"
+	syncRemote        = "origin"
+	syncDefaultBranch = "main"
+)
+
+// ErrSyncRejected is raised when the remote refused a push, because it received other changes meanwhile.
+var ErrSyncRejected = errors.New("The remote has changes you don't have yet")
+
+// SyncRepo is the git repository synchronizing the master file, see OpenSync.
+type SyncRepo struct {
+	// Path of the bare repository, and the branch holding the storage
+	dir    string
+	branch string
+}
+
+// syncDir returns the path of the repository, next to the master file.
+func syncDir() string {
+	return fileName + ".git"
+}
+
+// OpenSync opens the repository synchronizing the master file. It is nil if the synchronization has not been set up, see InitSync.
+func OpenSync() (*SyncRepo, error) {
+	if _, err := os.Stat(syncDir()); os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, fmt.Errorf("git is needed to synchronize your storage: %s", err)
+	}
+
+	repo := &SyncRepo{dir: syncDir(), branch: syncDefaultBranch}
+	if branch, err := repo.git(nil, "config", "--get", "mpm.branch"); err == nil && branch != "" {
+		repo.branch = branch
+	}
+	return repo, nil
+}
+
+// InitSync creates the repository synchronizing the master file, on the given branch. The remote can be empty, and set later with SetRemote. If git doesn't know who the user is, commits are signed with the name of the machine.
+func InitSync(remote string, branch string) (*SyncRepo, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, fmt.Errorf("git is needed to synchronize your storage: %s", err)
+	}
+	if _, err := os.Stat(syncDir()); err == nil {
+		return nil, fmt.Errorf("The synchronization of %s is already set up in %s", fileName, syncDir())
+	}
+	if branch == "" {
+		branch = syncDefaultBranch
+	}
+
+	repo := &SyncRepo{dir: syncDir(), branch: branch}
+	if err := runGit(nil, "init", "--quiet", "--bare", repo.dir); err != nil {
+		return nil, err
+	}
+	if _, err := repo.git(nil, "config", "mpm.branch", branch); err != nil {
+		return nil, err
+	}
+	if _, err := repo.git(nil, "symbolic-ref", "HEAD", "refs/heads/"+branch); err != nil {
+		return nil, err
+	}
+
+	if email, err := repo.git(nil, "config", "--get", "user.email"); err != nil || email == "" {
+		host, _ := os.Hostname()
+		if host == "" {
+			host = "localhost"
+		}
+		if _, err = repo.git(nil, "config", "user.name", "mpm"); err != nil {
+			return nil, err
+		}
+		if _, err = repo.git(nil, "config", "user.email", "mpm@"+host); err != nil {
+			return nil, err
+		}
+	}
+
+	if remote != "" {
+		if err := repo.SetRemote(remote); err != nil {
+			return nil, err
+		}
+	}
+	return repo, nil
+}
+
+// Dir returns the path of the repository.
+func (r *SyncRepo) Dir() string {
+	return r.dir
+}
+
+// Branch returns the branch holding the storage, on both sides.
+func (r *SyncRepo) Branch() string {
+	return r.branch
+}
+
+// Remote returns the URL of the remote, empty if none is set.
+func (r *SyncRepo) Remote() string {
+	remote, err := r.git(nil, "config", "--get", "remote."+syncRemote+".url")
+	if err != nil {
+		return ""
+	}
+	return remote
+}
+
+// SetRemote sets the URL of the remote, replacing the previous one.
+func (r *SyncRepo) SetRemote(url string) error {
+	if r.Remote() != "" {
+		_, err := r.git(nil, "remote", "set-url", syncRemote, url)
+		return err
+	}
+	_, err := r.git(nil, "remote", "add", syncRemote, url)
+	return err
+}
+
+// Head returns the last commit of the branch, empty if nothing has been committed yet.
+func (r *SyncRepo) Head() string {
+	return r.revision("refs/heads/" + r.branch)
+}
+
+// RemoteHead returns the last commit of the branch on the remote, as of the last Fetch. It is empty if the remote doesn't have it.
+func (r *SyncRepo) RemoteHead() string {
+	return r.revision("refs/remotes/" + syncRemote + "/" + r.branch)
+}
+
+// Resolves a reference to its commit, empty if it doesn't exist
+func (r *SyncRepo) revision(ref string) string {
+	commit, err := r.git(nil, "rev-parse", "--quiet", "--verify", ref+"^{commit}")
+	if err != nil {
+		return ""
+	}
+	return commit
+}
+
+// Commit records the master file on the branch, with the given message. The other parents are the commits merged into it, if any. Nothing is committed if the master file didn't change since the last commit and there is nothing merged, the returned boolean tells whether a commit was made.
+func (r *SyncRepo) Commit(message string, merged ...string) (bool, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return false, err
+	}
+
+	blob, err := r.git(data, "hash-object", "-w", "--stdin")
+	if err != nil {
+		return false, err
+	}
+	tree, err := r.git([]byte(fmt.Sprintf("100644 blob %s\t%s\n", blob, syncFile)), "mktree")
+	if err != nil {
+		return false, err
+	}
+
+	head := r.Head()
+	if head != "" && len(merged) == 0 {
+		if headTree, err := r.git(nil, "rev-parse", head+"^{tree}"); err == nil && headTree == tree {
+			return false, nil
+		}
+	}
+
+	args := []string{"commit-tree", tree, "-m", message}
+	for _, parent := range append([]string{head}, merged...) {
+		if parent != "" {
+			args = append(args, "-p", parent)
+		}
+	}
+	commit, err := r.git(nil, args...)
+	if err != nil {
+		return false, err
+	}
+
+	return true, r.SetHead(commit, head)
+}
+
+// SetHead moves the branch to the given commit, as long as it is still at the previous one (empty if it didn't exist).
+func (r *SyncRepo) SetHead(commit string, previous string) error {
+	args := []string{"update-ref", "-m", "mpm", "refs/heads/" + r.branch, commit}
+	if previous != "" {
+		args = append(args, previous)
+	}
+	_, err := r.git(nil, args...)
+	return err
+}
+
+// Fetch retrieves the branches of the remote.
+func (r *SyncRepo) Fetch() error {
+	if r.Remote() == "" {
+		return fmt.Errorf("No remote is set, set one with 'mpm sync remote <url>'")
+	}
+	_, err := r.git(nil, "fetch", "--quiet", "--prune", syncRemote)
+	return err
+}
+
+// Push sends the branch to the remote. ErrSyncRejected is raised if the remote received other changes since the last Fetch.
+func (r *SyncRepo) Push() error {
+	ref := "refs/heads/" + r.branch
+	_, err := r.git(nil, "push", "--quiet", "--porcelain", syncRemote, ref+":"+ref)
+	if err != nil && (strings.Contains(err.Error(), "[rejected]") || strings.Contains(err.Error(), "non-fast-forward") || strings.Contains(err.Error(), "fetch first")) {
+		return ErrSyncRejected
+	}
+	return err
+}
+
+// IsAncestor tells whether the first commit is an ancestor of the second one, or the same commit.
+func (r *SyncRepo) IsAncestor(ancestor string, commit string) bool {
+	_, err := r.git(nil, "merge-base", "--is-ancestor", ancestor, commit)
+	return err == nil
+}
+
+// MergeBase returns the best common ancestor of two commits, empty if they have none.
+func (r *SyncRepo) MergeBase(a string, b string) string {
+	base, err := r.git(nil, "merge-base", a, b)
+	if err != nil {
+		return ""
+	}
+	return base
+}
+
+// ReadStorage reads the storage recorded by a commit, the same way GetStorage reads the master file. Dumping it on the disk replaces the master file, as long as it does not change in the meantime.
+func (r *SyncRepo) ReadStorage(commit string) (*Storage, error) {
+	// The blob is read as is, its trailing new lines are part of its version
+	var data bytes.Buffer
+	if err := gitCommand(nil, &data, "--git-dir", r.dir, "cat-file", "blob", commit+":"+syncFile); err != nil {
+		return nil, err
+	}
+
+	storage, err := parseStorage(data.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	// The storage replaces the current master file
+	if storage.version, err = fileVersion(fileName); err != nil {
+		return nil, err
+	}
+	return storage, nil
+}
+
+// Runs a git command on the repository, and returns its output without the trailing new line
+func (r *SyncRepo) git(stdin []byte, args ...string) (string, error) {
+	var out bytes.Buffer
+	if err := gitCommand(stdin, &out, append([]string{"--git-dir", r.dir}, args...)...); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(out.String(), "\n"), nil
+}
+
+// Runs a git command outside of the repository
+func runGit(stdin []byte, args ...string) error {
+	var out bytes.Buffer
+	return gitCommand(stdin, &out, args...)
+}
+
+// Runs git with the given arguments, its messages are in English so that they can be recognized. The error holds what git printed, if it failed.
+func gitCommand(stdin []byte, out *bytes.Buffer, args ...string) error {
+	var stderr bytes.Buffer
+	command := exec.Command("git", args...)
+	command.Env = append(os.Environ(), "LC_ALL=C", "GIT_TERMINAL_PROMPT=0")
+	command.Stdin = bytes.NewReader(stdin)
+	command.Stdout = out
+	command.Stderr = &stderr
+
+	if err := command.Run(); err != nil {
+		// The porcelain output of push tells why a reference was refused
+		message := strings.TrimSpace(stderr.String() + "\n" + out.String())
+		if message == "" {
+			message = err.Error()
+		}
+		return fmt.Errorf("git %s failed: %s", args[firstGitCommand(args)], message)
+	}
+	return nil
+}
+
+// Index of the git command in the arguments, after the options of git itself
+func firstGitCommand(args []string) int {
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--git-dir" {
+			i++
+		} else if !strings.HasPrefix(args[i], "-") {
+			return i
+		}
+	}
+	return 0
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// Environment variable selecting the vault, either a registered name or a path
+const VaultEnv = "MPM_VAULT"
+
+// Path to the master file, see SetVaultPath
+var fileName string
+
+// SetVaultPath selects the master file used by GetStorage, DumpOnDisk and their friends. It must be called before any of them.
+func SetVaultPath(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	fileName = abs
+	return nil
+}
+
+// VaultPath returns the path of the selected master file.
+func VaultPath() string {
+	return fileName
+}
+
+// DefaultVaultPath returns the path of the master file when none has been chosen, $HOME/.mpm.
+func DefaultVaultPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.New("Your home directory can't be found, choose a vault with --vault or $" + VaultEnv)
+	}
+
+	return filepath.Join(home, ".mpm"), nil
+}
+
+// ResolveVault finds the path of the master file to use. The reference given on the command line comes first, then $MPM_VAULT, then the vault in use in the registry, and finally the default one. References are either names from the registry or paths.
+func ResolveVault(ref string) (string, error) {
+	if ref == "" {
+		ref = os.Getenv(VaultEnv)
+	}
+
+	config, err := LoadConfig()
+	if err != nil {
+		return "", err
+	}
+
+	if ref == "" {
+		ref = config.Current
+	}
+
+	if ref == "" {
+		return DefaultVaultPath()
+	}
+
+	if path, ok := config.Vaults[ref]; ok {
+		return path, nil
+	}
+
+	return ref, nil
+}
@@ -0,0 +1,154 @@
+package core
+
+import (
+	"bufio"
+	"crypto/rand"
+	_ "embed"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The large word list of the EFF, made for diceware: 7776 words, one for each roll of five dice. See https://www.eff.org/dice
+//
+//go:embed eff_large_wordlist.txt
+var effLargeWordList string
+
+// A WordList is a list of distinct words to draw passphrases from
+type WordList []string
+
+// EFFWordList returns the large word list of the EFF, embedded in mpm
+func EFFWordList() WordList {
+	list, err := ParseWordList(strings.NewReader(effLargeWordList))
+	if err != nil {
+		panic(err)
+	}
+
+	return list
+}
+
+// ParseWordList reads a word list, one word per line. A line may start with its dice roll, like in the EFF lists: only its last field is kept. Empty lines are skipped, duplicated words are refused since they would weaken the passphrases.
+func ParseWordList(reader io.Reader) (WordList, error) {
+	var list WordList
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		word := fields[len(fields)-1]
+		if seen[word] {
+			return nil, fmt.Errorf("Word %s appears twice, on line %d", word, line)
+		}
+		seen[word] = true
+		list = append(list, word)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(list) < 2 {
+		return nil, fmt.Errorf("A word list needs at least 2 words, found %d", len(list))
+	}
+
+	return list, nil
+}
+
+// LoadWordList reads a word list from a file, see ParseWordList
+func LoadWordList(path string) (WordList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseWordList(file)
+}
+
+// Diceware generates passphrases made of random words
+type Diceware struct {
+	List WordList
+	// Number of words
+	Words int
+	// Put between the words
+	Separator string
+	// Whether the first letter of each word is in upper case
+	Capitalize bool
+	// Whether a random digit is appended to one of the words
+	Digit bool
+}
+
+// Returns a uniformly random number in [0, max)
+func randomInt(max int) (int, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n.Int64()), nil
+}
+
+// Generate draws a passphrase
+func (d *Diceware) Generate() (string, error) {
+	if d.Words < 1 {
+		return "", fmt.Errorf("A passphrase needs at least one word, received %d", d.Words)
+	}
+
+	words := make([]string, d.Words)
+	for i := range words {
+		n, err := randomInt(len(d.List))
+		if err != nil {
+			return "", err
+		}
+
+		words[i] = d.List[n]
+		if d.Capitalize {
+			first, size := utf8.DecodeRuneInString(words[i])
+			words[i] = string(unicode.ToUpper(first)) + words[i][size:]
+		}
+	}
+
+	if d.Digit {
+		position, err := randomInt(len(words))
+		if err != nil {
+			return "", err
+		}
+		digit, err := randomInt(10)
+		if err != nil {
+			return "", err
+		}
+
+		words[position] += fmt.Sprint(digit)
+	}
+
+	return strings.Join(words, d.Separator), nil
+}
+
+// Entropy returns the entropy of the generated passphrases in bits, assuming the attacker knows the word list and the options. Capitalizing adds nothing, the digit adds the choice of its value and of its word.
+func (d *Diceware) Entropy() float64 {
+	entropy := float64(d.Words) * math.Log2(float64(len(d.List)))
+	if d.Digit {
+		entropy += math.Log2(10) + math.Log2(float64(d.Words))
+	}
+
+	return entropy
+}
+
+// Report describes the strength of the generated passphrases in one line
+func (d *Diceware) Report() string {
+	report := fmt.Sprintf("Entropy: %.1f bits, %d words from a list of %d", d.Entropy(), d.Words, len(d.List))
+	if d.Digit {
+		report += " and a digit"
+	}
+
+	return report
+}